@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"snippetbox/internal/models"
+	"snippetbox/internal/validator"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type apiSnippetInput struct {
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	Tags       []string `json:"tags"`
+	Language   string   `json:"language"`
+	Visibility string   `json:"visibility"`
+	Expires    int      `json:"expires"`
+}
+
+// form runs the input through the same validation as the HTML forms.
+func (in apiSnippetInput) form() snippetCreateForm {
+	form := snippetCreateForm{
+		Title:      in.Title,
+		Content:    in.Content,
+		Tags:       strings.Join(in.Tags, " "),
+		Language:   in.Language,
+		Visibility: in.Visibility,
+		Expires:    in.Expires,
+	}
+	form.validate()
+	return form
+}
+
+type apiSnippetUpdateInput struct {
+	Title    string   `json:"title"`
+	Content  string   `json:"content"`
+	Tags     []string `json:"tags"`
+	Language string   `json:"language"`
+}
+
+type apiCommentInput struct {
+	Content  string `json:"content"`
+	Markdown bool   `json:"markdown"`
+	// ParentID is the ID of the comment replied to, if any. It is ignored
+	// when editing.
+	ParentID string `json:"parent_id"`
+}
+
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	q, err := app.pageQuery(r)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	var page models.Page
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		page, err = app.snippets.ByTag(strings.ToLower(tag), q)
+	} else {
+		page, err = app.snippets.Latest(q)
+	}
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, envelope{
+		"snippets": newAPISnippets(page.Snippets),
+		"next":     page.Next,
+		"prev":     page.Prev,
+	})
+}
+
+func (app *application) apiSnippetGet(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	snippet, err := app.snippets.GetVisible(id, apiViewerID(r))
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippet": newAPISnippet(snippet)})
+}
+
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var in apiSnippetInput
+	err := app.readJSON(w, r, &in)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	form := in.form()
+	if !form.Valid() {
+		app.apiValidationError(w, r, form.Validator)
+		return
+	}
+	user, _ := apiUserFrom(r)
+	id, err := app.snippets.Insert(form.Title, form.Content, form.tags(), form.language(), form.Visibility, user.Name, user.ID.Hex(), form.Expires)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/snippets/%s", id.Hex()))
+	app.writeJSON(w, r, http.StatusCreated, envelope{"snippet": newAPISnippet(snippet)})
+}
+
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	var in apiSnippetUpdateInput
+	err := app.readJSON(w, r, &in)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	form := apiSnippetInput{Title: in.Title, Content: in.Content, Tags: in.Tags, Language: in.Language}.form()
+	if !form.Valid() {
+		app.apiValidationError(w, r, form.Validator)
+		return
+	}
+	user, _ := apiUserFrom(r)
+	err = app.snippets.Update(id, user.ID.Hex(), form.Title, form.Content, form.tags(), form.language())
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippet": newAPISnippet(snippet)})
+}
+
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	user, _ := apiUserFrom(r)
+	err := app.snippets.Delete(id, user.ID.Hex())
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) apiFavouriteAdd(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	user, _ := apiUserFrom(r)
+	err := app.users.AddFavourites(id, user.ID)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) apiFavouriteRemove(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	user, _ := apiUserFrom(r)
+	err = app.users.RemoveFavourites(snippet, id, user.ID)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) apiCommentList(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	q, err := app.commentQuery(r)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	_, err = app.snippets.GetVisible(id, apiViewerID(r))
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	page, err := app.commentary.Commentaries(id, q)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, envelope{
+		"comments": newAPIComments(page.Commentaries),
+		"next":     page.Next,
+		"prev":     page.Prev,
+	})
+}
+
+func (app *application) apiCommentCreate(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	var in apiCommentInput
+	err := app.readJSON(w, r, &in)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	var parentID primitive.ObjectID
+	var v validator.Validator
+	v.CheckField(validator.NotBlank(in.Content), "content", "This field cannot be blank")
+	if in.ParentID != "" {
+		parentID, err = primitive.ObjectIDFromHex(in.ParentID)
+		v.CheckField(err == nil, "parent_id", "This field must be a comment ID")
+	}
+	if !v.Valid() {
+		app.apiValidationError(w, r, v)
+		return
+	}
+	user, _ := apiUserFrom(r)
+	_, err = app.snippets.GetVisible(id, user.ID.Hex())
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	author := map[string]string{user.Name: user.ID.Hex()}
+	commentID, err := app.commentary.AddComentary(id, parentID, author, in.Content, in.Markdown)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	comment := models.Commentary{ID: commentID, ParentID: parentID, Author: author, Content: in.Content, Markdown: in.Markdown, Created: time.Now().UTC()}
+	app.writeJSON(w, r, http.StatusCreated, envelope{"comment": newAPIComments([]models.Commentary{comment})[0]})
+}
+
+// apiCommentUpdate changes the content of a comment, which only its author
+// and the author of the post may do.
+func (app *application) apiCommentUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	commentID, ok := apiIDParam(r, "comment")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	var in apiCommentInput
+	err := app.readJSON(w, r, &in)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	var v validator.Validator
+	v.CheckField(validator.NotBlank(in.Content), "content", "This field cannot be blank")
+	if !v.Valid() {
+		app.apiValidationError(w, r, v)
+		return
+	}
+	user, _ := apiUserFrom(r)
+	err = app.commentary.UpdateComentary(id, commentID, user.ID.Hex(), in.Content, in.Markdown)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	comment, err := app.commentary.Commentary(id, commentID)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, envelope{"comment": newAPIComments([]models.Commentary{comment})[0]})
+}
+
+// apiCommentDelete deletes a comment with the replies to it.
+func (app *application) apiCommentDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	commentID, ok := apiIDParam(r, "comment")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	user, _ := apiUserFrom(r)
+	err := app.commentary.DeleteComentary(id, commentID, user.ID.Hex())
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiUserGet returns a user's profile and a page of their posts. The email
+// address is only shown to the user themselves.
+func (app *application) apiUserGet(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(r, "id")
+	if !ok {
+		app.apiNotFound(w, r)
+		return
+	}
+	q, err := app.pageQuery(r)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, err := app.users.Get(id, apiViewerID(r), q)
+	if err != nil {
+		app.apiStoreError(w, r, err)
+		return
+	}
+	out := apiUser{
+		ID:      user.ID.Hex(),
+		Name:    user.Name,
+		Created: user.Created,
+		Posts:   newAPISnippets(user.CreatedSnippets),
+		Next:    user.CreatedNext,
+		Prev:    user.CreatedPrev,
+	}
+	if current, ok := apiUserFrom(r); ok && current.ID == user.ID {
+		out.Email = user.Email
+	}
+	app.writeJSON(w, r, http.StatusOK, envelope{"user": out})
+}
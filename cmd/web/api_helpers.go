@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"snippetbox/internal/models"
+	"snippetbox/internal/validator"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxAPIBodyBytes bounds the size of API request bodies.
+const maxAPIBodyBytes = 1 << 20
+
+// envelope is the top level object of every API response.
+type envelope map[string]any
+
+// apiErrorBody is the value of the "error" key of an error response. Fields
+// holds per-field messages when a request failed validation.
+type apiErrorBody struct {
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+type apiAuthor struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type apiSnippet struct {
+	ID         string     `json:"id"`
+	Title      string     `json:"title"`
+	Content    string     `json:"content"`
+	Tags       []string   `json:"tags"`
+	Language   string     `json:"language"`
+	Visibility string     `json:"visibility"`
+	Author     apiAuthor  `json:"author"`
+	Created    time.Time  `json:"created"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	Favourited int        `json:"favourited"`
+	Commented  int        `json:"commented"`
+	ForkedFrom string     `json:"forked_from,omitempty"`
+}
+
+type apiComment struct {
+	ID       string     `json:"id"`
+	ParentID string     `json:"parent_id,omitempty"`
+	Author   apiAuthor  `json:"author"`
+	Content  string     `json:"content"`
+	Markdown bool       `json:"markdown"`
+	Created  time.Time  `json:"created"`
+	Edited   *time.Time `json:"edited,omitempty"`
+}
+
+type apiUser struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	Email   string       `json:"email,omitempty"`
+	Created time.Time    `json:"created"`
+	Posts   []apiSnippet `json:"posts"`
+	Next    string       `json:"next,omitempty"`
+	Prev    string       `json:"prev,omitempty"`
+}
+
+func authorOf(author map[string]string) apiAuthor {
+	for name, id := range author {
+		return apiAuthor{ID: id, Name: name}
+	}
+	return apiAuthor{}
+}
+
+func newAPISnippet(s models.Snippet) apiSnippet {
+	out := apiSnippet{
+		ID:         s.ID.Hex(),
+		Title:      s.Title,
+		Content:    s.Content,
+		Tags:       s.Tags,
+		Language:   s.Language,
+		Visibility: s.Visibility,
+		Author:     authorOf(s.Author),
+		Created:    s.Created,
+		Favourited: s.Favourited,
+		Commented:  s.Commented,
+	}
+	if out.Tags == nil {
+		out.Tags = []string{}
+	}
+	if out.Visibility == "" {
+		out.Visibility = models.VisibilityPublic
+	}
+	if !s.Expires.IsZero() {
+		out.Expires = &s.Expires
+	}
+	if !s.ForkedFrom.IsZero() {
+		out.ForkedFrom = s.ForkedFrom.Hex()
+	}
+	return out
+}
+
+func newAPISnippets(snippets []models.Snippet) []apiSnippet {
+	out := make([]apiSnippet, len(snippets))
+	for i, s := range snippets {
+		out[i] = newAPISnippet(s)
+	}
+	return out
+}
+
+func newAPIComments(commentaries []models.Commentary) []apiComment {
+	out := make([]apiComment, len(commentaries))
+	for i, c := range commentaries {
+		out[i] = apiComment{ID: c.ID.Hex(), Author: authorOf(c.Author), Content: c.Content, Markdown: c.Markdown, Created: c.Created}
+		if !c.ParentID.IsZero() {
+			out[i].ParentID = c.ParentID.Hex()
+		}
+		if !c.Edited.IsZero() {
+			edited := c.Edited
+			out[i].Edited = &edited
+		}
+	}
+	return out
+}
+
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// readJSON decodes a single JSON object from the request body into dst,
+// rejecting unknown fields and trailing data.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		var invalidUnmarshalError *json.InvalidUnmarshalError
+		switch {
+		case errors.As(err, &invalidUnmarshalError):
+			panic(err)
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		}
+		return fmt.Errorf("body is not valid JSON: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return errors.New("body must only contain a single JSON value")
+	}
+	return nil
+}
+
+func (app *application) apiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	app.writeJSON(w, r, status, envelope{"error": apiErrorBody{Message: message}})
+}
+
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	app.apiError(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+func (app *application) apiInvalidToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	app.apiError(w, r, http.StatusUnauthorized, "invalid or revoked API token")
+}
+
+func (app *application) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	app.apiError(w, r, http.StatusNotFound, "the requested resource could not be found")
+}
+
+// apiValidationError reports the errors collected by v.
+func (app *application) apiValidationError(w http.ResponseWriter, r *http.Request, v validator.Validator) {
+	message := "the request failed validation"
+	if len(v.NonFieldErrors) > 0 {
+		message = strings.Join(v.NonFieldErrors, "; ")
+	}
+	app.writeJSON(w, r, http.StatusUnprocessableEntity, envelope{"error": apiErrorBody{Message: message, Fields: v.FieldErrors}})
+}
+
+// apiStoreError maps the model errors shared by the API handlers to
+// responses, and anything else to a server error.
+func (app *application) apiStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		app.apiNotFound(w, r)
+	case errors.Is(err, models.ErrNotAuthor):
+		app.apiError(w, r, http.StatusForbidden, "only the author can change this post")
+	case errors.Is(err, models.ErrAlreadyFavourite):
+		app.apiError(w, r, http.StatusConflict, "the post is already in favourites")
+	case errors.Is(err, models.ErrInvalidCursor):
+		app.apiError(w, r, http.StatusBadRequest, "invalid pagination cursor")
+	default:
+		app.apiServerError(w, r, err)
+	}
+}
+
+// apiIDParam parses the ObjectID in the named URL parameter.
+func apiIDParam(r *http.Request, name string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(httprouter.ParamsFromContext(r.Context()).ByName(name))
+	return id, err == nil
+}
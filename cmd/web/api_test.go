@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models/mocks"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAPISnippetRead(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "List",
+			urlPath:  "/api/v1/snippets",
+			wantCode: http.StatusOK,
+			wantBody: `"title":"An old silent pond"`,
+		},
+		{
+			name:     "List by tag",
+			urlPath:  "/api/v1/snippets?tag=limerick",
+			wantCode: http.StatusOK,
+			wantBody: `"snippets":[]`,
+		},
+		{
+			name:     "Bad sort",
+			urlPath:  "/api/v1/snippets?sort=oldest",
+			wantCode: http.StatusBadRequest,
+			wantBody: `"error":{"message":`,
+		},
+		{
+			name:     "Get",
+			urlPath:  "/api/v1/snippets/" + mocks.MockSnippet.ID.Hex(),
+			wantCode: http.StatusOK,
+			wantBody: `"tags":["haiku"]`,
+		},
+		{
+			name:     "Get missing",
+			urlPath:  "/api/v1/snippets/" + primitive.NewObjectID().Hex(),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Get bad ID",
+			urlPath:  "/api/v1/snippets/nope",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Comments",
+			urlPath:  "/api/v1/snippets/" + mocks.MockSnippet.ID.Hex() + "/comments",
+			wantCode: http.StatusOK,
+			wantBody: `"comments":[]`,
+		},
+		{
+			name:     "User hides email",
+			urlPath:  "/api/v1/users/" + mocks.MockUser.ID.Hex(),
+			wantCode: http.StatusOK,
+			wantBody: `"name":"Alice"`,
+		},
+		{
+			name:     "Unknown route",
+			urlPath:  "/api/v1/nothing",
+			wantCode: http.StatusNotFound,
+			wantBody: `"error"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Content-Type"), "application/json")
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+	_, _, body := ts.get(t, "/api/v1/users/"+mocks.MockUser.ID.Hex())
+	assert.Equal(t, json.Valid([]byte(body)), true)
+	assert.Equal(t, strings.Contains(body, mocks.MockUser.Email), false)
+}
+
+func TestAPISnippetWrite(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.do(t, http.MethodPost, "/api/v1/snippets", `{"title":"O snail","content":"Climb Mount Fuji","tags":["haiku"]}`)
+	assert.Equal(t, code, http.StatusUnauthorized)
+	assert.StringContains(t, body, `"error"`)
+
+	ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+
+	code, _, body = ts.do(t, http.MethodPost, "/api/v1/snippets", `{"title":"","content":"Climb Mount Fuji","tags":["a/b"]}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, `"title":"This field cannot be blank"`)
+	assert.StringContains(t, body, `"tags":`)
+
+	code, _, body = ts.do(t, http.MethodPost, "/api/v1/snippets", `{"title":"O snail"`)
+	assert.Equal(t, code, http.StatusBadRequest)
+
+	code, _, body = ts.do(t, http.MethodPost, "/api/v1/snippets", `{"title":"O snail","bogus":1}`)
+	assert.Equal(t, code, http.StatusBadRequest)
+	assert.StringContains(t, body, "bogus")
+
+	code, header, body := ts.do(t, http.MethodPost, "/api/v1/snippets", `{"title":"O snail","content":"Climb Mount Fuji","tags":["Haiku","snail"],"expires":7}`)
+	assert.Equal(t, code, http.StatusCreated)
+	var created struct {
+		Snippet apiSnippet `json:"snippet"`
+	}
+	assert.NilError(t, json.Unmarshal([]byte(body), &created))
+	assert.Equal(t, header.Get("Location"), "/api/v1/snippets/"+created.Snippet.ID)
+	assert.Equal(t, created.Snippet.Author.Name, "Alice")
+	assert.Equal(t, created.Snippet.Tags[0], "haiku")
+	assert.Equal(t, created.Snippet.Expires != nil, true)
+	assert.Equal(t, created.Snippet.Language, "plaintext")
+	path := "/api/v1/snippets/" + created.Snippet.ID
+
+	code, _, body = ts.do(t, http.MethodPut, path, `{"title":"O snail!","content":"Climb slowly","tags":["haiku"],"language":"cobol"}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, `"language":`)
+	code, _, body = ts.do(t, http.MethodPut, path, `{"title":"O snail!","content":"Climb slowly","tags":["haiku"],"language":"markdown"}`)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"title":"O snail!"`)
+	assert.StringContains(t, body, `"language":"markdown"`)
+
+	code, _, _ = ts.do(t, http.MethodPost, path+"/favourite", `{}`)
+	assert.Equal(t, code, http.StatusNoContent)
+	code, _, _ = ts.do(t, http.MethodPost, path+"/favourite", `{}`)
+	assert.Equal(t, code, http.StatusConflict)
+	code, _, _ = ts.do(t, http.MethodDelete, path+"/favourite", "")
+	assert.Equal(t, code, http.StatusNoContent)
+	// Unfavouriting again changes nothing, the count included.
+	code, _, _ = ts.do(t, http.MethodDelete, path+"/favourite", "")
+	assert.Equal(t, code, http.StatusNoContent)
+	_, _, body = ts.get(t, path)
+	assert.StringContains(t, body, `"favourited":0`)
+
+	code, _, _ = ts.do(t, http.MethodPost, path+"/comments", `{"content":" "}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	code, _, body = ts.do(t, http.MethodPost, path+"/comments", `{"content":"Nice"}`)
+	assert.Equal(t, code, http.StatusCreated)
+	assert.StringContains(t, body, `"content":"Nice"`)
+	_, _, body = ts.get(t, path+"/comments")
+	assert.StringContains(t, body, `"name":"Alice"`)
+
+	_, _, body = ts.get(t, "/api/v1/users/"+mocks.MockUser.ID.Hex())
+	assert.StringContains(t, body, mocks.MockUser.Email)
+
+	code, _, _ = ts.do(t, http.MethodDelete, path, "")
+	assert.Equal(t, code, http.StatusNoContent)
+	code, _, _ = ts.get(t, path)
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestAPIVisibility(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+
+	code, _, body := ts.do(t, http.MethodPost, "/api/v1/snippets", `{"title":"O snail","content":"Climb Mount Fuji","tags":["haiku"],"visibility":"secret"}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, `"visibility":`)
+	code, _, body = ts.do(t, http.MethodPost, "/api/v1/snippets", `{"title":"O snail","content":"Climb Mount Fuji","tags":["haiku"],"visibility":"private"}`)
+	assert.Equal(t, code, http.StatusCreated)
+	assert.StringContains(t, body, `"visibility":"private"`)
+	var created struct {
+		Snippet apiSnippet `json:"snippet"`
+	}
+	assert.NilError(t, json.Unmarshal([]byte(body), &created))
+	path := "/api/v1/snippets/" + created.Snippet.ID
+	code, _, _ = ts.get(t, path)
+	assert.Equal(t, code, http.StatusOK)
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	code, _, _ = other.get(t, path)
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, _ = other.get(t, path+"/comments")
+	assert.Equal(t, code, http.StatusNotFound)
+	_, _, body = other.get(t, "/api/v1/snippets")
+	assert.Equal(t, strings.Contains(body, created.Snippet.ID), false)
+}
+
+func TestAPICommentThreads(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, mocks.DupeEmail, mocks.MockUserPassword)
+	path := "/api/v1/snippets/" + mocks.MockSnippet.ID.Hex() + "/comments"
+
+	code, _, body := ts.do(t, http.MethodPost, path, `{"content":"First!"}`)
+	assert.Equal(t, code, http.StatusCreated)
+	var created struct {
+		Comment apiComment `json:"comment"`
+	}
+	assert.NilError(t, json.Unmarshal([]byte(body), &created))
+	commentID := created.Comment.ID
+	code, _, body = ts.do(t, http.MethodPost, path, `{"content":"A reply","parent_id":"`+commentID+`"}`)
+	assert.Equal(t, code, http.StatusCreated)
+	assert.StringContains(t, body, `"parent_id":"`+commentID+`"`)
+	code, _, _ = ts.do(t, http.MethodPost, path, `{"content":"A reply","parent_id":"`+primitive.NewObjectID().Hex()+`"}`)
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, body = ts.do(t, http.MethodPost, path, `{"content":"A reply","parent_id":"nope"}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, `"parent_id":`)
+
+	code, _, body = ts.do(t, http.MethodPut, path+"/"+commentID, `{"content":"First, edited"}`)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"content":"First, edited"`)
+	assert.StringContains(t, body, `"edited":`)
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	addUser(t, app, "Eve", "eve@example.com")
+	other.login(t, "eve@example.com", mocks.MockUserPassword)
+	code, _, _ = other.do(t, http.MethodDelete, path+"/"+commentID, "")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	code, _, _ = ts.do(t, http.MethodDelete, path+"/"+commentID, "")
+	assert.Equal(t, code, http.StatusNoContent)
+	_, _, body = ts.get(t, path)
+	assert.Equal(t, body, `{"comments":[],"next":"","prev":""}`)
+}
+
+func TestAPIRequiresJSON(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+
+	// A cross-site form post carries the session cookie but can't set a JSON
+	// content type.
+	code, _, body := ts.postForm(t, "/api/v1/snippets/"+mocks.MockSnippet.ID.Hex()+"/favourite", nil)
+	assert.Equal(t, code, http.StatusUnsupportedMediaType)
+	assert.StringContains(t, body, "application/json")
+}
+
+func TestAPINotAuthor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, mocks.DupeEmail, mocks.MockUserPassword)
+
+	path := "/api/v1/snippets/" + mocks.MockSnippet.ID.Hex()
+	code, _, _ := ts.do(t, http.MethodPut, path, `{"title":"Mine","content":"Now","tags":["haiku"]}`)
+	assert.Equal(t, code, http.StatusForbidden)
+	code, _, _ = ts.do(t, http.MethodDelete, path, "")
+	assert.Equal(t, code, http.StatusForbidden)
+}
+
+func TestAPITokens(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+
+	createToken := func(scope string) string {
+		form := url.Values{}
+		form.Add("name", "CI "+scope)
+		form.Add("scope", scope)
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/account/tokens", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		_, _, body := ts.get(t, "/account/view")
+		token := regexp.MustCompile(`<code>(sbx_[a-z0-9]+)</code>`).FindStringSubmatch(body)
+		if token == nil {
+			t.Fatal("no token on the account page")
+		}
+		// It's only shown once.
+		_, _, body = ts.get(t, "/account/view")
+		assert.Equal(t, strings.Contains(body, token[1]), false)
+		return token[1]
+	}
+	readWrite := createToken("read-write")
+	readOnly := createToken("read")
+
+	form := url.Values{}
+	form.Add("name", "")
+	form.Add("scope", "admin")
+	form.Add("csrf_token", csrfToken)
+	code, _, body := ts.postForm(t, "/account/tokens", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "This field must be read or read-write")
+
+	// A fresh client, with no session cookie.
+	api := newTestServer(t, app.routes())
+	defer api.Close()
+	send := func(method, path, token, body string) int {
+		req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		assert.NilError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rs, err := api.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+	snippet := `{"title":"Build","content":"Passed","tags":["ci"]}`
+	assert.Equal(t, send(http.MethodPost, "/api/v1/snippets", "", snippet), http.StatusUnauthorized)
+	assert.Equal(t, send(http.MethodPost, "/api/v1/snippets", "sbx_bogus", snippet), http.StatusUnauthorized)
+	assert.Equal(t, send(http.MethodGet, "/api/v1/snippets", readOnly, ""), http.StatusOK)
+	assert.Equal(t, send(http.MethodPost, "/api/v1/snippets", readOnly, snippet), http.StatusForbidden)
+	assert.Equal(t, send(http.MethodPost, "/api/v1/snippets", readWrite, snippet), http.StatusCreated)
+
+	tokens, err := app.users.Tokens(mocks.MockUser.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tokens), 2)
+	for _, token := range tokens {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/account/tokens/revoke/"+token.ID.Hex(), form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+	assert.Equal(t, send(http.MethodPost, "/api/v1/snippets", readWrite, snippet), http.StatusUnauthorized)
+}
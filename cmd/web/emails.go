@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"snippetbox/internal/mailer"
+	"snippetbox/internal/models"
+	"strconv"
+	"time"
+)
+
+// The purposes of the tokens in email links, and how long they are valid.
+// A verification token is stamped with the address and whether it is
+// verified, and a reset token with the password hash, so each stops working
+// once used. A reset token is stamped with the address too, so that links
+// sent to an old address stop working once it is changed.
+const (
+	verifyEmailPurpose   = "verify-email"
+	verifyEmailTTL       = 48 * time.Hour
+	resetPasswordPurpose = "reset-password"
+	resetPasswordTTL     = time.Hour
+)
+
+func verifyEmailStamp(user models.User) string {
+	return user.Email + "\x00" + strconv.FormatBool(user.EmailVerified)
+}
+
+func resetPasswordStamp(user models.User) string {
+	return user.Email + "\x00" + user.HashedPassword
+}
+
+// siteURL is the public URL of the site, for links that leave it. It comes
+// from the configuration, since the Host header of the request is chosen by
+// the client; the configuration only leaves it unset when mail is merely
+// logged and single sign-on is off, as in development.
+func (app *application) siteURL(r *http.Request) string {
+	if app.publicURL != "" {
+		return app.publicURL
+	}
+	return baseURL(r)
+}
+
+// sendMail sends msg in the background, so that neither a slow mail server
+// nor whether a message was sent at all shows in the response time.
+func (app *application) sendMail(msg mailer.Message) {
+	app.background(func(ctx context.Context) {
+		err := app.mailer.Send(msg)
+		if err != nil {
+			app.logger.Error(err.Error(), "subject", msg.Subject)
+		}
+	})
+}
+
+func (app *application) sendVerificationEmail(r *http.Request, user models.User) {
+	token := app.signer.Sign(verifyEmailPurpose, user.ID.Hex(), verifyEmailStamp(user), time.Now().Add(verifyEmailTTL))
+	link := app.siteURL(r) + "/user/verify?token=" + url.QueryEscape(token)
+	app.sendMail(mailer.Message{
+		To:      recipient(user),
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link within %s:\n\n%s\n\n"+
+			"If you didn't sign up, you can ignore this email.\n", user.Name, humanTTL(verifyEmailTTL), link),
+	})
+}
+
+func (app *application) sendPasswordResetEmail(r *http.Request, user models.User) {
+	token := app.signer.Sign(resetPasswordPurpose, user.ID.Hex(), resetPasswordStamp(user), time.Now().Add(resetPasswordTTL))
+	link := app.siteURL(r) + "/user/reset?token=" + url.QueryEscape(token)
+	app.sendMail(mailer.Message{
+		To:      recipient(user),
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nYou can choose a new password by opening this link within %s:\n\n%s\n\n"+
+			"If you didn't ask to reset your password, you can ignore this email.\n", user.Name, humanTTL(resetPasswordTTL), link),
+	})
+}
+
+// sendEmailChangedEmail tells user at their old address that it was
+// changed to newEmail, in case someone else did it.
+func (app *application) sendEmailChangedEmail(user models.User, newEmail string) {
+	app.sendMail(mailer.Message{
+		To:      recipient(user),
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s.\n\n"+
+			"If you didn't change it, please reset your password and contact us.\n", user.Name, newEmail),
+	})
+}
+
+// recipient formats the address of user, encoding the name as needed.
+func recipient(user models.User) string {
+	return (&mail.Address{Name: user.Name, Address: user.Email}).String()
+}
+
+func humanTTL(d time.Duration) string {
+	if d%time.Hour == 0 {
+		if h := int(d / time.Hour); h != 1 {
+			return fmt.Sprintf("%d hours", h)
+		}
+		return "an hour"
+	}
+	return d.String()
+}
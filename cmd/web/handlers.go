@@ -1,23 +1,111 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"snippetbox/internal/authtoken"
+	"snippetbox/internal/diff"
+	"snippetbox/internal/highlight"
 	"snippetbox/internal/models"
+	"snippetbox/internal/totp"
 	"snippetbox/internal/validator"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/skip2/go-qrcode"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type snippetCreateForm struct {
 	Title               string `form:"title"`
 	Content             string `form:"content"`
+	Tags                string `form:"tags"`
+	Language            string `form:"language"`
+	Visibility          string `form:"visibility"`
+	Expires             int    `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
+func (form *snippetCreateForm) validate() {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	tags := models.SplitTags(form.Tags)
+	form.CheckField(len(tags) > 0, "tags", "This field cannot be blank")
+	form.CheckField(len(tags) <= models.MaxTags, "tags", fmt.Sprintf("This field cannot have more than %d tags", models.MaxTags))
+	for _, tag := range tags {
+		form.CheckField(validator.MaxChars(tag, models.MaxTagLength), "tags", fmt.Sprintf("Tags cannot be more than %d characters long", models.MaxTagLength))
+		form.CheckField(models.ValidTag(tag), "tags", "Tags can only contain letters, digits and _+#.-")
+	}
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(form.Language == "" || highlight.Valid(form.Language), "language", "This field must be one of the listed languages")
+	form.CheckField(form.Visibility == "" || validator.PermittedValue(form.Visibility, models.Visibilities...), "visibility", "This field must be public, unlisted or private")
+	form.CheckField(validator.PermittedValue(form.Expires, 0, 1, 7, 365), "expires", "This field must equal 0, 1, 7 or 365")
+}
+
+// tags returns the normalized tags of a validated form.
+func (form *snippetCreateForm) tags() []string {
+	return models.NormalizeTags(models.SplitTags(form.Tags))
+}
+
+// language returns the language picked on a validated form, or the one
+// detected from the content when it was left on auto-detect.
+func (form *snippetCreateForm) language() string {
+	if form.Language == "" {
+		return highlight.Detect(form.Content)
+	}
+	return form.Language
+}
+
+// dateLayout is the format of date inputs.
+const dateLayout = "2006-01-02"
+
+type searchForm struct {
+	Q                   string `form:"q"`
 	Tag                 string `form:"tag"`
+	Author              string `form:"author"`
+	From                string `form:"from"`
+	To                  string `form:"to"`
 	validator.Validator `form:"-"`
 }
 
+// Terms returns the search terms, for highlighting them in results.
+func (form searchForm) Terms() []string {
+	return models.ParseTerms(form.Q)
+}
+
+// query validates the form and turns it into a SearchQuery. Dates are whole
+// days and the To day is included.
+func (form *searchForm) query() models.SearchQuery {
+	form.CheckField(validator.MaxChars(form.Q, 200), "q", "This field cannot be more than 200 characters long")
+	q := models.SearchQuery{
+		Terms:  form.Terms(),
+		Tag:    strings.ToLower(strings.TrimSpace(form.Tag)),
+		Author: strings.TrimSpace(form.Author),
+	}
+	if form.From != "" {
+		from, err := time.Parse(dateLayout, form.From)
+		form.CheckField(err == nil, "from", "This field must be a date")
+		q.From = from
+	}
+	if form.To != "" {
+		to, err := time.Parse(dateLayout, form.To)
+		form.CheckField(err == nil, "to", "This field must be a date")
+		if err == nil {
+			q.To = to.AddDate(0, 0, 1)
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() {
+		form.CheckField(q.From.Before(q.To), "to", "This field cannot be before the from date")
+	}
+	return q
+}
+
 type userSignupForm struct {
 	Name                string `form:"name"`
 	Email               string `form:"email"`
@@ -31,300 +119,1524 @@ type userLoginForm struct {
 	validator.Validator `form:"-"`
 }
 
+// emailForm asks for the address to send a verification or password reset
+// link to.
+type emailForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type passwordResetForm struct {
+	Token               string `form:"token"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// twoFactorForm takes an authenticator or recovery code, and the password
+// where changing two-factor settings asks for it again.
+type twoFactorForm struct {
+	Code                string `form:"code"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type apiTokenForm struct {
+	Name                string `form:"name"`
+	Scope               string `form:"scope"`
+	validator.Validator `form:"-"`
+}
+
 type commentaryForm struct {
-	Author              map[string]string `form:"author"`
-	Content             string            `form:"content"`
+	Author   map[string]string `form:"author"`
+	Content  string            `form:"content"`
+	Markdown bool              `form:"markdown"`
+	// Parent is the hex ID of the comment replied to, or empty for a top
+	// level comment.
+	Parent              string `form:"parent"`
 	validator.Validator `form:"-"`
 }
 
-func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
+func ping(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+func (app *application) home(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	q, err := app.pageQuery(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	page, err := app.snippets.Latest(q)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	tags, err := app.snippets.TagCounts()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = page.Snippets
+	data.Page = page
+	data.Sort = q.Sort
+	data.TagCloud = tagCloud(tags)
+	app.render(w, r, http.StatusOK, "home.html", data)
+}
+
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	tag := strings.ToLower(params.ByName("name"))
+	if !models.ValidTag(tag) {
+		app.notFound(w)
+		return
+	}
+	q, err := app.pageQuery(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	page, err := app.snippets.ByTag(tag, q)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = page.Snippets
+	data.Page = page
+	data.Sort = q.Sort
+	data.Tag = tag
+	app.render(w, r, http.StatusOK, "tag.html", data)
+}
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	var form searchForm
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	q := form.query()
+	data := app.newTemplateData(r)
+	data.Form = form
+	if !form.Valid() {
+		app.render(w, r, http.StatusUnprocessableEntity, "search.html", data)
+		return
+	}
+	if !q.IsEmpty() {
+		data.Results, err = app.snippets.Search(q)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Searched = true
+	}
+	app.render(w, r, http.StatusOK, "search.html", data)
+}
+
+func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	idStr := params.ByName("id")
+	id, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+	viewerIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.GetVisible(id, viewerIDStr)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			fmt.Println(err)
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	q, err := app.commentQuery(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	data, err := app.snippetViewData(r, snippet, viewerIDStr, q)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.Form = commentaryForm{}
+	app.render(w, r, http.StatusOK, "view.html", data)
+}
+
+// snippetViewData gathers what view.html shows about snippet besides the
+// form: its parent, its forks and the page q of its comments, threaded.
+func (app *application) snippetViewData(r *http.Request, snippet models.Snippet, viewerIDStr string, q models.CommentQuery) (templateData, error) {
+	var err error
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.BaseURL = baseURL(r)
+	if !snippet.ForkedFrom.IsZero() {
+		// A parent that has since gone, or was private, is shown as
+		// unavailable.
+		data.Parent, err = app.snippets.GetVisible(snippet.ForkedFrom, viewerIDStr)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return templateData{}, err
+		}
+	}
+	data.Forks, err = app.snippets.Forks(snippet.ID, viewerIDStr)
+	if err != nil {
+		return templateData{}, err
+	}
+	data.CommentPage, err = app.commentary.Commentaries(snippet.ID, q)
+	if err != nil {
+		return templateData{}, err
+	}
+	data.Comments = commentTree(snippet, models.Threads(data.CommentPage.Commentaries), &data)
+	return data, nil
+}
+
+// snippetFromParams loads the snippet named by the id URL parameter, writing
+// the error response itself when there isn't one that the user with the hex
+// ID viewerIDStr may see.
+func (app *application) snippetFromParams(w http.ResponseWriter, r *http.Request, viewerIDStr string) (models.Snippet, bool) {
+	id, err := primitive.ObjectIDFromHex(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return models.Snippet{}, false
+	}
+	snippet, err := app.snippets.GetVisible(id, viewerIDStr)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Snippet{}, false
+	}
+	return snippet, true
+}
+
+// snippetRaw serves the content of a snippet as plain text.
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromParams(w, r, app.sessionManager.GetString(r.Context(), "authenticatedUserID"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, snippet.Content)
+}
+
+// snippetDownload serves the content of a snippet as a file attachment named
+// after its title.
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromParams(w, r, app.sessionManager.GetString(r.Context(), "authenticatedUserID"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": downloadFilename(snippet.Title, snippet.Language),
+	}))
+	io.WriteString(w, snippet.Content)
+}
+
+// snippetEmbed renders a bare page with just the snippet, meant to be shown
+// in an iframe on other sites; see allowFraming. Those requests come without
+// the session, so private snippets can't be embedded.
+func (app *application) snippetEmbed(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromParams(w, r, "")
+	if !ok {
+		return
+	}
+	data := templateData{
+		Snippet: snippet,
+		BaseURL: baseURL(r),
+	}
+	app.render(w, r, http.StatusOK, "embed.html", data)
+}
+
+// snippetWidget serves a script that inserts the embed iframe of a snippet
+// where the script tag is, so the snippet can be pasted into pages that
+// take scripts but not iframes.
+func (app *application) snippetWidget(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromParams(w, r, "")
+	if !ok {
+		return
+	}
+	src, err := json.Marshal(embedURL(r, snippet))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	fmt.Fprintf(w, widgetScript, src)
+}
+
+// snippetHistory lists the revisions of a snippet, newest first.
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromParams(w, r, app.sessionManager.GetString(r.Context(), "authenticatedUserID"))
+	if !ok {
+		return
+	}
+	revisions, err := app.snippets.Revisions(snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revisions = revisions
+	app.render(w, r, http.StatusOK, "history.html", data)
+}
+
+// revisionParam reads the revision number in the named query parameter,
+// returning def when it is absent.
+func revisionParam(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid revision %q", s)
+	}
+	return n, nil
+}
+
+// snippetDiff shows the changes between two revisions of a snippet, given
+// by the from and to query parameters. They default to the latest revision
+// and the one before it; revision 0 stands for the empty snippet before the
+// first. With format=patch the diff is served as a plain text unified diff.
+func (app *application) snippetDiff(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromParams(w, r, app.sessionManager.GetString(r.Context(), "authenticatedUserID"))
+	if !ok {
+		return
+	}
+	revisions, err := app.snippets.Revisions(snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	latest := 0
+	if len(revisions) > 0 {
+		latest = revisions[0].Number
+	}
+	to, err := revisionParam(r, "to", latest)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	from, err := revisionParam(r, "from", max(to-1, 0))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	var fromRevision, toRevision models.Revision
+	for _, rev := range revisions {
+		if rev.Number == from {
+			fromRevision = rev
+		}
+		if rev.Number == to {
+			toRevision = rev
+		}
+	}
+	if (from != 0 && fromRevision.Number == 0) || (to != 0 && toRevision.Number == 0) {
+		app.notFound(w)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "patch" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, diff.Unified(fromRevision.Content, toRevision.Content,
+			fmt.Sprintf("revision %d", from), fmt.Sprintf("revision %d", to)))
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revisions = revisions
+	data.FromRevision = fromRevision
+	data.ToRevision = toRevision
+	data.Hunks = diff.Hunks(fromRevision.Content, toRevision.Content, 3)
+	app.render(w, r, http.StatusOK, "diff.html", data)
+}
+
+type restoreForm struct {
+	Revision int `form:"revision"`
+}
+
+// snippetRestorePost brings back an earlier revision of a snippet. Only its
+// author may do so.
+func (app *application) snippetRestorePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := primitive.ObjectIDFromHex(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+	var form restoreForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	err = app.snippets.Restore(id, UserIDStr, form.Revision)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, models.ErrNotAuthor):
+			app.clientError(w, http.StatusForbidden)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Revision %d successfully restored!", form.Revision))
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id.Hex()), http.StatusSeeOther)
+}
+
+// snippetForkPost copies a snippet as a new one written by the logged in
+// user, who can then edit it as their own.
+func (app *application) snippetForkPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := primitive.ObjectIDFromHex(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+	UserName := app.sessionManager.GetString(r.Context(), "UserName")
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	forkID, err := app.snippets.Fork(id, UserName, UserIDStr)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Post successfully forked!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", forkID.Hex()), http.StatusSeeOther)
+}
+
+func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = snippetCreateForm{}
+	app.render(w, r, http.StatusOK, "create.html", data)
+}
+func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form snippetCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.validate()
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
+		return
+	}
+	UserName := app.sessionManager.GetString(r.Context(), "UserName")
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	ObjectID, err := app.snippets.Insert(form.Title, form.Content, form.tags(), form.language(), form.Visibility, UserName, UserIDStr, form.Expires)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	id := ObjectID.Hex()
+	app.sessionManager.Put(r.Context(), "flash", "Post successfully created!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id), http.StatusSeeOther)
+}
+
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := primitive.ObjectIDFromHex(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if snippet.AuthorID() != app.sessionManager.GetString(r.Context(), "authenticatedUserID") {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Form = snippetCreateForm{
+		Title:    snippet.Title,
+		Content:  snippet.Content,
+		Tags:     strings.Join(snippet.Tags, ", "),
+		Language: snippet.Language,
+	}
+	app.render(w, r, http.StatusOK, "edit.html", data)
+}
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := primitive.ObjectIDFromHex(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+	var form snippetCreateForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.validate()
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = models.Snippet{ID: id, IDStr: id.Hex()}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.html", data)
+		return
+	}
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	err = app.snippets.Update(id, UserIDStr, form.Title, form.Content, form.tags(), form.language())
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, models.ErrNotAuthor):
+			app.clientError(w, http.StatusForbidden)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Post successfully updated!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id.Hex()), http.StatusSeeOther)
+}
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := primitive.ObjectIDFromHex(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	err = app.snippets.Delete(id, UserIDStr)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, models.ErrNotAuthor):
+			app.clientError(w, http.StatusForbidden)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Post successfully deleted!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) FavouritePost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	params := httprouter.ParamsFromContext(r.Context())
+	SnippetIDStr := params.ByName("id")
+	SnippetID, err := primitive.ObjectIDFromHex(SnippetIDStr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	UserID, err := primitive.ObjectIDFromHex(UserIDStr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.users.AddFavourites(SnippetID, UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrAlreadyFavourite) {
+			app.sessionManager.Put(r.Context(), "flash", "Post is already in favourites!")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Post added succesfuly!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+func (app *application) FavouriteDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	params := httprouter.ParamsFromContext(r.Context())
+	SnippetIDStr := params.ByName("id")
+	SnippetID, err := primitive.ObjectIDFromHex(SnippetIDStr)
+	if err != nil {
+		app.notFound(w)
+		return
+
+	}
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	UserID, err := primitive.ObjectIDFromHex(UserIDStr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	Snippet, err := app.snippets.Get(SnippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	err = app.users.RemoveFavourites(Snippet, SnippetID, UserID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Post removed succesfuly!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+
+}
+func (app *application) CommentaryPost(w http.ResponseWriter, r *http.Request) {
+	var form commentaryForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	var ParentID primitive.ObjectID
+	if form.Parent != "" {
+		ParentID, err = primitive.ObjectIDFromHex(form.Parent)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	snippet, ok := app.snippetFromParams(w, r, UserIDStr)
+	if !ok {
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	if !form.Valid() {
+		data, err := app.snippetViewData(r, snippet, UserIDStr, models.CommentQuery{})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "view.html", data)
+		return
+	}
+	form.Author = map[string]string{
+		app.sessionManager.GetString(r.Context(), "UserName"): UserIDStr,
+	}
+	_, err = app.commentary.AddComentary(snippet.ID, ParentID, form.Author, form.Content, form.Markdown)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Comment added succesfuly!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", snippet.IDStr), http.StatusSeeOther)
+}
+
+// commentParams returns the snippet and comment IDs in the URL, writing a
+// not found response itself when either is malformed.
+func (app *application) commentParams(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, primitive.ObjectID, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := primitive.ObjectIDFromHex(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	commentID, err := primitive.ObjectIDFromHex(params.ByName("comment"))
+	if err != nil {
+		app.notFound(w)
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return id, commentID, true
+}
+
+// commentError writes the response for an error from changing a comment.
+func (app *application) commentError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		app.notFound(w)
+	case errors.Is(err, models.ErrNotAuthor):
+		app.clientError(w, http.StatusForbidden)
+	default:
+		app.serverError(w, r, err)
+	}
+}
+
+// CommentaryEditPost changes the content of a comment. Only its author and
+// the author of the snippet may do so.
+func (app *application) CommentaryEditPost(w http.ResponseWriter, r *http.Request) {
+	id, commentID, ok := app.commentParams(w, r)
+	if !ok {
+		return
+	}
+	var form commentaryForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	if !form.Valid() {
+		snippet, ok := app.snippetFromParams(w, r, UserIDStr)
+		if !ok {
+			return
+		}
+		data, err := app.snippetViewData(r, snippet, UserIDStr, models.CommentQuery{})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "view.html", data)
+		return
+	}
+	err = app.commentary.UpdateComentary(id, commentID, UserIDStr, form.Content, form.Markdown)
+	if err != nil {
+		app.commentError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Comment successfully edited!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id.Hex()), http.StatusSeeOther)
+}
+
+// CommentaryDeletePost deletes a comment with the replies to it. Only its
+// author and the author of the snippet may do so.
+func (app *application) CommentaryDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, commentID, ok := app.commentParams(w, r)
+	if !ok {
+		return
+	}
+	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	err := app.commentary.DeleteComentary(id, commentID, UserIDStr)
+	if err != nil {
+		app.commentError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Comment successfully deleted!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id.Hex()), http.StatusSeeOther)
+}
+
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userSignupForm{}
+	app.render(w, r, http.StatusOK, "signup.html", data)
+}
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	var form userSignupForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Mathches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+		return
+	}
+	err = app.users.Insert(form.Name, form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email address is already in use")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sendVerificationEmail(r, user)
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please follow the link we've emailed you to verify your address, then log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	app.render(w, r, http.StatusOK, "login.html", data)
+}
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
+	var form userLoginForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Mathches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		return
+	}
+	// A client being throttled is turned away before the password is
+	// checked, so that guessing gets nowhere even when it guesses right.
+	if wait := app.loginWait(r, form.Email); wait > 0 {
+		setRetryAfter(w, wait)
+		form.AddNonFieldError(tooManyLogins)
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.html", data)
+		return
+	}
+	ObjectID, name, err := app.users.Authenticate(form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.loginFailed(r, form.Email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddNonFieldError("Email or password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+
+	}
+	user, err := app.users.GetByID(ObjectID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !user.EmailVerified {
+		form.AddNonFieldError("Please verify your email address before logging in")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		return
+	}
+	if user.TOTPSecret != "" {
+		// The password checks out, but the session stays unauthenticated
+		// until the second factor does too.
+		err = app.startTwoFactor(r, ObjectID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/user/login/twofactor", http.StatusSeeOther)
+		return
+	}
+	err = app.logIn(r, ObjectID, name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.loginSucceeded(form.Email)
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+
+}
+
+// A user who passed the first login step has twoFactorTimeout to enter a
+// code, and maxTwoFactorAttempts tries before having to start over.
+const (
+	twoFactorTimeout     = 5 * time.Minute
+	maxTwoFactorAttempts = 5
+	// totpIssuer names the site in authenticator apps.
+	totpIssuer = "Snippetbox"
+)
+
+// startTwoFactor records that the user passed the first login step in this
+// session, under a new session token.
+func (app *application) startTwoFactor(r *http.Request, id primitive.ObjectID) error {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), "twoFactorUserID", id.Hex())
+	app.sessionManager.Put(r.Context(), "twoFactorExpires", time.Now().Add(twoFactorTimeout).Unix())
+	app.sessionManager.Put(r.Context(), "twoFactorAttempts", 0)
+	return nil
+}
+
+// twoFactorUser returns the user who passed the first login step in this
+// session and has yet to pass the second, if they are still in time.
+func (app *application) twoFactorUser(r *http.Request) (models.User, bool, error) {
+	idStr := app.sessionManager.GetString(r.Context(), "twoFactorUserID")
+	if idStr == "" || time.Now().Unix() > app.sessionManager.GetInt64(r.Context(), "twoFactorExpires") {
+		return models.User{}, false, nil
+	}
+	id, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return models.User{}, false, err
+	}
+	user, err := app.users.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.User{}, false, nil
+		}
+		return models.User{}, false, err
+	}
+	return user, user.TOTPSecret != "", nil
+}
+
+func (app *application) clearTwoFactor(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorExpires")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, ok, err := app.twoFactorUser(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = twoFactorForm{}
+	app.render(w, r, http.StatusOK, "twoFactorLogin.html", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	var form twoFactorForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok, err := app.twoFactorUser(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		app.clearTwoFactor(r)
+		app.sessionManager.Put(r.Context(), "flash", "Your login has timed out. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "twoFactorLogin.html", data)
+		return
+	}
+	// Wrong codes count against the account like wrong passwords, or
+	// starting over would give unlimited guesses to whoever has the
+	// password.
+	if wait := app.loginWait(r, user.Email); wait > 0 {
+		setRetryAfter(w, wait)
+		form.AddNonFieldError(tooManyLogins)
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "twoFactorLogin.html", data)
+		return
+	}
+	recovery, ok, err := app.checkSecondFactor(user, form.Code)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.loginFailed(r, user.Email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+		if attempts >= maxTwoFactorAttempts {
+			app.clearTwoFactor(r)
+			app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes. Please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+		form.AddNonFieldError("The code is incorrect")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "twoFactorLogin.html", data)
+		return
+	}
+	app.clearTwoFactor(r)
+	err = app.logIn(r, user.ID, user.Name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.loginSucceeded(user.Email)
+	if recovery {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You logged in with a recovery code. You have %d left.", len(user.RecoveryCodes)-1))
+	}
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// checkSecondFactor reports whether code is a valid authenticator code or
+// unused recovery code of user, spending it if so, and whether it was a
+// recovery code.
+func (app *application) checkSecondFactor(user models.User, code string) (recovery, ok bool, err error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if step, valid := totp.Validate(user.TOTPSecret, code, time.Now()); valid {
+		err := app.users.UseTOTPStep(user.ID, step)
+		if errors.Is(err, models.ErrCodeUsed) {
+			return false, false, nil
+		}
+		return false, err == nil, err
+	}
+	err = app.users.UseRecoveryCode(user.ID, models.HashRecoveryCode(code))
+	if errors.Is(err, models.ErrNoRecord) {
+		return false, false, nil
+	}
+	return true, err == nil, err
+}
+
+// logIn starts an authenticated session for the user, under a new session
+// token to guard against session fixation.
+func (app *application) logIn(r *http.Request, id primitive.ObjectID, name string) error {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id.Hex())
+	app.sessionManager.Put(r.Context(), "UserName", name)
+	return nil
+}
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// userFromToken returns the user a token from an email link was signed for,
+// provided it is still valid for them. Otherwise it sets a flash saying why
+// not and returns false.
+func (app *application) userFromToken(r *http.Request, purpose, token string, stamp func(models.User) string) (models.User, bool, error) {
+	user, err := app.tokenUser(purpose, token, stamp)
+	switch {
+	case err == nil:
+		return user, true, nil
+	case errors.Is(err, authtoken.ErrExpired):
+		app.sessionManager.Put(r.Context(), "flash", "This link has expired. Please ask for a new one.")
+	case errors.Is(err, authtoken.ErrUsed):
+		app.sessionManager.Put(r.Context(), "flash", "This link has already been used. Please ask for a new one if you need to.")
+	case errors.Is(err, authtoken.ErrInvalid):
+		app.sessionManager.Put(r.Context(), "flash", "This link is not valid. Please ask for a new one.")
+	default:
+		return models.User{}, false, err
+	}
+	return models.User{}, false, nil
+}
+
+func (app *application) tokenUser(purpose, token string, stamp func(models.User) string) (models.User, error) {
+	claims, err := app.signer.Parse(purpose, token, time.Now())
+	if err != nil {
+		return models.User{}, err
+	}
+	id, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return models.User{}, authtoken.ErrInvalid
+	}
+	user, err := app.users.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.User{}, authtoken.ErrInvalid
+		}
+		return models.User{}, err
+	}
+	return user, claims.Check(stamp(user))
+}
+
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	user, ok, err := app.userFromToken(r, verifyEmailPurpose, r.URL.Query().Get("token"), verifyEmailStamp)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		http.Redirect(w, r, "/user/verify/resend", http.StatusSeeOther)
+		return
+	}
+	err = app.users.SetEmailVerified(user.ID, user.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userVerifyResend(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = emailForm{}
+	app.render(w, r, http.StatusOK, "resend.html", data)
+}
+
+// userVerifyResendPost answers the same whether or not there is an
+// unverified account for the address, so as not to tell who has signed up.
+func (app *application) userVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	form, ok := app.decodeEmailForm(w, r, "resend.html")
+	if !ok {
+		return
+	}
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	if err == nil && !user.EmailVerified {
+		app.sendVerificationEmail(r, user)
+	}
+	app.sessionManager.Put(r.Context(), "flash", "If that address belongs to an account awaiting verification, we've emailed it a new link.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = emailForm{}
+	app.render(w, r, http.StatusOK, "forgot.html", data)
+}
+
+// userForgotPasswordPost answers the same whether or not there is an
+// account for the address, so as not to tell who has signed up.
+func (app *application) userForgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	form, ok := app.decodeEmailForm(w, r, "forgot.html")
+	if !ok {
 		return
 	}
-	snippets, err := app.snippets.Latest()
-	if err != nil {
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
 		app.serverError(w, r, err)
 		return
 	}
+	if err == nil {
+		app.sendPasswordResetEmail(r, user)
+	}
+	app.sessionManager.Put(r.Context(), "flash", "If that address belongs to an account, we've emailed it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
 
-	data := app.newTemplateData(r)
-	data.Snippets = snippets
-	app.render(w, r, http.StatusOK, "home.html", data)
+// decodeEmailForm decodes and validates an emailForm, rendering page with
+// the errors if it isn't valid.
+func (app *application) decodeEmailForm(w http.ResponseWriter, r *http.Request, page string) (emailForm, bool) {
+	var form emailForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return form, false
+	}
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Mathches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, page, data)
+		return form, false
+	}
+	return form, true
 }
-func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
-	params := httprouter.ParamsFromContext(r.Context())
-	idStr := params.ByName("id")
-	id, err := primitive.ObjectIDFromHex(idStr)
+
+func (app *application) userResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, ok, err := app.userFromToken(r, resetPasswordPurpose, token, resetPasswordStamp)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	snippet, err := app.snippets.Get(id)
-	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			fmt.Println(err)
-			app.notFound(w)
-		} else {
-			app.serverError(w, r, err)
-		}
+	if !ok {
+		http.Redirect(w, r, "/user/forgot", http.StatusSeeOther)
 		return
 	}
 	data := app.newTemplateData(r)
-	data.Snippet = snippet
-	data.Form = commentaryForm{}
-	app.render(w, r, http.StatusOK, "view.html", data)
-}
-func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = snippetCreateForm{}
-	app.render(w, r, http.StatusOK, "create.html", data)
+	data.Form = passwordResetForm{Token: token}
+	app.render(w, r, http.StatusOK, "reset.html", data)
 }
-func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
-	var form snippetCreateForm
+
+func (app *application) userResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordResetForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Tag), "tag", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	user, ok, err := app.userFromToken(r, resetPasswordPurpose, form.Token, resetPasswordStamp)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		http.Redirect(w, r, "/user/forgot", http.StatusSeeOther)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.html", data)
 		return
 	}
-	UserName := app.sessionManager.GetString(r.Context(), "UserName")
-	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
-	ObjectID, err := app.snippets.Insert(form.Title, form.Content, form.Tag, UserName, UserIDStr)
+	err = app.users.SetPassword(user.ID, form.Password)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	id := ObjectID.Hex()
-	app.sessionManager.Put(r.Context(), "flash", "Post successfully created!")
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", id), http.StatusSeeOther)
+	// The link was emailed to the address, which proves it as well as a
+	// verification link would.
+	err = app.users.SetEmailVerified(user.ID, user.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-func (app *application) FavouritePost(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		app.clientError(w, http.StatusMethodNotAllowed)
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	app.renderAccount(w, r, http.StatusOK, apiTokenForm{Scope: models.ScopeRead})
+}
+
+// renderAccount renders the account page of the logged in user, with form
+// as the state of the new API token form.
+func (app *application) renderAccount(w http.ResponseWriter, r *http.Request, status int, form apiTokenForm) {
+	idStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	if idStr == "" {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
-	params := httprouter.ParamsFromContext(r.Context())
-	SnippetIDStr := params.ByName("id")
-	SnippetID, err := primitive.ObjectIDFromHex(SnippetIDStr)
+	id, err := primitive.ObjectIDFromHex(idStr)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
-	UserID, err := primitive.ObjectIDFromHex(UserIDStr)
+	q, err := app.pageQuery(r)
 	if err != nil {
-		app.serverError(w, r, err)
+		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	err = app.users.AddFavourites(SnippetID, UserID)
+	user, err := app.users.Get(id, idStr, q)
 	if err != nil {
-		if err.Error() == "Post is already in favourites" {
-			app.sessionManager.Put(r.Context(), "flash", "Post is already in favourites!")
-			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
-			return
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
 		}
-		app.serverError(w, r, err)
 		return
 	}
-	app.sessionManager.Put(r.Context(), "flash", "Post added succesfuly!")
-	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
-}
-func (app *application) FavouriteDelete(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		app.clientError(w, http.StatusMethodNotAllowed)
+	tokens, err := app.users.Tokens(id)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
-	params := httprouter.ParamsFromContext(r.Context())
-	SnippetIDStr := params.ByName("id")
-	SnippetID, err := primitive.ObjectIDFromHex(SnippetIDStr)
+	events, err := app.users.AuditEntries(id, securityEventsShown)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
+	}
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Tokens = tokens
+	data.AuditEntries = events
+	data.NewToken = app.sessionManager.PopString(r.Context(), "newAPIToken")
+	data.Form = form
+	app.render(w, r, status, "account.html", data)
+}
 
+// accountUser returns the logged in user without their snippets.
+func (app *application) accountUser(r *http.Request) (models.User, error) {
+	id, err := primitive.ObjectIDFromHex(app.sessionManager.GetString(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		return models.User{}, err
 	}
-	UserIDStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
-	UserID, err := primitive.ObjectIDFromHex(UserIDStr)
+	return app.users.GetByID(id)
+}
+
+func (app *application) twoFactorView(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactor(w, r, http.StatusOK, twoFactorForm{})
+}
+
+// renderTwoFactor renders the page where the logged in user manages
+// two-factor authentication: enrollment if they haven't enabled it, with a
+// secret kept in the session until they confirm it, otherwise their
+// recovery codes and a way to turn it off.
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, form twoFactorForm) {
+	user, err := app.accountUser(r)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	Snippet, err := app.snippets.Get(SnippetID)
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+	if codes := app.sessionManager.PopString(r.Context(), "newRecoveryCodes"); codes != "" {
+		data.RecoveryCodes = strings.Fields(codes)
+	}
+	if user.TOTPSecret == "" {
+		secret := app.sessionManager.GetString(r.Context(), "totpEnrollSecret")
+		if secret == "" {
+			secret, err = totp.NewSecret()
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			app.sessionManager.Put(r.Context(), "totpEnrollSecret", secret)
+		}
+		data.TOTPSecret = groupSecret(secret)
+	}
+	app.render(w, r, status, "twoFactor.html", data)
+}
+
+// twoFactorQR serves the QR code of the secret being enrolled as a PNG. It
+// is a URL of its own rather than a data: URI, which the Content Security
+// Policy doesn't allow.
+func (app *application) twoFactorQR(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "totpEnrollSecret")
+	if secret == "" {
+		app.notFound(w)
+		return
+	}
+	user, err := app.accountUser(r)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	err = app.users.RemoveFavourites(Snippet, SnippetID, UserID)
+	png, err := qrcode.Encode(totp.URI(secret, totpIssuer, user.Email), qrcode.Medium, 256)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	app.sessionManager.Put(r.Context(), "flash", "Post removed succesfuly!")
-	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
-
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
 }
-func (app *application) CommentaryPost(w http.ResponseWriter, r *http.Request) {
-	var form commentaryForm
+
+func (app *application) twoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	var form twoFactorForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	secret := app.sessionManager.GetString(r.Context(), "totpEnrollSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/account/twofactor", http.StatusSeeOther)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	step, ok := totp.Validate(secret, strings.ReplaceAll(strings.TrimSpace(form.Code), " ", ""), time.Now())
+	if form.Valid() && !ok {
+		form.AddFieldError("code", "The code is incorrect")
+	}
 	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "view.html", data)
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form)
 		return
 	}
-	params := httprouter.ParamsFromContext(r.Context())
-	SnippetIDStr := params.ByName("id")
-	SnippetID, err := primitive.ObjectIDFromHex(SnippetIDStr)
+	userID, err := primitive.ObjectIDFromHex(app.sessionManager.GetString(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	codes, hashes, err := models.NewRecoveryCodes()
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	Author := map[string]string{
-		app.sessionManager.GetString(r.Context(), "UserName"): app.sessionManager.GetString(r.Context(), "authenticatedUserID"),
+	err = app.users.EnableTOTP(userID, secret, hashes)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
-
-	form.Author = Author
-	err = app.commentary.AddComentary(SnippetID, form.Author, form.Content)
+	// The code just entered can't be used to log in again.
+	err = app.users.UseTOTPStep(userID, step)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	app.sessionManager.Put(r.Context(), "flash", "Comment added succesfuly!")
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", SnippetIDStr), http.StatusSeeOther)
+	app.sessionManager.Remove(r.Context(), "totpEnrollSecret")
+	app.sessionManager.Put(r.Context(), "newRecoveryCodes", strings.Join(codes, " "))
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication enabled!")
+	http.Redirect(w, r, "/account/twofactor", http.StatusSeeOther)
 }
 
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, r, http.StatusOK, "signup.html", data)
-}
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
-	var form userSignupForm
+// twoFactorPassword decodes a form that changes two-factor settings and
+// checks the password on it, rendering the errors if it doesn't pass.
+func (app *application) twoFactorPassword(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	var form twoFactorForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
-		return
+		return models.User{}, false
+	}
+	user, err := app.accountUser(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return models.User{}, false
+	}
+	// The page has two forms asking for the password, so the errors go
+	// above both.
+	ok, wait, err := app.confirmPassword(r, user, form.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return models.User{}, false
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		form.AddNonFieldError(tooManyLogins)
+		app.renderTwoFactor(w, r, http.StatusTooManyRequests, form)
+		return models.User{}, false
+	}
+	if !ok {
+		form.AddNonFieldError("The password is incorrect")
 	}
-	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Mathches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
 	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func (app *application) twoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.twoFactorPassword(w, r)
+	if !ok {
 		return
 	}
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	err := app.users.DisableTOTP(user.ID)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email address is already in use")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
-		} else {
-			app.serverError(w, r, err)
-		}
+		app.serverError(w, r, err)
 		return
 	}
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication disabled!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, r, http.StatusOK, "login.html", data)
+
+func (app *application) twoFactorRecoveryPost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.twoFactorPassword(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPSecret == "" {
+		http.Redirect(w, r, "/account/twofactor", http.StatusSeeOther)
+		return
+	}
+	codes, hashes, err := models.NewRecoveryCodes()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.users.SetRecoveryCodes(user.ID, hashes)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "newRecoveryCodes", strings.Join(codes, " "))
+	app.sessionManager.Put(r.Context(), "flash", "New recovery codes generated!")
+	http.Redirect(w, r, "/account/twofactor", http.StatusSeeOther)
 }
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
-	var form userLoginForm
+
+func (app *application) apiTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form apiTokenForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Mathches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.PermittedValue(form.Scope, models.ScopeRead, models.ScopeReadWrite), "scope", "This field must be read or read-write")
 	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, form)
 		return
 	}
-	ObjectID, name, err := app.users.Authenticate(form.Email, form.Password)
-	id := ObjectID.Hex()
+	userID, err := primitive.ObjectIDFromHex(app.sessionManager.GetString(r.Context(), "authenticatedUserID"))
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
-		} else {
-			app.serverError(w, r, err)
-		}
+		app.serverError(w, r, err)
 		return
-
 	}
-	err = app.sessionManager.RenewToken(r.Context())
+	token, plaintext, err := models.NewAPIToken(userID, form.Name, form.Scope)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
-	app.sessionManager.Put(r.Context(), "UserName", name)
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
-
-}
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	err := app.sessionManager.RenewToken(r.Context())
+	err = app.users.AddToken(token)
 	if err != nil {
 		app.serverError(w, r, err)
+		return
 	}
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	// The plaintext is shown once, on the next page load, and never again.
+	app.sessionManager.Put(r.Context(), "newAPIToken", plaintext)
+	app.sessionManager.Put(r.Context(), "flash", "API token created!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
 
-func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
-	idStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
-	if idStr == "" {
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+func (app *application) apiTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	tokenID, err := primitive.ObjectIDFromHex(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
 		return
 	}
-	id, err := primitive.ObjectIDFromHex(idStr)
+	userID, err := primitive.ObjectIDFromHex(app.sessionManager.GetString(r.Context(), "authenticatedUserID"))
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	user, err := app.users.Get(id)
+	err = app.users.RevokeToken(userID, tokenID)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			app.notFound(w)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
-	data := app.newTemplateData(r)
-	data.User = user
-	app.render(w, r, http.StatusOK, "account.html", data)
+	app.sessionManager.Put(r.Context(), "flash", "API token revoked!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
+
 func (app *application) otherAccountView(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 	idStr := params.ByName("id")
@@ -342,7 +1654,12 @@ func (app *application) otherAccountView(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := app.users.Get(id)
+	q, err := app.pageQuery(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, err := app.users.Get(id, app.sessionManager.GetString(r.Context(), "authenticatedUserID"), q)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -1,11 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+	"snippetbox/internal/models/mocks"
+	"snippetbox/internal/oidc"
+	"snippetbox/internal/oidc/oidctest"
+	"snippetbox/internal/throttle"
+	"snippetbox/internal/totp"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestPing(t *testing.T) {
@@ -35,13 +49,13 @@ func TestSnippetView(t *testing.T) {
 	}{
 		{
 			name:     "Valid ID",
-			urlPath:  "/snippet/view/1",
+			urlPath:  "/snippet/view/" + mocks.MockSnippet.ID.Hex(),
 			wantCode: http.StatusOK,
 			wantBody: "An old silent pond...",
 		},
 		{
 			name:     "Non-existent ID",
-			urlPath:  "/snippet/view/2",
+			urlPath:  "/snippet/view/" + primitive.NewObjectID().Hex(),
 			wantCode: http.StatusNotFound,
 		},
 		{
@@ -161,7 +175,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Duplicate email",
 			userName:     validName,
-			userEmail:    "dupe@example.com",
+			userEmail:    mocks.DupeEmail,
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
 			wantCode:     http.StatusUnprocessableEntity,
@@ -183,3 +197,1484 @@ func TestUserSignup(t *testing.T) {
 		})
 	}
 }
+
+func TestUserVerifyEmail(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", mocks.MockUserPassword)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	sent := sentMail(app)
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, `"Bob" <bob@example.com>`)
+	link := mailLink(t, sent[0])
+
+	// Until the address is verified, the password is not enough.
+	form = url.Values{}
+	form.Add("email", "bob@example.com")
+	form.Add("password", mocks.MockUserPassword)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, body = ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Please verify your email address before logging in")
+
+	code, headers, _ := ts.get(t, link[:len(link)-1])
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/verify/resend")
+	_, _, body = ts.get(t, "/user/verify/resend")
+	assert.StringContains(t, body, "This link is not valid")
+
+	code, headers, _ = ts.get(t, link)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+	_, _, body = ts.get(t, "/user/login")
+	assert.StringContains(t, body, "Your email address has been verified")
+	ts.login(t, "bob@example.com", mocks.MockUserPassword)
+
+	code, headers, _ = ts.get(t, link)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/verify/resend")
+	_, _, body = ts.get(t, "/user/verify/resend")
+	assert.StringContains(t, body, "This link has already been used")
+}
+
+func TestUserVerifyResend(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	assert.NilError(t, app.users.Insert("Bob", "bob@example.com", mocks.MockUserPassword))
+
+	_, _, body := ts.get(t, "/user/verify/resend")
+	csrfToken := extractCSRFToken(t, body)
+	// Verified and unknown addresses get the same answer as unverified
+	// ones, but no email.
+	for _, email := range []string{"bob@example.com", mocks.MockUser.Email, "nobody@example.com"} {
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("csrf_token", csrfToken)
+		code, headers, _ := ts.postForm(t, "/user/verify/resend", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+		_, _, body = ts.get(t, "/user/login")
+		assert.StringContains(t, body, "If that address belongs to an account awaiting verification")
+	}
+	sent := sentMail(app)
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, `"Bob" <bob@example.com>`)
+
+	form := url.Values{}
+	form.Add("email", "bob@")
+	form.Add("csrf_token", csrfToken)
+	code, _, body := ts.postForm(t, "/user/verify/resend", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "This field must be a valid email address")
+}
+
+func TestUserResetPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/forgot")
+	csrfToken := extractCSRFToken(t, body)
+	for _, email := range []string{mocks.MockUser.Email, "nobody@example.com"} {
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/user/forgot", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		_, _, body = ts.get(t, "/user/login")
+		assert.StringContains(t, body, "If that address belongs to an account, we've emailed it a link")
+	}
+	sent := sentMail(app)
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, `"Alice" <alice@example.com>`)
+	link := mailLink(t, sent[0])
+	token, err := url.QueryUnescape(strings.TrimPrefix(link, "/user/reset?token="))
+	assert.NilError(t, err)
+
+	code, _, body := ts.get(t, link)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<form action='/user/reset' method='POST' novalidate>")
+
+	form := url.Values{}
+	form.Add("token", token)
+	form.Add("password", "short")
+	form.Add("csrf_token", csrfToken)
+	code, _, body = ts.postForm(t, "/user/reset", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "This field must be at least 8 characters long")
+
+	form.Set("password", "new pa$$word")
+	code, headers, _ := ts.postForm(t, "/user/reset", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+	_, _, err = app.users.Authenticate(mocks.MockUser.Email, mocks.MockUserPassword)
+	assert.Equal(t, err, models.ErrInvalidCredentials)
+	ts.login(t, mocks.MockUser.Email, "new pa$$word")
+
+	// The link is spent once the password changes.
+	form.Set("password", "another pa$$word")
+	code, headers, _ = ts.postForm(t, "/user/reset", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/forgot")
+	_, _, body = ts.get(t, "/user/forgot")
+	assert.StringContains(t, body, "This link has already been used")
+
+	user, err := app.users.GetByID(mocks.MockUser.ID)
+	assert.NilError(t, err)
+	expired := app.signer.Sign(resetPasswordPurpose, user.IDStr, resetPasswordStamp(user), time.Now().Add(-time.Minute))
+	code, headers, _ = ts.get(t, "/user/reset?token="+url.QueryEscape(expired))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/forgot")
+	_, _, body = ts.get(t, "/user/forgot")
+	assert.StringContains(t, body, "This link has expired")
+
+	// A verification token can't stand in for a reset token.
+	verify := app.signer.Sign(verifyEmailPurpose, user.IDStr, resetPasswordStamp(user), time.Now().Add(time.Hour))
+	code, _, _ = ts.get(t, "/user/reset?token="+url.QueryEscape(verify))
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestSiteURLIgnoresHost(t *testing.T) {
+	app := newTestApplication(t)
+	app.publicURL = "https://snippetbox.example.com"
+	stub := oidctest.NewProvider("snippetbox", "s3cret")
+	defer stub.Close()
+	var err error
+	app.oidc, err = oidc.Discover(context.Background(), stub.URL, "snippetbox", "s3cret", nil)
+	assert.NilError(t, err)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// spoofed sends a request in the session of ts with a Host header
+	// chosen by an attacker.
+	spoofed := func(method, urlPath string, form url.Values) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+urlPath, strings.NewReader(form.Encode()))
+		assert.NilError(t, err)
+		req.Host = "evil.example"
+		// The jar looks cookies up by Host, so they are added by hand.
+		for _, c := range ts.Client().Jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+		if method == http.MethodPost {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+		return rs
+	}
+
+	_, _, body := ts.get(t, "/user/forgot")
+	form := url.Values{}
+	form.Add("email", mocks.MockUser.Email)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	rs := spoofed(http.MethodPost, "/user/forgot", form)
+	assert.Equal(t, rs.StatusCode, http.StatusSeeOther)
+	sent := sentMail(app)
+	assert.Equal(t, len(sent), 1)
+	assert.StringContains(t, sent[0].Body, "https://snippetbox.example.com/user/reset?token=")
+	if strings.Contains(sent[0].Body, "evil.example") {
+		t.Errorf("reset link points at the spoofed host: %q", sent[0].Body)
+	}
+
+	rs = spoofed(http.MethodGet, "/user/login/sso", nil)
+	assert.Equal(t, rs.StatusCode, http.StatusSeeOther)
+	authURL, err := url.Parse(rs.Header.Get("Location"))
+	assert.NilError(t, err)
+	assert.Equal(t, authURL.Query().Get("redirect_uri"), "https://snippetbox.example.com/user/login/sso/callback")
+}
+
+var (
+	totpKeyRX       = regexp.MustCompile(`Key: <code>([A-Z2-7 ]+)</code>`)
+	recoveryCodesRX = regexp.MustCompile(`<li><code>([a-z2-7]{5}-[a-z2-7]{5})</code></li>`)
+)
+
+func TestTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	email := mocks.MockUser.Email
+	csrfToken := ts.login(t, email, mocks.MockUserPassword)
+
+	code, _, body := ts.get(t, "/account/twofactor")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<img class='qr' src='/account/twofactor/qr.png'")
+	matches := totpKeyRX.FindStringSubmatch(body)
+	if matches == nil {
+		t.Fatal("no key found on the page")
+	}
+	secret := strings.ReplaceAll(matches[1], " ", "")
+
+	code, headers, body := ts.get(t, "/account/twofactor/qr.png")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "image/png")
+	assert.Equal(t, strings.HasPrefix(body, "\x89PNG"), true)
+
+	now := time.Now()
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	form.Add("code", "000000")
+	code, _, body = ts.postForm(t, "/account/twofactor", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "The code is incorrect")
+	enrollCode, err := totp.Code(secret, now)
+	assert.NilError(t, err)
+	form.Set("code", enrollCode)
+	code, headers, _ = ts.postForm(t, "/account/twofactor", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/twofactor")
+	_, _, body = ts.get(t, "/account/twofactor")
+	assert.StringContains(t, body, "Two-factor authentication is on")
+	var recoveryCodes []string
+	for _, m := range recoveryCodesRX.FindAllStringSubmatch(body, -1) {
+		recoveryCodes = append(recoveryCodes, m[1])
+	}
+	assert.Equal(t, len(recoveryCodes), models.RecoveryCodeCount)
+	_, _, body = ts.get(t, "/account/twofactor")
+	assert.Equal(t, recoveryCodesRX.MatchString(body), false)
+
+	// The password alone leaves the session unauthenticated.
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	_, _, body = other.get(t, "/user/login")
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("email", email)
+	form.Add("password", mocks.MockUserPassword)
+	code, headers, _ = other.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login/twofactor")
+	code, headers, _ = other.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	_, _, body = other.get(t, "/user/login/twofactor")
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("code", enrollCode)
+	code, _, body = other.postForm(t, "/user/login/twofactor", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "The code is incorrect")
+	nextCode, err := totp.Code(secret, now.Add(totp.Period*time.Second))
+	assert.NilError(t, err)
+	form.Set("code", nextCode)
+	code, headers, _ = other.postForm(t, "/user/login/twofactor", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/snippet/create")
+	code, _, _ = other.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+
+	// A recovery code works once.
+	for i, want := range []int{http.StatusSeeOther, http.StatusUnprocessableEntity} {
+		third := newTestServer(t, app.routes())
+		defer third.Close()
+		twoFactorLogin(t, third, email)
+		_, _, body = third.get(t, "/user/login/twofactor")
+		form = url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		form.Add("code", strings.ToUpper(recoveryCodes[0]))
+		code, _, _ = third.postForm(t, "/user/login/twofactor", form)
+		assert.Equal(t, code, want)
+		if i == 0 {
+			_, _, body = third.get(t, "/snippet/create")
+			assert.StringContains(t, body, "You have 9 left")
+		}
+	}
+
+	// Too many wrong codes and the first step has to be done again. The
+	// session runs out of attempts before the account would be throttled,
+	// but the earlier wrong codes count too, so that is put out of the way.
+	app.loginAccounts = &throttle.Limiter{}
+	fourth := newTestServer(t, app.routes())
+	defer fourth.Close()
+	twoFactorLogin(t, fourth, email)
+	_, _, body = fourth.get(t, "/user/login/twofactor")
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("code", "000000")
+	for i := 1; i < maxTwoFactorAttempts; i++ {
+		code, _, _ = fourth.postForm(t, "/user/login/twofactor", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+	code, headers, _ = fourth.postForm(t, "/user/login/twofactor", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+	_, _, body = fourth.get(t, "/user/login")
+	assert.StringContains(t, body, "Too many incorrect codes")
+	code, headers, _ = fourth.get(t, "/user/login/twofactor")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	form.Add("password", "wrong")
+	code, _, body = ts.postForm(t, "/account/twofactor/disable", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "The password is incorrect")
+	form.Set("password", mocks.MockUserPassword)
+	code, _, _ = ts.postForm(t, "/account/twofactor/disable", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	fifth := newTestServer(t, app.routes())
+	defer fifth.Close()
+	fifth.login(t, email, mocks.MockUserPassword)
+	code, _, _ = fifth.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestLoginThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	// Backing off is covered by the throttle tests, so here every failure
+	// may follow the last straight away.
+	app.loginIPs.Base, app.loginIPs.Max = time.Nanosecond, time.Nanosecond
+	app.loginAccounts.Base, app.loginAccounts.Max = time.Nanosecond, time.Nanosecond
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+	login := func(email, password string) (int, http.Header, string) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		form.Add("email", email)
+		form.Add("password", password)
+		return ts.postForm(t, "/user/login", form)
+	}
+
+	// Whether or not the account exists, it is locked out the same way.
+	for _, email := range []string{mocks.MockUser.Email, "nobody@example.com"} {
+		for i := 0; i < 5; i++ {
+			code, _, body := login(email, "wrong")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.StringContains(t, body, "Email or password is incorrect")
+		}
+		code, headers, body := login(email, mocks.MockUserPassword)
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.Equal(t, headers.Get("Retry-After"), "900")
+		assert.StringContains(t, body, tooManyLogins)
+	}
+	entries, err := app.users.AuditEntries(mocks.MockUser.ID, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Event, models.AuditLoginLockout)
+	assert.Equal(t, entries[0].IP, "127.0.0.1")
+
+	// Other accounts can still be logged in to from the same address.
+	addUser(t, app, "Bob", "bob@example.com")
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.login(t, "bob@example.com", mocks.MockUserPassword)
+	_, _, body = other.get(t, "/account/view")
+	assert.StringContains(t, body, "No security events")
+
+	// Once the lockout is over, the owner sees it on their account page.
+	app.loginAccounts.Reset(mocks.MockUser.Email)
+	code, _, _ := login(mocks.MockUser.Email, mocks.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, "Locked out after too many failed logins")
+	assert.StringContains(t, body, "127.0.0.1")
+}
+
+func TestPasswordConfirmThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	app.loginIPs.Base, app.loginIPs.Max = time.Nanosecond, time.Nanosecond
+	app.loginAccounts.Base, app.loginAccounts.Max = time.Nanosecond, time.Nanosecond
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+	deleteAccount := func(password string) (int, http.Header, string) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		form.Add("content", contentRemove)
+		form.Add("delete_password", password)
+		return ts.postForm(t, "/account/settings/delete", form)
+	}
+
+	// Passwords asked for again count against the account like logins, so
+	// a hijacked session can't be used to guess it.
+	for i := 0; i < 5; i++ {
+		code, _, body := deleteAccount("wrong")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "The password is incorrect")
+	}
+	code, headers, body := deleteAccount(mocks.MockUserPassword)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "900")
+	assert.StringContains(t, body, tooManyLogins)
+	_, err := app.users.GetByID(mocks.MockUser.ID)
+	assert.NilError(t, err)
+	entries, err := app.users.AuditEntries(mocks.MockUser.ID, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Event, models.AuditLoginLockout)
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	form.Add("password", mocks.MockUserPassword)
+	code, headers, body = ts.postForm(t, "/account/twofactor/disable", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "900")
+	assert.StringContains(t, body, tooManyLogins)
+}
+
+func TestSingleSignOn(t *testing.T) {
+	app := newTestApplication(t)
+	stub := oidctest.NewProvider("snippetbox", "s3cret")
+	defer stub.Close()
+	var err error
+	app.oidc, err = oidc.Discover(context.Background(), stub.URL, "snippetbox", "s3cret", nil)
+	assert.NilError(t, err)
+	app.ssoName = "Acme SSO"
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	_, _, body := ts.get(t, "/user/login")
+	assert.StringContains(t, body, "<a href='/user/login/sso'>Log in with Acme SSO</a>")
+
+	// A new user is signed up, with their address verified by the provider.
+	stub.SetUser(oidctest.User{Subject: "c1", Email: "carol@example.com", EmailVerified: true, Name: "Carol"})
+	code, headers := ssoLogin(t, ts, stub)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/snippet/create")
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, "carol@example.com")
+	carol, err := app.users.GetByEmail("carol@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, carol.Name, "Carol")
+	assert.Equal(t, carol.EmailVerified, true)
+
+	// An existing user is logged in to their account.
+	stub.SetUser(oidctest.User{Subject: "a1", Email: mocks.MockUser.Email, EmailVerified: true})
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	code, headers = ssoLogin(t, other, stub)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/snippet/create")
+	_, _, body = other.get(t, "/account/view")
+	assert.StringContains(t, body, mocks.MockUser.Email)
+
+	// Someone who signed up with an address that wasn't theirs loses the
+	// account to its owner.
+	assert.NilError(t, app.users.Insert("Mallory", "dave@example.com", mocks.MockUserPassword))
+	stub.SetUser(oidctest.User{Subject: "d1", Email: "dave@example.com", EmailVerified: true, Name: "Dave"})
+	third := newTestServer(t, app.routes())
+	defer third.Close()
+	code, _ = ssoLogin(t, third, stub)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = third.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("email", "dave@example.com")
+	form.Add("password", mocks.MockUserPassword)
+	code, _, body = third.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Email or password is incorrect")
+
+	// The second factor is still asked for.
+	assert.NilError(t, app.users.EnableTOTP(mocks.MockUser.ID, "JBSWY3DPEHPK3PXP", nil))
+	stub.SetUser(oidctest.User{Subject: "a1", Email: mocks.MockUser.Email, EmailVerified: true})
+	fourth := newTestServer(t, app.routes())
+	defer fourth.Close()
+	code, headers = ssoLogin(t, fourth, stub)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login/twofactor")
+	code, _, _ = fourth.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	tests := []struct {
+		name  string
+		setup func()
+		flash string
+	}{
+		{
+			name:  "Unverified address",
+			setup: func() { stub.SetUser(oidctest.User{Subject: "e1", Email: "erin@example.com", Name: "Erin"}) },
+			flash: "hasn't verified your email address",
+		},
+		{
+			name:  "Cancelled",
+			setup: stub.Deny,
+			flash: "Single sign-on failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+			code, headers := ssoLogin(t, ts, stub)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/login")
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, tt.flash)
+		})
+	}
+	_, err = app.users.GetByEmail("erin@example.com")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	// A callback that wasn't asked for in this session is refused.
+	stub.SetUser(oidctest.User{Subject: "c1", Email: "carol@example.com", EmailVerified: true})
+	fifth := newTestServer(t, app.routes())
+	defer fifth.Close()
+	code, headers, _ = fifth.get(t, "/user/login/sso/callback?state=forged&code=stolen")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+	_, _, body = fifth.get(t, "/user/login")
+	assert.StringContains(t, body, "Your single sign-on login has expired")
+}
+
+// ssoLogin goes through a single sign-on login with the stub provider and
+// returns the response to the callback.
+func ssoLogin(t *testing.T, ts *testServer, stub *oidctest.Provider) (int, http.Header) {
+	t.Helper()
+	code, headers, _ := ts.get(t, "/user/login/sso")
+	assert.Equal(t, code, http.StatusSeeOther)
+	authURL := headers.Get("Location")
+	assert.Equal(t, strings.HasPrefix(authURL, stub.URL+"/authorize?"), true)
+
+	resp, err := ts.Client().Get(authURL)
+	assert.NilError(t, err)
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	assert.NilError(t, err)
+	assert.Equal(t, back.Path, "/user/login/sso/callback")
+	code, headers, _ = ts.get(t, back.RequestURI())
+	return code, headers
+}
+
+// twoFactorLogin passes the first login step of a user with two-factor
+// authentication.
+func twoFactorLogin(t *testing.T, ts *testServer, email string) {
+	t.Helper()
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("email", email)
+	form.Add("password", mocks.MockUserPassword)
+	code, headers, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/login/twofactor" {
+		t.Fatalf("first login step failed with status %d", code)
+	}
+}
+
+func TestAccountSettings(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	// Another session of Alice's, which has to pick up her new name.
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	otherCSRF := other.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+	csrfToken := ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+
+	code, _, body := ts.get(t, "/account/settings")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<input type='text' name='name' value='Alice'>")
+	assert.StringContains(t, body, "<input type='email' name='email' value='alice@example.com'>")
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	form.Set("name", " ")
+	code, _, body = ts.postForm(t, "/account/settings/name", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "This field cannot be blank")
+	form.Set("name", "Alicia")
+	code, headers, _ := ts.postForm(t, "/account/settings/name", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/settings")
+	snippet, err := app.snippets.Get(mocks.MockSnippet.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Author["Alicia"], mocks.MockUser.ID.Hex())
+	comment := url.Values{}
+	comment.Add("csrf_token", otherCSRF)
+	comment.Add("content", "Renamed")
+	code, _, _ = other.postForm(t, "/snippet/addCommentary/"+mocks.MockSnippet.ID.Hex(), comment)
+	assert.Equal(t, code, http.StatusSeeOther)
+	page, err := app.commentary.Commentaries(mocks.MockSnippet.ID, models.CommentQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, page.Commentaries[0].Author["Alicia"], mocks.MockUser.ID.Hex())
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	form.Set("current_password", "wrong pa$$word")
+	form.Set("new_password", "new pa$$word")
+	form.Set("confirm_password", "other pa$$word")
+	code, _, body = ts.postForm(t, "/account/settings/password", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "The password is incorrect")
+	assert.StringContains(t, body, "The passwords don't match")
+	form.Set("current_password", mocks.MockUserPassword)
+	form.Set("confirm_password", "new pa$$word")
+	code, _, _ = ts.postForm(t, "/account/settings/password", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, err = app.users.Authenticate(mocks.MockUser.Email, "new pa$$word")
+	assert.NilError(t, err)
+
+	// A reset link sent to the old address, which may be in the wrong
+	// hands, stops working once the address changes.
+	user, err := app.users.GetByID(mocks.MockUser.ID)
+	assert.NilError(t, err)
+	reset := app.signer.Sign(resetPasswordPurpose, user.IDStr, resetPasswordStamp(user), time.Now().Add(time.Hour))
+	code, _, _ = ts.get(t, "/user/reset?token="+url.QueryEscape(reset))
+	assert.Equal(t, code, http.StatusOK)
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	form.Set("email", mocks.DupeEmail)
+	form.Set("email_password", "new pa$$word")
+	code, _, body = ts.postForm(t, "/account/settings/email", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Email address is already in use")
+	form.Set("email", "alicia@example.com")
+	code, _, _ = ts.postForm(t, "/account/settings/email", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	user, err = app.users.GetByID(mocks.MockUser.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Email, "alicia@example.com")
+	assert.Equal(t, user.EmailVerified, false)
+	code, headers, _ = ts.get(t, "/user/reset?token="+url.QueryEscape(reset))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/forgot")
+
+	// The old address hears of the change, the new one gets a link to
+	// verify it.
+	sent := sentMail(app)
+	assert.Equal(t, len(sent), 2)
+	assert.Equal(t, sent[0].To, `"Alicia" <alice@example.com>`)
+	assert.StringContains(t, sent[0].Body, "changed to alicia@example.com")
+	assert.Equal(t, sent[1].To, `"Alicia" <alicia@example.com>`)
+	code, headers, _ = ts.get(t, mailLink(t, sent[1]))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+	user, err = app.users.GetByID(mocks.MockUser.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, user.EmailVerified, true)
+
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, "Email address changed")
+	assert.StringContains(t, body, "Password changed")
+}
+
+func TestAccountDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"Anonymize", contentAnonymize},
+		{"Remove", contentRemove},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			form := url.Values{}
+			form.Add("csrf_token", ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword))
+			form.Set("delete_password", "wrong pa$$word")
+			code, _, body := ts.postForm(t, "/account/settings/delete", form)
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.StringContains(t, body, "The password is incorrect")
+			assert.StringContains(t, body, "Please choose what happens to your posts and comments")
+
+			form.Set("delete_password", mocks.MockUserPassword)
+			form.Set("content", tt.content)
+			code, headers, _ := ts.postForm(t, "/account/settings/delete", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/")
+			_, _, body = ts.get(t, "/")
+			assert.StringContains(t, body, "Your account has been deleted.")
+			code, headers, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/login")
+			_, err := app.users.GetByID(mocks.MockUser.ID)
+			assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+			snippet, err := app.snippets.Get(mocks.MockSnippet.ID)
+			if tt.content == contentRemove {
+				assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, snippet.AuthorName(), models.DeletedAuthor)
+			// Content of deleted users has no account to link to.
+			_, _, body = ts.get(t, "/snippet/view/"+mocks.MockSnippet.ID.Hex())
+			assert.StringContains(t, body, "<time>[deleted]</time>")
+		})
+	}
+}
+
+func TestSnippetEdit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	id := mocks.MockSnippet.ID.Hex()
+
+	code, headers, _ := ts.get(t, "/snippet/edit/"+id)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	csrfToken := ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+	code, _, body := ts.get(t, "/snippet/edit/"+id)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "An old silent pond...")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		title    string
+		wantCode int
+	}{
+		{
+			name:     "Valid submission",
+			urlPath:  "/snippet/edit/" + id,
+			title:    "A new silent pond",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Empty title",
+			urlPath:  "/snippet/edit/" + id,
+			title:    "",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/edit/" + primitive.NewObjectID().Hex(),
+			title:    "A new silent pond",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", "A frog jumps into the pond")
+			form.Add("tags", "haiku")
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	_, _, body = ts.get(t, "/snippet/view/"+id)
+	assert.StringContains(t, body, "A new silent pond")
+}
+
+func TestSnippetDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	urlPath := "/snippet/delete/" + mocks.MockSnippet.ID.Hex()
+
+	csrfToken := ts.login(t, mocks.DupeEmail, mocks.MockUserPassword)
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, urlPath, form)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	ts = newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken = ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+	form.Set("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, urlPath, form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, _, _ = ts.get(t, "/snippet/view/"+mocks.MockSnippet.ID.Hex())
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestFavouriteDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	form := url.Values{}
+	form.Add("csrf_token", ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword))
+	code, _, _ := ts.postForm(t, "/snippet/removeFavourite/"+primitive.NewObjectID().Hex(), form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	code, _, _ = ts.postForm(t, "/snippet/removeFavourite/"+mocks.MockSnippet.ID.Hex(), form)
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestSnippetCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+
+	tests := []struct {
+		name     string
+		tags     string
+		expires  string
+		wantCode int
+	}{
+		{
+			name:     "Never expires",
+			tags:     "haiku",
+			expires:  "0",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Expires in a week",
+			tags:     "haiku",
+			expires:  "7",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Invalid expiry",
+			tags:     "haiku",
+			expires:  "30",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Several tags",
+			tags:     "Haiku, nature snail,haiku",
+			expires:  "0",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Blank tags",
+			tags:     " , ",
+			expires:  "0",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Invalid tag",
+			tags:     "haiku a/b",
+			expires:  "0",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Too many tags",
+			tags:     "a b c d e f g h i j k",
+			expires:  "0",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "O snail")
+			form.Add("content", "Climb Mount Fuji")
+			form.Add("tags", tt.tags)
+			form.Add("expires", tt.expires)
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestHome(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Default sort",
+			urlPath:  "/",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Tag cloud",
+			urlPath:  "/",
+			wantCode: http.StatusOK,
+			wantBody: "<a class='tag-1' href='/tag/haiku' title='1 posts'>haiku</a>",
+		},
+		{
+			name:     "Most favourited",
+			urlPath:  "/?sort=favourited&limit=5",
+			wantCode: http.StatusOK,
+			wantBody: "<strong>favourited</strong>",
+		},
+		{
+			name:     "Unknown sort",
+			urlPath:  "/?sort=oldest",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Invalid cursor",
+			urlPath:  "/?after=bogus",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Invalid limit",
+			urlPath:  "/?limit=-1",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Empty form",
+			urlPath:  "/search",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Match",
+			urlPath:  "/search?q=silent",
+			wantCode: http.StatusOK,
+			wantBody: "An old <mark>silent</mark> pond",
+		},
+		{
+			name:     "Filtered out",
+			urlPath:  "/search?q=silent&tag=limerick",
+			wantCode: http.StatusOK,
+			wantBody: "No posts matched your search.",
+		},
+		{
+			name:     "Author filter",
+			urlPath:  "/search?author=Alice",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Invalid date",
+			urlPath:  "/search?q=pond&from=yesterday",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a date",
+		},
+		{
+			name:     "Reversed dates",
+			urlPath:  "/search?q=pond&from=2024-02-01&to=2024-01-01",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be before the from date",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestTagView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Tagged",
+			urlPath:  "/tag/haiku",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Upper case",
+			urlPath:  "/tag/HAIKU",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Unused tag",
+			urlPath:  "/tag/limerick",
+			wantCode: http.StatusOK,
+			wantBody: "No posts carry this tag.",
+		},
+		{
+			name:     "Invalid tag",
+			urlPath:  "/tag/a%2Fb",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetRawAndDownload(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	id := mocks.MockSnippet.ID.Hex()
+
+	code, headers, body := ts.get(t, "/snippet/raw/"+id)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "text/plain; charset=utf-8")
+	assert.Equal(t, headers.Get("Content-Disposition"), "")
+	assert.Equal(t, body, mocks.MockSnippet.Content)
+
+	code, headers, body = ts.get(t, "/snippet/download/"+id)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "text/plain; charset=utf-8")
+	assert.Equal(t, headers.Get("Content-Disposition"), `attachment; filename=an-old-silent-pond.txt`)
+	assert.Equal(t, body, mocks.MockSnippet.Content)
+
+	for _, path := range []string{"/snippet/raw/", "/snippet/download/"} {
+		code, _, _ = ts.get(t, path+primitive.NewObjectID().Hex())
+		assert.Equal(t, code, http.StatusNotFound)
+		code, _, _ = ts.get(t, path+"foo")
+		assert.Equal(t, code, http.StatusNotFound)
+	}
+}
+
+func TestSnippetEmbed(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	id := mocks.MockSnippet.ID.Hex()
+
+	code, headers, body := ts.get(t, "/snippet/embed/"+id)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("X-Frame-Options"), "")
+	assert.StringContains(t, headers.Get("Content-Security-Policy"), "frame-ancestors *")
+	assert.StringContains(t, headers.Get("Content-Security-Policy"), "default-src 'self'")
+	assert.StringContains(t, body, mocks.MockSnippet.Content)
+	assert.StringContains(t, body, ts.URL+"/snippet/view/"+id)
+
+	code, headers, body = ts.get(t, "/snippet/widget/"+id)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "text/javascript; charset=utf-8")
+	assert.StringContains(t, body, `frame.src = "`+ts.URL+`/snippet/embed/`+id+`"`)
+
+	code, _, _ = ts.get(t, "/snippet/embed/"+primitive.NewObjectID().Hex())
+	assert.Equal(t, code, http.StatusNotFound)
+
+	// Only the embed routes may be framed.
+	_, headers, body = ts.get(t, "/snippet/view/"+id)
+	assert.Equal(t, headers.Get("X-Frame-Options"), "deny")
+	assert.Equal(t, strings.Contains(headers.Get("Content-Security-Policy"), "frame-ancestors"), false)
+	assert.StringContains(t, body, ts.URL+"/snippet/widget/"+id)
+}
+
+func TestDownloadFilename(t *testing.T) {
+	tests := []struct {
+		title    string
+		language string
+		want     string
+	}{
+		{"An old silent pond", "plaintext", "an-old-silent-pond.txt"},
+		{"  Hello, World!  ", "go", "hello-world.go"},
+		{"../../etc/passwd", "", "etc-passwd.txt"},
+		{"Привет мир", "python", "привет-мир.py"},
+		{"!!!", "cobol", "snippet.txt"},
+		{strings.Repeat("ab ", 40), "", strings.Repeat("ab-", 21) + "a.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			assert.Equal(t, downloadFilename(tt.title, tt.language), tt.want)
+		})
+	}
+}
+
+func TestSnippetLanguage(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+
+	tests := []struct {
+		name         string
+		content      string
+		language     string
+		wantCode     int
+		wantLanguage string
+		wantBody     string
+	}{
+		{
+			name:         "Detected",
+			content:      "package main\n\nfunc main() {}",
+			wantCode:     http.StatusSeeOther,
+			wantLanguage: "Go",
+			wantBody:     `<span class="kd">func</span>`,
+		},
+		{
+			name:         "Chosen",
+			content:      "package main\n\nfunc main() {}",
+			language:     "plaintext",
+			wantCode:     http.StatusSeeOther,
+			wantLanguage: "Plain text",
+			wantBody:     `href="#L3"`,
+		},
+		{
+			name:     "Unknown",
+			content:  "IDENTIFICATION DIVISION.",
+			language: "cobol",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Main")
+			form.Add("content", tt.content)
+			form.Add("tags", "go")
+			form.Add("language", tt.language)
+			form.Add("expires", "0")
+			form.Add("csrf_token", csrfToken)
+			code, headers, _ := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			if code != http.StatusSeeOther {
+				return
+			}
+			_, _, body := ts.get(t, headers.Get("Location"))
+			assert.StringContains(t, body, "<span class='language'>"+tt.wantLanguage+"</span>")
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+
+	form := url.Values{}
+	form.Add("title", "Notes")
+	form.Add("content", "# Notes\n\n<script>alert(1)</script>\n\n[bad](javascript:alert(1)) [good](https://example.com)")
+	form.Add("tags", "notes")
+	form.Add("language", "markdown")
+	form.Add("expires", "0")
+	form.Add("csrf_token", csrfToken)
+	code, headers, _ := ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	path := headers.Get("Location")
+	id := strings.TrimPrefix(path, "/snippet/view/")
+
+	tests := []struct {
+		name     string
+		content  string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "Markdown comment",
+			content:  "**bold** <i>raw</i>",
+			markdown: "true",
+			want:     "<strong>bold</strong>",
+		},
+		{
+			name:    "Plain comment",
+			content: "**stars** <i>raw</i>",
+			want:    "**stars** &lt;i&gt;raw&lt;/i&gt;",
+		},
+	}
+	for _, tt := range tests {
+		form := url.Values{}
+		form.Add("content", tt.content)
+		form.Add("markdown", tt.markdown)
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/snippet/addCommentary/"+id, form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+
+	_, _, body := ts.get(t, path)
+	assert.StringContains(t, body, "<h1>Notes</h1>")
+	assert.StringContains(t, body, `<a href="https://example.com" rel="nofollow noopener ugc">good</a>`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.StringContains(t, body, tt.want)
+		})
+	}
+	for _, unsafe := range []string{"<script>", "javascript:", "<i>"} {
+		assert.Equal(t, strings.Contains(body, unsafe), false)
+	}
+}
+
+func TestSnippetHistory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	id := mocks.MockSnippet.ID.Hex()
+
+	csrfToken := ts.login(t, mocks.MockUser.Email, mocks.MockUserPassword)
+	form := url.Values{}
+	form.Add("title", "A new silent pond")
+	form.Add("content", "An old silent pond...\nA frog leaps in.")
+	form.Add("tags", "haiku")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/snippet/edit/"+id, form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, _, body := ts.get(t, "/snippet/history/"+id)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "#2")
+	assert.StringContains(t, body, "A new silent pond")
+	assert.StringContains(t, body, "Restore")
+
+	code, _, body = ts.get(t, "/snippet/diff/"+id)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<tr class='insert'>")
+	assert.StringContains(t, body, "A frog leaps in.")
+
+	code, headers, body := ts.get(t, "/snippet/diff/"+id+"?from=1&to=2&format=patch")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "text/plain; charset=utf-8")
+	assert.StringContains(t, body, "--- revision 1\n+++ revision 2\n")
+	assert.StringContains(t, body, "+A frog leaps in.")
+
+	for _, query := range []string{"?from=x", "?to=-1"} {
+		code, _, _ = ts.get(t, "/snippet/diff/"+id+query)
+		assert.Equal(t, code, http.StatusBadRequest)
+	}
+	code, _, _ = ts.get(t, "/snippet/diff/"+id+"?to=9")
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, _ = ts.get(t, "/snippet/history/"+primitive.NewObjectID().Hex())
+	assert.Equal(t, code, http.StatusNotFound)
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	restore := url.Values{}
+	restore.Add("revision", "1")
+	restore.Add("csrf_token", other.login(t, mocks.DupeEmail, mocks.MockUserPassword))
+	code, _, _ = other.postForm(t, "/snippet/restore/"+id, restore)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	restore.Set("csrf_token", csrfToken)
+	restore.Set("revision", "9")
+	code, _, _ = ts.postForm(t, "/snippet/restore/"+id, restore)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	restore.Set("revision", "1")
+	code, _, _ = ts.postForm(t, "/snippet/restore/"+id, restore)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = ts.get(t, "/snippet/view/"+id)
+	assert.StringContains(t, body, mocks.MockSnippet.Title)
+	_, _, body = ts.get(t, "/snippet/history/"+id)
+	assert.StringContains(t, body, "(restored #1)")
+}
+
+func TestSnippetFork(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	id := mocks.MockSnippet.ID.Hex()
+
+	form := url.Values{}
+	form.Add("csrf_token", ts.login(t, mocks.DupeEmail, mocks.MockUserPassword))
+	code, _, _ := ts.postForm(t, "/snippet/fork/"+primitive.NewObjectID().Hex(), form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	code, headers, _ := ts.postForm(t, "/snippet/fork/"+id, form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	location := headers.Get("Location")
+	forkID := strings.TrimPrefix(location, "/snippet/view/")
+	assert.Equal(t, forkID != id, true)
+
+	_, _, body := ts.get(t, location)
+	assert.StringContains(t, body, "Forked from <a href='/snippet/view/"+id+"'>")
+	assert.StringContains(t, body, mocks.MockSnippet.Content)
+	assert.StringContains(t, body, "Forks: 0")
+
+	_, _, body = ts.get(t, "/snippet/view/"+id)
+	assert.StringContains(t, body, "Forks: 1")
+	assert.StringContains(t, body, "<a href='/snippet/view/"+forkID+"'>")
+
+	// The fork is the forker's to edit.
+	code, _, _ = ts.get(t, "/snippet/edit/"+forkID)
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestSnippetVisibility(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	author := mocks.MockUser
+	unlistedID, err := app.snippets.Insert("Unlisted haiku", "By the link only", []string{"secret"}, "", models.VisibilityUnlisted, author.Name, author.ID.Hex(), 0)
+	assert.NilError(t, err)
+	privateID, err := app.snippets.Insert("Private haiku", "For me alone", []string{"secret"}, "", models.VisibilityPrivate, author.Name, author.ID.Hex(), 0)
+	assert.NilError(t, err)
+	unlisted, private := unlistedID.Hex(), privateID.Hex()
+
+	// Neither is listed, but the unlisted one is reachable by its link.
+	for _, path := range []string{"/", "/tag/secret", "/search?q=haiku", "/account/view/" + author.ID.Hex()} {
+		_, _, body := ts.get(t, path)
+		assert.Equal(t, strings.Contains(body, "Unlisted haiku"), false)
+		assert.Equal(t, strings.Contains(body, "Private haiku"), false)
+	}
+	code, _, _ := ts.get(t, "/snippet/view/"+unlisted)
+	assert.Equal(t, code, http.StatusOK)
+	for _, path := range []string{"/snippet/view/", "/snippet/raw/", "/snippet/embed/", "/snippet/history/"} {
+		code, _, _ = ts.get(t, path+private)
+		assert.Equal(t, code, http.StatusNotFound)
+	}
+
+	// Other users can't get at the private one either.
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	form := url.Values{}
+	form.Add("csrf_token", other.login(t, mocks.DupeEmail, mocks.MockUserPassword))
+	code, _, _ = other.get(t, "/snippet/view/"+private)
+	assert.Equal(t, code, http.StatusNotFound)
+	for _, path := range []string{"/snippet/addFavourite/", "/snippet/fork/"} {
+		code, _, _ = other.postForm(t, path+private, form)
+		assert.Equal(t, code, http.StatusNotFound)
+	}
+	form.Set("content", "Let me in")
+	code, _, _ = other.postForm(t, "/snippet/addCommentary/"+private, form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	// The author sees both, on their account too.
+	ts.login(t, author.Email, mocks.MockUserPassword)
+	code, _, body := ts.get(t, "/snippet/view/"+private)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<span class='visibility'>private</span>")
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, "Unlisted haiku")
+	assert.StringContains(t, body, "Private haiku")
+}
+
+func TestCommentaryThreads(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	id := mocks.MockSnippet.ID.Hex()
+
+	form := url.Values{}
+	form.Add("csrf_token", ts.login(t, mocks.DupeEmail, mocks.MockUserPassword))
+	form.Set("content", "First!")
+	code, _, _ := ts.postForm(t, "/snippet/addCommentary/"+id, form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	page, err := app.commentary.Commentaries(mocks.MockSnippet.ID, models.CommentQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Commentaries), 1)
+	commentID := page.Commentaries[0].ID.Hex()
+
+	tests := []struct {
+		name     string
+		parent   string
+		wantCode int
+	}{
+		{"Reply", commentID, http.StatusSeeOther},
+		{"Missing parent", primitive.NewObjectID().Hex(), http.StatusNotFound},
+		{"Malformed parent", "nope", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form.Set("content", "A reply")
+			form.Set("parent", tt.parent)
+			code, _, _ := ts.postForm(t, "/snippet/addCommentary/"+id, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+	form.Del("parent")
+
+	_, _, body := ts.get(t, "/snippet/view/"+id)
+	assert.StringContains(t, body, "<div class='replies'>")
+	assert.StringContains(t, body, "A reply")
+	assert.StringContains(t, body, "<form action='/snippet/deleteCommentary/"+id+"/"+commentID+"' method='POST'>")
+
+	// Someone who wrote neither the comment nor the post can't touch it.
+	addUser(t, app, "Eve", "eve@example.com")
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	otherForm := url.Values{}
+	otherForm.Add("csrf_token", other.login(t, "eve@example.com", mocks.MockUserPassword))
+	otherForm.Set("content", "Defaced")
+	code, _, _ = other.postForm(t, "/snippet/editCommentary/"+id+"/"+commentID, otherForm)
+	assert.Equal(t, code, http.StatusForbidden)
+	code, _, _ = other.postForm(t, "/snippet/deleteCommentary/"+id+"/"+commentID, otherForm)
+	assert.Equal(t, code, http.StatusForbidden)
+	_, _, body = other.get(t, "/snippet/view/"+id)
+	assert.Equal(t, strings.Contains(body, "/snippet/deleteCommentary/"), false)
+
+	form.Set("content", "")
+	code, _, _ = ts.postForm(t, "/snippet/editCommentary/"+id+"/"+commentID, form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	form.Set("content", "First, edited")
+	code, _, _ = ts.postForm(t, "/snippet/editCommentary/"+id+"/"+commentID, form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = ts.postForm(t, "/snippet/editCommentary/"+id+"/"+primitive.NewObjectID().Hex(), form)
+	assert.Equal(t, code, http.StatusNotFound)
+	_, _, body = ts.get(t, "/snippet/view/"+id)
+	assert.StringContains(t, body, "First, edited")
+	assert.StringContains(t, body, "(edited)")
+
+	// The author of the post may delete the comment, and its reply goes
+	// with it.
+	author := newTestServer(t, app.routes())
+	defer author.Close()
+	authorForm := url.Values{}
+	authorForm.Add("csrf_token", author.login(t, mocks.MockUser.Email, mocks.MockUserPassword))
+	code, _, _ = author.postForm(t, "/snippet/deleteCommentary/"+id+"/"+commentID, authorForm)
+	assert.Equal(t, code, http.StatusSeeOther)
+	page, err = app.commentary.Commentaries(mocks.MockSnippet.ID, models.CommentQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Commentaries), 0)
+	snippet, err := app.snippets.Get(mocks.MockSnippet.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Commented, 0)
+}
+
+func TestCommentaryPages(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	id := mocks.MockSnippet.ID
+	for i := 0; i < models.DefaultCommentLimit+1; i++ {
+		_, err := app.commentary.AddComentary(id, primitive.NilObjectID, map[string]string{"Bob": ""}, fmt.Sprintf("Comment %d", i), false)
+		assert.NilError(t, err)
+	}
+
+	_, _, body := ts.get(t, "/snippet/view/"+id.Hex())
+	assert.StringContains(t, body, "Comment 20")
+	assert.Equal(t, strings.Contains(body, "Comment 0<"), false)
+	next := regexp.MustCompile(`\?after=([0-9a-f]{24})#comments`).FindStringSubmatch(body)
+	assert.Equal(t, len(next), 2)
+
+	_, _, body = ts.get(t, "/snippet/view/"+id.Hex()+"?after="+next[1])
+	assert.StringContains(t, body, "Comment 0<")
+	assert.StringContains(t, body, "Newer comments")
+	assert.Equal(t, strings.Contains(body, "Comment 20"), false)
+
+	code, _, _ := ts.get(t, "/snippet/view/"+id.Hex()+"?after=nope")
+	assert.Equal(t, code, http.StatusBadRequest)
+}
@@ -6,7 +6,14 @@ import (
 	"fmt"
 	"net/http"
 	"runtime/debug"
+	"slices"
+	"snippetbox/internal/highlight"
+	"snippetbox/internal/models"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-playground/form"
 	"github.com/justinas/nosurf"
@@ -50,12 +57,19 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 	buf.WriteTo(w)
 }
 func (app *application) newTemplateData(r *http.Request) templateData {
-	return templateData{
+	data := templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken:       nosurf.Token(r),
 	}
+	if data.IsAuthenticated {
+		data.AuthenticatedUserID = app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	}
+	if app.oidc != nil {
+		data.SSOName = app.ssoName
+	}
+	return data
 }
 func (app *application) decodePostForm(r *http.Request, dst any) error {
 
@@ -82,3 +96,129 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// pageQuery reads the sort, limit, after and before parameters of a listing
+// from the URL query. Any error means the client sent a malformed query.
+func (app *application) pageQuery(r *http.Request) (models.PageQuery, error) {
+	query := r.URL.Query()
+	q := models.PageQuery{
+		Sort:   query.Get("sort"),
+		After:  query.Get("after"),
+		Before: query.Get("before"),
+	}
+	if q.Sort != "" && !slices.Contains(models.SortOptions, q.Sort) {
+		return q, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	if q.After != "" && q.Before != "" {
+		return q, errors.New("after and before are mutually exclusive")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid limit %q", limit)
+		}
+		q.Limit = n
+	}
+	if _, _, err := q.Cursor(); err != nil {
+		return q, err
+	}
+	return q.Normalize(), nil
+}
+
+// commentQuery reads which page of comments to show from the query string.
+func (app *application) commentQuery(r *http.Request) (models.CommentQuery, error) {
+	query := r.URL.Query()
+	q := models.CommentQuery{
+		After:  query.Get("after"),
+		Before: query.Get("before"),
+	}
+	if q.After != "" && q.Before != "" {
+		return q, errors.New("after and before are mutually exclusive")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid limit %q", limit)
+		}
+		q.Limit = n
+	}
+	if _, _, err := q.Cursor(); err != nil {
+		return q, err
+	}
+	return q.Normalize(), nil
+}
+
+// widgetScript is the script served by snippetWidget, with the URL of the
+// embed page to be filled in as a JSON string.
+const widgetScript = `(function () {
+	var script = document.currentScript;
+	var frame = document.createElement("iframe");
+	frame.src = %s;
+	frame.title = "Snippet";
+	frame.loading = "lazy";
+	frame.style.width = "100%%";
+	frame.style.height = "320px";
+	frame.style.border = "0";
+	script.parentNode.insertBefore(frame, script);
+})();
+`
+
+// baseURL is the scheme and host the request was made to, for links that
+// have to work from other sites.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// groupSecret splits an authenticator secret into groups of four, which
+// are easier to type by hand.
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
+
+func embedURL(r *http.Request, snippet models.Snippet) string {
+	return baseURL(r) + "/snippet/embed/" + snippet.ID.Hex()
+}
+
+// downloadFilename turns a snippet title into a file name: runs of anything
+// but letters and digits become a single dash, and the result is lower-cased
+// and given the extension of the snippet's language.
+func downloadFilename(title, language string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		} else {
+			dash = true
+		}
+	}
+	name := b.String()
+	if len(name) > 64 {
+		name = name[:64]
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+		name = strings.TrimRight(name, "-")
+	}
+	if name == "" {
+		name = "snippet"
+	}
+	ext := ".txt"
+	if lang, ok := highlight.Lookup(language); ok {
+		ext = lang.Extension
+	}
+	return name + ext
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// lifecycle tracks what has to happen when the server stops: background
+// goroutines to wait for and shutdown hooks to run once requests have
+// drained. Hooks run in reverse order of registration, like deferred calls,
+// so resources are released before whatever they depend on.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	hooks  []shutdownHook
+}
+
+type shutdownHook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// onShutdown registers fn to be called after the HTTP server has drained.
+func (app *application) onShutdown(name string, fn func(context.Context) error) {
+	app.lifecycle.mu.Lock()
+	defer app.lifecycle.mu.Unlock()
+	app.lifecycle.hooks = append(app.lifecycle.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// background runs fn in a goroutine that shutdown will wait for. The context
+// passed to fn is cancelled as soon as shutdown starts, which long-running
+// workers should treat as their signal to return.
+func (app *application) background(fn func(ctx context.Context)) {
+	app.lifecycle.wg.Add(1)
+	go func() {
+		defer app.lifecycle.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%s", err))
+			}
+		}()
+		fn(app.lifecycle.ctx)
+	}()
+}
+
+// serve calls start, which is expected to block serving srv, until ctx is
+// done, SIGINT or SIGTERM is received, or start fails. It then stops
+// accepting connections, gives in-flight requests and background goroutines
+// up to timeout to finish, and runs the shutdown hooks.
+func (app *application) serve(ctx context.Context, srv *http.Server, timeout time.Duration, start func() error) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- start()
+	}()
+
+	var errs []error
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, err)
+		}
+	case <-ctx.Done():
+		app.logger.Info("shutting down server", "timeout", timeout)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("draining requests: %w", err))
+	}
+
+	app.lifecycle.cancel()
+	err = app.waitForBackground(shutdownCtx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("waiting for background tasks: %w", err))
+	}
+
+	app.lifecycle.mu.Lock()
+	hooks := app.lifecycle.hooks
+	app.lifecycle.mu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := hooks[i].fn(shutdownCtx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", hooks[i].name, err))
+			continue
+		}
+		app.logger.Info("stopped", "component", hooks[i].name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (app *application) waitForBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		app.lifecycle.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
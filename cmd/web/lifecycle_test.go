@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+// startServer serves h on a random local port through app.serve and returns
+// the server's base URL and a channel that receives serve's result.
+func startServer(t *testing.T, app *application, ctx context.Context, timeout time.Duration, h http.Handler) (string, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: h}
+	done := make(chan error, 1)
+	go func() {
+		done <- app.serve(ctx, srv, timeout, func() error {
+			return srv.Serve(l)
+		})
+	}()
+	return "http://" + l.Addr().String(), done
+}
+
+func TestServeDrainsRequests(t *testing.T) {
+	app := newTestApplication(t)
+
+	var order []string
+	app.onShutdown("first", func(context.Context) error {
+		order = append(order, "first")
+		return nil
+	})
+	app.onShutdown("second", func(context.Context) error {
+		order = append(order, "second")
+		return nil
+	})
+
+	workerStopped := make(chan struct{})
+	app.background(func(ctx context.Context) {
+		<-ctx.Done()
+		close(workerStopped)
+	})
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write([]byte("drained"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, done := startServer(t, app, ctx, 5*time.Second, h)
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		rs, err := http.Get(url)
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer rs.Body.Close()
+		body, err := io.ReadAll(rs.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+
+	<-entered
+	cancel()
+
+	select {
+	case err := <-done:
+		t.Fatalf("serve returned before the request drained: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	rs := <-responses
+	assert.NilError(t, rs.err)
+	assert.Equal(t, rs.body, "drained")
+
+	assert.NilError(t, <-done)
+	<-workerStopped
+	assert.Equal(t, strings.Join(order, ","), "second,first")
+}
+
+func TestServeShutdownDeadline(t *testing.T) {
+	app := newTestApplication(t)
+	hookRan := false
+	app.onShutdown("hook", func(context.Context) error {
+		hookRan = true
+		return nil
+	})
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, done := startServer(t, app, ctx, 50*time.Millisecond, h)
+	go http.Get(url)
+
+	<-entered
+	cancel()
+
+	err := <-done
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got: %v; want: %v", err, context.DeadlineExceeded)
+	}
+	assert.StringContains(t, err.Error(), "draining requests")
+	assert.Equal(t, hookRan, true)
+}
+
+func TestServeSignal(t *testing.T) {
+	app := newTestApplication(t)
+	hookRan := make(chan struct{})
+	app.onShutdown("hook", func(context.Context) error {
+		close(hookRan)
+		return nil
+	})
+
+	ready := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(ready)
+	})
+	url, done := startServer(t, app, context.Background(), time.Second, h)
+	rs, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	<-ready
+
+	err = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NilError(t, <-done)
+	<-hookRan
+}
+
+func TestServeStartError(t *testing.T) {
+	app := newTestApplication(t)
+	hookRan := false
+	app.onShutdown("hook", func(context.Context) error {
+		hookRan = true
+		return errors.New("boom")
+	})
+
+	srv := &http.Server{}
+	err := app.serve(context.Background(), srv, time.Second, func() error {
+		return errors.New("address in use")
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.StringContains(t, err.Error(), "address in use")
+	assert.StringContains(t, err.Error(), "hook: boom")
+	assert.Equal(t, hookRan, true)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"snippetbox/internal/models"
+	"snippetbox/internal/throttle"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// securityEventsShown is how many audit entries the account page lists.
+const securityEventsShown = 10
+
+const tooManyLogins = "Too many failed login attempts. Please try again later."
+
+// newLoginLimiters returns the limiters of failed logins per client IP and
+// per account. An account is locked out for lockout after maxFailures in a
+// row; an IP, which many users may share, only ever has to slow down.
+func newLoginLimiters(maxFailures int, lockout time.Duration) (ips, accounts *throttle.Limiter) {
+	ips = &throttle.Limiter{
+		Free:   2 * maxFailures,
+		Base:   time.Second,
+		Max:    time.Minute,
+		Forget: time.Hour,
+	}
+	accounts = &throttle.Limiter{
+		Free:      3,
+		Base:      time.Second,
+		Max:       time.Minute,
+		LockAfter: maxFailures,
+		LockFor:   lockout,
+		Forget:    time.Hour,
+	}
+	return ips, accounts
+}
+
+// accountKey is the key an email address is throttled under. It is used
+// whether or not an account has the address, so that being throttled
+// doesn't tell which ones do.
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// clientIP returns the IP address of the client. X-Forwarded-For and the
+// like are ignored, since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginWait returns how long the client must wait before trying to log in
+// to the account with email again, zero if it may now.
+func (app *application) loginWait(r *http.Request, email string) time.Duration {
+	return max(app.loginIPs.Wait(clientIP(r)), app.loginAccounts.Wait(accountKey(email)))
+}
+
+// loginFailed records a failed login to the account with email, which
+// need not exist, and audits the lockout if it is the failure that locked
+// the account.
+func (app *application) loginFailed(r *http.Request, email string) error {
+	ip := clientIP(r)
+	app.loginIPs.Fail(ip)
+	if !app.loginAccounts.Fail(accountKey(email)) {
+		return nil
+	}
+	var userID primitive.ObjectID
+	user, err := app.users.GetByEmail(email)
+	if err == nil {
+		userID = user.ID
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+	app.logger.Warn("account locked out", "email", email, "ip", ip)
+	return app.users.AddAuditEntry(models.NewAuditEntry(models.AuditLoginLockout, userID, email, ip))
+}
+
+// confirmPassword checks password against that of user, for the forms that
+// ask for it again. Wrong passwords count as failed logins, so that a
+// hijacked session can't be used to guess it. A throttled client gets the
+// time it has to wait instead, without the password being checked.
+func (app *application) confirmPassword(r *http.Request, user models.User, password string) (bool, time.Duration, error) {
+	if wait := app.loginWait(r, user.Email); wait > 0 {
+		return false, wait, nil
+	}
+	_, _, err := app.users.Authenticate(user.Email, password)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		return false, 0, app.loginFailed(r, user.Email)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	app.loginSucceeded(user.Email)
+	return true, 0, nil
+}
+
+// loginSucceeded forgets the failed logins to the account with email.
+func (app *application) loginSucceeded(email string) {
+	app.loginAccounts.Reset(accountKey(email))
+}
+
+// setRetryAfter tells the client how many seconds to wait before trying
+// again.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+}
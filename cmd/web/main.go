@@ -3,21 +3,31 @@ package main
 //ngrok http --domain=humane-titmouse-pure.ngrok-free.app https://localhost:4000/
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"snippetbox/internal/authtoken"
+	"snippetbox/internal/config"
+	"snippetbox/internal/mailer"
 	"snippetbox/internal/models"
+	"snippetbox/internal/models/memory"
+	"snippetbox/internal/models/sqlstore"
+	"snippetbox/internal/oidc"
+	"snippetbox/internal/throttle"
+	"strings"
 	"text/template"
 	"time"
 
 	"github.com/alexedwards/scs/mongodbstore"
+	"github.com/alexedwards/scs/mysqlstore"
+	"github.com/alexedwards/scs/sqlite3store"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form"
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -25,39 +35,53 @@ import (
 
 type application struct {
 	logger         *slog.Logger
-	snippets       models.SnippetModel
-	users          models.UserModel
-	commentary     models.CommentaryModel
+	snippets       models.SnippetStore
+	users          models.UserStore
+	commentary     models.CommentaryStore
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	lifecycle      *lifecycle
+	mailer         mailer.Mailer
+	// signer signs the tokens in email links, and publicURL, if set, is
+	// the URL they point to.
+	signer    *authtoken.Signer
+	publicURL string
+	// loginIPs and loginAccounts throttle failed logins per client IP and
+	// per account.
+	loginIPs      *throttle.Limiter
+	loginAccounts *throttle.Limiter
+	// oidc is the provider users can log in with instead of a password,
+	// nil unless single sign-on is configured, and ssoName labels it.
+	oidc    *oidc.Provider
+	ssoName string
 }
 
 func main() {
-	addr := flag.String("addr", ":4000", "HTTP network address")
-	flag.Parse()
-	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-	err := godotenv.Load("../../mongo.env")
-	if err != nil {
-		panic(err)
-	}
-	uri := os.Getenv("MONGODB_URI")
-	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
-	client, err := mongo.Connect(context.TODO(), opts)
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.Getenv)
 	if err != nil {
-		panic(err)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	defer func() {
-		if err = client.Disconnect(context.TODO()); err != nil {
-			panic(err)
+	if cfg.DumpConfig {
+		err := cfg.Dump(os.Stdout)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
 		}
-	}()
-	if err := client.Database("snippetbox").RunCommand(context.TODO(), bson.D{{Key: "ping", Value: 1}}).Err(); err != nil {
-		panic(err)
+		return
+	}
+	err = cfg.Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	fmt.Println("Pinged your deployment. You successfully connected to MongoDB!")
+
 	templateCache, err := newTemplateCache()
 	if err != nil {
 		logger.Error(err.Error())
@@ -65,38 +89,152 @@ func main() {
 	}
 	formDecoder := form.NewDecoder()
 	sessionManager := scs.New()
-	sessionManager.Store = mongodbstore.New(client.Database("snippetbox"))
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Lifetime = cfg.Session.Lifetime.Duration
 
 	sessionManager.Cookie.Secure = true
 	app := &application{
 		logger:         logger,
-		snippets:       models.SnippetModel{Client: client},
-		users:          models.UserModel{Client: client},
-		commentary:     models.CommentaryModel{Client: client},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		lifecycle:      newLifecycle(),
+		publicURL:      strings.TrimSuffix(cfg.BaseURL, "/"),
 	}
+	app.loginIPs, app.loginAccounts = newLoginLimiters(cfg.Login.MaxFailures, cfg.Login.Lockout.Duration)
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Warn("no secret configured, so links in emails will stop working when the server restarts")
+	}
+	app.signer = authtoken.NewSigner(secret)
+
+	switch cfg.Mail.Transport {
+	case "smtp":
+		app.mailer = &mailer.SMTP{
+			Addr:     cfg.Mail.SMTP.Addr,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+			From:     cfg.Mail.From,
+		}
+	case "file":
+		app.mailer = &mailer.File{Dir: cfg.Mail.Dir, From: cfg.Mail.From}
+	default:
+		app.mailer = &mailer.Log{Logger: logger}
+	}
+
+	if cfg.OIDC.Issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		client := &http.Client{Timeout: 10 * time.Second}
+		app.oidc, err = oidc.Discover(ctx, cfg.OIDC.Issuer, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret, client)
+		cancel()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		app.ssoName = cfg.OIDC.Name
+		logger.Info("single sign-on enabled", "issuer", cfg.OIDC.Issuer)
+	}
+
+	switch cfg.Store {
+	case "mongo":
+		client, err := openMongo(cfg.Mongo.URI, cfg.Mongo.Database)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		app.onShutdown("mongo", client.Disconnect)
+		logger.Info("connected to MongoDB", "database", cfg.Mongo.Database)
+		db := client.Database(cfg.Mongo.Database)
+		err = models.Migrate(db)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		sessionManager.Store = mongodbstore.New(db)
+		app.snippets = &models.SnippetModel{DB: db}
+		app.users = &models.UserModel{DB: db}
+		app.commentary = &models.CommentaryModel{DB: db}
+	case sqlstore.MySQL, sqlstore.SQLite:
+		db, err := sqlstore.Open(cfg.Store, cfg.DSN)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		app.onShutdown("database", func(context.Context) error {
+			return db.Close()
+		})
+		if cfg.Store == sqlstore.MySQL {
+			sessionManager.Store = mysqlstore.New(db)
+		} else {
+			sessionManager.Store = sqlite3store.New(db)
+		}
+		app.snippets = &sqlstore.SnippetModel{DB: db}
+		app.users = &sqlstore.UserModel{DB: db}
+		app.commentary = &sqlstore.CommentaryModel{DB: db}
+	case "memory":
+		db := memory.New()
+		app.snippets = &memory.SnippetModel{DB: db}
+		app.users = &memory.UserModel{DB: db}
+		app.commentary = &memory.CommentaryModel{DB: db}
+	}
+	app.onShutdown("session store", func(context.Context) error {
+		// Sessions are committed at the end of each request, so once requests
+		// have drained only the store's expiry sweeper is left to stop.
+		if store, ok := sessionManager.Store.(interface{ StopCleanup() }); ok {
+			store.StopCleanup()
+		}
+		return nil
+	})
+
+	app.background(func(ctx context.Context) {
+		app.sweepExpired(ctx, cfg.SweepInterval.Duration)
+	})
+	app.background(func(ctx context.Context) {
+		app.sweepLimiters(ctx, cfg.SweepInterval.Duration)
+	})
 
 	tlsConfig := &tls.Config{
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
 
 	srv := &http.Server{
-		Addr:         *addr,
+		Addr:         cfg.Addr,
 		Handler:      app.routes(),
 		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		TLSConfig:    tlsConfig,
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  cfg.Server.IdleTimeout.Duration,
+		ReadTimeout:  cfg.Server.ReadTimeout.Duration,
+		WriteTimeout: cfg.Server.WriteTimeout.Duration,
 	}
 
-	logger.Info("starting server", "addr", srv.Addr)
+	logger.Info("starting server", "addr", srv.Addr, "store", cfg.Store)
 
-	err = srv.ListenAndServeTLS("../../tls/cert.pem", "../../tls/key.pem")
+	err = app.serve(context.Background(), srv, cfg.Server.ShutdownTimeout.Duration, func() error {
+		return srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger.Info("server stopped")
+}
 
-	logger.Error(err.Error())
-	os.Exit(1)
+func openMongo(uri, database string) (*mongo.Client, error) {
+	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
+	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Database(database).RunCommand(context.TODO(), bson.D{{Key: "ping", Value: 1}}).Err(); err != nil {
+		client.Disconnect(context.TODO())
+		return nil, err
+	}
+	return client, nil
 }
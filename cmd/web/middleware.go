@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"snippetbox/internal/models"
+	"strings"
 
 	"github.com/justinas/nosurf"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// contentSecurityPolicy is the CSP of every page. It doesn't allow inline
+// scripts or styles, so templates must not use them.
+const contentSecurityPolicy = "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
+
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Security-Policy",
-			"default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
 
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -23,6 +30,18 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// allowFraming lifts the ban on framing set by secureHeaders, for the embed
+// routes only: any site may show them in an iframe. The rest of the CSP is
+// kept.
+func allowFraming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy+"; frame-ancestors *")
+		w.Header().Del("X-Frame-Options")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
@@ -70,25 +89,144 @@ func noSurf(next http.Handler) http.Handler {
 
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		idStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
-		if idStr == "" {
+		_, ok, err := app.sessionUser(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if ok {
+			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionUser returns the user logged in to the session, provided they
+// still have an account. Their name is refreshed in the session, as they
+// may have changed it in another one.
+func (app *application) sessionUser(r *http.Request) (models.User, bool, error) {
+	idStr := app.sessionManager.GetString(r.Context(), "authenticatedUserID")
+	if idStr == "" {
+		return models.User{}, false, nil
+	}
+	id, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return models.User{}, false, err
+	}
+	user, err := app.users.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.User{}, false, nil
+		}
+		return models.User{}, false, err
+	}
+	if app.sessionManager.GetString(r.Context(), "UserName") != user.Name {
+		app.sessionManager.Put(r.Context(), "UserName", user.Name)
+	}
+	return user, true, nil
+}
+
+// apiPrincipal is the user an API request is made on behalf of. CanWrite is
+// false for read-only tokens.
+type apiPrincipal struct {
+	ID       primitive.ObjectID
+	Name     string
+	CanWrite bool
+}
+
+// apiAuthenticate identifies the user of an API request from a bearer token
+// or, failing that, the session cookie. Unlike the HTML routes,
+// unauthenticated requests are let through and left to
+// apiRequireAuthentication, but a bad token is rejected outright.
+func (app *application) apiAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			scheme, plaintext, _ := strings.Cut(authorization, " ")
+			if !strings.EqualFold(scheme, "Bearer") || plaintext == "" {
+				app.apiInvalidToken(w, r)
+				return
+			}
+			token, name, err := app.users.AuthenticateToken(plaintext)
+			if err != nil {
+				if errors.Is(err, models.ErrInvalidCredentials) {
+					app.apiInvalidToken(w, r)
+				} else {
+					app.apiServerError(w, r, err)
+				}
+				return
+			}
+			user := apiPrincipal{ID: token.UserID, Name: name, CanWrite: token.CanWrite()}
+			r = r.WithContext(context.WithValue(r.Context(), apiUserContextKey, user))
 			next.ServeHTTP(w, r)
 			return
 		}
-		id, err := primitive.ObjectIDFromHex(idStr)
+
+		sessionUser, ok, err := app.sessionUser(r)
 		if err != nil {
-			app.serverError(w, r, err)
+			app.apiServerError(w, r, err)
 			return
 		}
-		exists, err := app.users.Exists(id)
-		if err != nil {
-			app.serverError(w, r, err)
+		if ok {
+			user := apiPrincipal{ID: sessionUser.ID, Name: sessionUser.Name, CanWrite: true}
+			r = r.WithContext(context.WithValue(r.Context(), apiUserContextKey, user))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) apiRequireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := apiUserFrom(r); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.apiError(w, r, http.StatusUnauthorized, "you must be authenticated to access this resource")
 			return
 		}
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx)
+		w.Header().Add("Cache-control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRequireWrite rejects requests made with a read-only token. It must come
+// after apiRequireAuthentication.
+func (app *application) apiRequireWrite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := apiUserFrom(r); !user.CanWrite {
+			app.apiError(w, r, http.StatusForbidden, "this token is read-only")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRequireJSON rejects writes whose body is not declared as JSON. The API
+// skips nosurf, and this is what keeps it safe from CSRF: a cross-site form
+// cannot send application/json, nor use PUT or DELETE, without a CORS
+// preflight, which we never allow.
+func (app *application) apiRequireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				app.apiError(w, r, http.StatusUnsupportedMediaType, "requests must have a Content-Type of application/json")
+				return
+			}
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+func apiUserFrom(r *http.Request) (apiPrincipal, bool) {
+	user, ok := r.Context().Value(apiUserContextKey).(apiPrincipal)
+	return user, ok
+}
+
+// apiViewerID returns the hex ID of the user making an API request, or ""
+// for anonymous requests.
+func apiViewerID(r *http.Request) string {
+	if user, ok := apiUserFrom(r); ok {
+		return user.ID.Hex()
+	}
+	return ""
+}
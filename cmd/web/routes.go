@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"snippetbox/ui"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
@@ -11,28 +13,99 @@ import (
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			app.apiNotFound(w, r)
+			return
+		}
 		app.notFound(w)
 	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			app.apiError(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("the %s method is not supported for this resource", r.Method))
+			return
+		}
+		app.clientError(w, http.StatusMethodNotAllowed)
+	})
 
 	fileServer := http.FileServer(http.FS(ui.Files))
 	router.Handler(http.MethodGet, "/static/*filepath", fileServer)
+	router.HandlerFunc(http.MethodGet, "/ping", ping)
 
 	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/raw/:id", dynamic.ThenFunc(app.snippetRaw))
+	router.Handler(http.MethodGet, "/snippet/download/:id", dynamic.ThenFunc(app.snippetDownload))
+	router.Handler(http.MethodGet, "/snippet/history/:id", dynamic.ThenFunc(app.snippetHistory))
+	router.Handler(http.MethodGet, "/snippet/diff/:id", dynamic.ThenFunc(app.snippetDiff))
+	router.Handler(http.MethodGet, "/search", dynamic.ThenFunc(app.search))
+	router.Handler(http.MethodGet, "/tag/:name", dynamic.ThenFunc(app.tagView))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/login/twofactor", dynamic.ThenFunc(app.userLoginTwoFactor))
+	router.Handler(http.MethodPost, "/user/login/twofactor", dynamic.ThenFunc(app.userLoginTwoFactorPost))
+	router.Handler(http.MethodGet, "/user/login/sso", dynamic.ThenFunc(app.userLoginSSO))
+	router.Handler(http.MethodGet, "/user/login/sso/callback", dynamic.ThenFunc(app.userLoginSSOCallback))
+	router.Handler(http.MethodGet, "/user/verify", dynamic.ThenFunc(app.userVerify))
+	router.Handler(http.MethodGet, "/user/verify/resend", dynamic.ThenFunc(app.userVerifyResend))
+	router.Handler(http.MethodPost, "/user/verify/resend", dynamic.ThenFunc(app.userVerifyResendPost))
+	router.Handler(http.MethodGet, "/user/forgot", dynamic.ThenFunc(app.userForgotPassword))
+	router.Handler(http.MethodPost, "/user/forgot", dynamic.ThenFunc(app.userForgotPasswordPost))
+	router.Handler(http.MethodGet, "/user/reset", dynamic.ThenFunc(app.userResetPassword))
+	router.Handler(http.MethodPost, "/user/reset", dynamic.ThenFunc(app.userResetPasswordPost))
+	// Embeds are shown on other sites, where our session cookie isn't sent
+	// anyway.
+	embed := alice.New(allowFraming)
+	router.Handler(http.MethodGet, "/snippet/embed/:id", embed.ThenFunc(app.snippetEmbed))
+	router.Handler(http.MethodGet, "/snippet/widget/:id", embed.ThenFunc(app.snippetWidget))
 	protected := dynamic.Append(app.requireAuthentication)
 	router.Handler(http.MethodPost, "/snippet/addFavourite/:id", dynamic.ThenFunc(app.FavouritePost))
 	router.Handler(http.MethodPost, "/snippet/removeFavourite/:id", dynamic.ThenFunc(app.FavouriteDelete))
 	router.Handler(http.MethodPost, "/snippet/addCommentary/:id", dynamic.ThenFunc(app.CommentaryPost))
 	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
 	router.Handler(http.MethodGet, "/account/view", protected.ThenFunc(app.accountView))
+	router.Handler(http.MethodGet, "/account/settings", protected.ThenFunc(app.accountSettings))
+	router.Handler(http.MethodPost, "/account/settings/name", protected.ThenFunc(app.accountNamePost))
+	router.Handler(http.MethodPost, "/account/settings/email", protected.ThenFunc(app.accountEmailPost))
+	router.Handler(http.MethodPost, "/account/settings/password", protected.ThenFunc(app.accountPasswordPost))
+	router.Handler(http.MethodPost, "/account/settings/delete", protected.ThenFunc(app.accountDeletePost))
+	router.Handler(http.MethodPost, "/account/tokens", protected.ThenFunc(app.apiTokenCreatePost))
+	router.Handler(http.MethodPost, "/account/tokens/revoke/:id", protected.ThenFunc(app.apiTokenRevokePost))
+	router.Handler(http.MethodGet, "/account/twofactor", protected.ThenFunc(app.twoFactorView))
+	router.Handler(http.MethodPost, "/account/twofactor", protected.ThenFunc(app.twoFactorEnablePost))
+	router.Handler(http.MethodGet, "/account/twofactor/qr.png", protected.ThenFunc(app.twoFactorQR))
+	router.Handler(http.MethodPost, "/account/twofactor/disable", protected.ThenFunc(app.twoFactorDisablePost))
+	router.Handler(http.MethodPost, "/account/twofactor/recovery", protected.ThenFunc(app.twoFactorRecoveryPost))
 	router.Handler(http.MethodGet, "/account/view/:id", protected.ThenFunc(app.otherAccountView))
 	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodGet, "/snippet/edit/:id", protected.ThenFunc(app.snippetEdit))
+	router.Handler(http.MethodPost, "/snippet/edit/:id", protected.ThenFunc(app.snippetEditPost))
+	router.Handler(http.MethodPost, "/snippet/delete/:id", protected.ThenFunc(app.snippetDeletePost))
+	router.Handler(http.MethodPost, "/snippet/restore/:id", protected.ThenFunc(app.snippetRestorePost))
+	router.Handler(http.MethodPost, "/snippet/editCommentary/:id/:comment", protected.ThenFunc(app.CommentaryEditPost))
+	router.Handler(http.MethodPost, "/snippet/deleteCommentary/:id/:comment", protected.ThenFunc(app.CommentaryDeletePost))
+	router.Handler(http.MethodPost, "/snippet/fork/:id", protected.ThenFunc(app.snippetForkPost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+
+	// The API takes bearer tokens as well as the session cookie, but has no
+	// CSRF tokens; see apiRequireJSON.
+	api := alice.New(app.sessionManager.LoadAndSave, app.apiAuthenticate, app.apiRequireJSON)
+	apiProtected := api.Append(app.apiRequireAuthentication, app.apiRequireWrite)
+	router.Handler(http.MethodGet, "/api/v1/snippets", api.ThenFunc(app.apiSnippetList))
+	router.Handler(http.MethodPost, "/api/v1/snippets", apiProtected.ThenFunc(app.apiSnippetCreate))
+	router.Handler(http.MethodGet, "/api/v1/snippets/:id", api.ThenFunc(app.apiSnippetGet))
+	router.Handler(http.MethodPut, "/api/v1/snippets/:id", apiProtected.ThenFunc(app.apiSnippetUpdate))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:id", apiProtected.ThenFunc(app.apiSnippetDelete))
+	router.Handler(http.MethodPost, "/api/v1/snippets/:id/favourite", apiProtected.ThenFunc(app.apiFavouriteAdd))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:id/favourite", apiProtected.ThenFunc(app.apiFavouriteRemove))
+	router.Handler(http.MethodGet, "/api/v1/snippets/:id/comments", api.ThenFunc(app.apiCommentList))
+	router.Handler(http.MethodPost, "/api/v1/snippets/:id/comments", apiProtected.ThenFunc(app.apiCommentCreate))
+	router.Handler(http.MethodPut, "/api/v1/snippets/:id/comments/:comment", apiProtected.ThenFunc(app.apiCommentUpdate))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:id/comments/:comment", apiProtected.ThenFunc(app.apiCommentDelete))
+	router.Handler(http.MethodGet, "/api/v1/users/:id", api.ThenFunc(app.apiUserGet))
+
 	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
 	return standard.Then(router)
 }
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"snippetbox/internal/models"
+	"snippetbox/internal/validator"
+)
+
+// accountSettingsForm holds the forms of the settings page. Each asks for
+// the password under its own name, so that errors show on the right one.
+type accountSettingsForm struct {
+	Name                string `form:"name"`
+	Email               string `form:"email"`
+	EmailPassword       string `form:"email_password"`
+	CurrentPassword     string `form:"current_password"`
+	NewPassword         string `form:"new_password"`
+	ConfirmPassword     string `form:"confirm_password"`
+	DeletePassword      string `form:"delete_password"`
+	Content             string `form:"content"`
+	validator.Validator `form:"-"`
+}
+
+// What happens to the snippets and comments of a deleted account.
+const (
+	contentAnonymize = "anonymize"
+	contentRemove    = "remove"
+)
+
+func (app *application) accountSettings(w http.ResponseWriter, r *http.Request) {
+	app.renderSettings(w, r, http.StatusOK, accountSettingsForm{})
+}
+
+// renderSettings renders the settings page of the logged in user. The name
+// and email fields of forms that weren't sent show the current ones.
+func (app *application) renderSettings(w http.ResponseWriter, r *http.Request, status int, form accountSettingsForm) {
+	user, err := app.accountUser(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if form.Name == "" && form.FieldErrors["name"] == "" {
+		form.Name = user.Name
+	}
+	if form.Email == "" && form.FieldErrors["email"] == "" {
+		form.Email = user.Email
+	}
+	if form.Content == "" {
+		form.Content = contentAnonymize
+	}
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+	app.render(w, r, status, "settings.html", data)
+}
+
+// settingsForm decodes a form of the settings page and returns it with the
+// logged in user.
+func (app *application) settingsForm(w http.ResponseWriter, r *http.Request) (accountSettingsForm, models.User, bool) {
+	var form accountSettingsForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return form, models.User{}, false
+	}
+	user, err := app.accountUser(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return form, models.User{}, false
+	}
+	return form, user, true
+}
+
+// checkPassword adds an error under key to form unless password is the
+// one of user. If the client is throttled, it returns how long it has to
+// wait.
+func (app *application) checkPassword(r *http.Request, form *accountSettingsForm, key string, user models.User, password string) (time.Duration, error) {
+	if !validator.NotBlank(password) {
+		form.AddFieldError(key, "This field cannot be blank")
+		return 0, nil
+	}
+	ok, wait, err := app.confirmPassword(r, user, password)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case wait > 0:
+		form.AddFieldError(key, tooManyLogins)
+	case !ok:
+		form.AddFieldError(key, "The password is incorrect")
+	}
+	return wait, nil
+}
+
+// renderInvalidSettings renders the settings page with the errors of form,
+// telling a throttled client when to try again.
+func (app *application) renderInvalidSettings(w http.ResponseWriter, r *http.Request, wait time.Duration, form accountSettingsForm) {
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		app.renderSettings(w, r, http.StatusTooManyRequests, form)
+		return
+	}
+	app.renderSettings(w, r, http.StatusUnprocessableEntity, form)
+}
+
+func (app *application) accountNamePost(w http.ResponseWriter, r *http.Request) {
+	form, user, ok := app.settingsForm(w, r)
+	if !ok {
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	if !form.Valid() {
+		app.renderSettings(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+	err := app.users.SetName(user.ID, form.Name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "UserName", form.Name)
+	app.sessionManager.Put(r.Context(), "flash", "Your name has been changed!")
+	http.Redirect(w, r, "/account/settings", http.StatusSeeOther)
+}
+
+// accountEmailPost changes the address of the user, who stays logged in
+// but has to verify the new one before they can log in again. The old
+// address is told about the change, in case it wasn't them.
+func (app *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	form, user, ok := app.settingsForm(w, r)
+	if !ok {
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Mathches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(form.Email != user.Email, "email", "This is already your email address")
+	wait, err := app.checkPassword(r, &form, "emailPassword", user, form.EmailPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !form.Valid() {
+		app.renderInvalidSettings(w, r, wait, form)
+		return
+	}
+	err = app.users.SetEmail(user.ID, form.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email address is already in use")
+			app.renderSettings(w, r, http.StatusUnprocessableEntity, form)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	err = app.users.AddAuditEntry(models.NewAuditEntry(models.AuditEmailChanged, user.ID, form.Email, clientIP(r)))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	changed, err := app.users.GetByID(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sendEmailChangedEmail(user, changed.Email)
+	app.sendVerificationEmail(r, changed)
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been changed. Please follow the link we've emailed to it to verify it.")
+	http.Redirect(w, r, "/account/settings", http.StatusSeeOther)
+}
+
+func (app *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	form, user, ok := app.settingsForm(w, r)
+	if !ok {
+		return
+	}
+	wait, err := app.checkPassword(r, &form, "currentPassword", user, form.CurrentPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
+	form.CheckField(form.ConfirmPassword == form.NewPassword, "confirmPassword", "The passwords don't match")
+	if !form.Valid() {
+		app.renderInvalidSettings(w, r, wait, form)
+		return
+	}
+	err = app.users.SetPassword(user.ID, form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.users.AddAuditEntry(models.NewAuditEntry(models.AuditPasswordChanged, user.ID, user.Email, clientIP(r)))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed!")
+	http.Redirect(w, r, "/account/settings", http.StatusSeeOther)
+}
+
+// accountDeletePost deletes the account of the user and logs them out.
+// Their snippets and comments are either credited to models.DeletedAuthor
+// or removed along with it, as they choose.
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	form, user, ok := app.settingsForm(w, r)
+	if !ok {
+		return
+	}
+	form.CheckField(validator.PermittedValue(form.Content, contentAnonymize, contentRemove), "content", "Please choose what happens to your posts and comments")
+	wait, err := app.checkPassword(r, &form, "deletePassword", user, form.DeletePassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !form.Valid() {
+		app.renderInvalidSettings(w, r, wait, form)
+		return
+	}
+	err = app.users.Delete(user.ID, form.Content == contentAnonymize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.logger.Info("deleted user", "id", user.ID.Hex(), "content", form.Content)
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "UserName")
+	app.sessionManager.Put(r.Context(), "flash", "Your account has been deleted.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"snippetbox/internal/models"
+	"snippetbox/internal/oidc"
+)
+
+const ssoFailed = "Single sign-on failed. Please try again or log in with your password."
+
+// ssoRedirectURL is where the provider sends users back to, which has to
+// be registered with it.
+func (app *application) ssoRedirectURL(r *http.Request) string {
+	return app.siteURL(r) + "/user/login/sso/callback"
+}
+
+// userLoginSSO sends the user to the OpenID provider to log in. The state,
+// nonce and PKCE verifier of the login are kept in the session until they
+// come back.
+func (app *application) userLoginSSO(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+	var values [3]string
+	for i := range values {
+		v, err := oidc.NewRandom()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+	app.sessionManager.Put(r.Context(), "ssoState", state)
+	app.sessionManager.Put(r.Context(), "ssoNonce", nonce)
+	app.sessionManager.Put(r.Context(), "ssoVerifier", verifier)
+	http.Redirect(w, r, app.oidc.AuthCodeURL(app.ssoRedirectURL(r), state, nonce, verifier), http.StatusSeeOther)
+}
+
+func (app *application) userLoginSSOCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+	state := app.sessionManager.PopString(r.Context(), "ssoState")
+	nonce := app.sessionManager.PopString(r.Context(), "ssoNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "ssoVerifier")
+	q := r.URL.Query()
+	if state == "" || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		app.sessionManager.Put(r.Context(), "flash", "Your single sign-on login has expired. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		app.logger.Info("single sign-on refused", "error", e, "description", q.Get("error_description"))
+		app.sessionManager.Put(r.Context(), "flash", ssoFailed)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	claims, err := app.oidc.Exchange(r.Context(), q.Get("code"), app.ssoRedirectURL(r), verifier, nonce)
+	if err != nil {
+		app.logger.Warn("single sign-on failed", "error", err.Error())
+		app.sessionManager.Put(r.Context(), "flash", ssoFailed)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		app.sessionManager.Put(r.Context(), "flash", "Your identity provider hasn't verified your email address, so it can't be used to log in.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	user, err := app.ssoUser(claims)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// The provider stands in for the password, not for the second factor.
+	if user.TOTPSecret != "" {
+		err = app.startTwoFactor(r, user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/user/login/twofactor", http.StatusSeeOther)
+		return
+	}
+	err = app.logIn(r, user.ID, user.Name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// ssoUser returns the user with the verified email address of claims,
+// signing them up if there is none. Signed up users get a random password,
+// which they can replace through the password reset if they want one.
+func (app *application) ssoUser(claims oidc.Claims) (models.User, error) {
+	user, err := app.users.GetByEmail(claims.Email)
+	if err == nil {
+		return app.linkSSOUser(user)
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return models.User{}, err
+	}
+
+	password, err := oidc.NewRandom()
+	if err != nil {
+		return models.User{}, err
+	}
+	err = app.users.Insert(ssoName(claims), claims.Email, password)
+	switch {
+	case err == nil:
+		app.logger.Info("signed up user through single sign-on", "email", claims.Email)
+	case !errors.Is(err, models.ErrDuplicateEmail):
+		return models.User{}, err
+	}
+	// If someone else signed up with the address in the meantime, their
+	// account is linked instead.
+	user, err = app.users.GetByEmail(claims.Email)
+	if err != nil {
+		return models.User{}, err
+	}
+	return app.linkSSOUser(user)
+}
+
+// linkSSOUser lets the provider vouch for the email address of user, who
+// may have signed up without verifying it.
+func (app *application) linkSSOUser(user models.User) (models.User, error) {
+	if user.EmailVerified {
+		return user, nil
+	}
+	// Whoever chose the password never proved the address was theirs, so
+	// it must not keep working on an account its owner now uses. This is
+	// also the password of a user just signed up, which nobody knows.
+	password, err := oidc.NewRandom()
+	if err != nil {
+		return models.User{}, err
+	}
+	err = app.users.SetPassword(user.ID, password)
+	if err != nil {
+		return models.User{}, err
+	}
+	err = app.users.SetEmailVerified(user.ID, user.Email)
+	if err != nil {
+		return models.User{}, err
+	}
+	return app.users.GetByID(user.ID)
+}
+
+// ssoName is the name a user signed up through single sign-on gets: the
+// one the provider knows them by, or else the local part of their address.
+func ssoName(claims oidc.Claims) string {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	for utf8.RuneCountInString(name) > 100 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
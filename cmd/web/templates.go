@@ -1,12 +1,20 @@
 package main
 
 import (
+	"html"
 	"io/fs"
 	"path/filepath"
+	"regexp"
+	"snippetbox/internal/diff"
+	"snippetbox/internal/highlight"
+	"snippetbox/internal/markdown"
 	"snippetbox/internal/models"
 	"snippetbox/ui"
+	"sort"
+	"strings"
 	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
 type templateData struct {
@@ -16,8 +24,107 @@ type templateData struct {
 	Form            any
 	Flash           string
 	IsAuthenticated bool
+	// AuthenticatedUserID is the hex ID of the logged in user, or empty.
+	AuthenticatedUserID string
+	CSRFToken           string
+	User                models.User
+	// Page and Sort describe the listing being shown, for the pager and
+	// sort links.
+	Page models.Page
+	Sort string
+	// Results holds the hits of a search, and Searched whether a search was
+	// run at all, to tell an empty result from an empty form.
+	Results  []models.SearchResult
+	Searched bool
+	// Tag is the tag whose page is shown.
+	Tag      string
+	TagCloud []cloudTag
+	// Tokens are the API tokens of the logged in user, and NewToken the
+	// plaintext of one just created.
+	Tokens   []models.APIToken
+	NewToken string
+	// BaseURL is the scheme and host of the site, for the embed code.
+	BaseURL string
+	// Revisions is the history of Snippet, newest first. On the diff page
+	// FromRevision and ToRevision are the revisions compared, the zero
+	// Revision standing for the empty snippet before the first, and Hunks
+	// the changes to the content.
+	Revisions    []models.Revision
+	FromRevision models.Revision
+	ToRevision   models.Revision
+	Hunks        []diff.Hunk
+	// Parent is the snippet Snippet was forked from, or the zero Snippet if
+	// that has been deleted, and Forks the snippets forked from Snippet.
+	Parent models.Snippet
+	Forks  []models.Snippet
+	// CommentPage is the page of comments on Snippet shown, and Comments
+	// the comments on it arranged in threads.
+	CommentPage models.CommentPage
+	Comments    []commentNode
+	// TOTPSecret is the authenticator secret being enrolled, for typing in
+	// by hand, and RecoveryCodes the recovery codes just generated, which
+	// are shown only once.
+	TOTPSecret    string
+	RecoveryCodes []string
+	// AuditEntries are the latest security events of the logged in user.
+	AuditEntries []models.AuditEntry
+	// SSOName labels the single sign-on option of the login page, which is
+	// only offered when set.
+	SSOName string
+}
+
+// commentNode is a comment as view.html shows it. It carries what the
+// recursive comment template needs from the page, since that can't reach
+// the page data itself.
+type commentNode struct {
+	models.Commentary
+	Replies         []commentNode
+	SnippetID       string
+	CanModify       bool
+	IsAuthenticated bool
 	CSRFToken       string
-	User            models.User
+}
+
+// commentTree turns the threads of comments on snippet into nodes for the
+// page described by data.
+func commentTree(snippet models.Snippet, threads []models.Thread, data *templateData) []commentNode {
+	nodes := make([]commentNode, len(threads))
+	for i, t := range threads {
+		nodes[i] = commentNode{
+			Commentary:      t.Commentary,
+			Replies:         commentTree(snippet, t.Replies, data),
+			SnippetID:       snippet.IDStr,
+			CanModify:       models.CanModifyComment(snippet, t.Commentary, data.AuthenticatedUserID),
+			IsAuthenticated: data.IsAuthenticated,
+			CSRFToken:       data.CSRFToken,
+		}
+	}
+	return nodes
+}
+
+// cloudTag is a tag in the tag cloud, sized from 1 to 5 by how often it is
+// used compared to the most used tag.
+type cloudTag struct {
+	models.TagCount
+	Size int
+}
+
+// tagCloud sizes counts and orders them by name.
+func tagCloud(counts []models.TagCount) []cloudTag {
+	most := 0
+	for _, c := range counts {
+		most = max(most, c.Count)
+	}
+	cloud := make([]cloudTag, len(counts))
+	for i, c := range counts {
+		size := 1
+		if most > 1 {
+			size += 4 * (c.Count - 1) / (most - 1)
+		}
+		cloud[i] = cloudTag{TagCount: c, Size: size}
+	}
+	sort.Slice(cloud, func(i, j int) bool { return cloud[i].Name < cloud[j].Name })
+	return cloud
 }
 
 func humanDate(t time.Time) string {
@@ -27,7 +134,96 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
-var functions = template.FuncMap{"humanDate": humanDate}
+// termsRX matches any of terms, ignoring case. It is nil if there are no
+// terms.
+func termsRX(terms []string) *regexp.Regexp {
+	if len(terms) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	// Longest first, so that a term is not cut short by its own prefix.
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// highlightTerms HTML-escapes text and wraps each occurrence of terms in a mark
+// element.
+func highlightTerms(text string, terms []string) string {
+	rx := termsRX(terms)
+	if rx == nil {
+		return html.EscapeString(text)
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range rx.FindAllStringIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		b.WriteString("<mark>" + html.EscapeString(text[m[0]:m[1]]) + "</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+const excerptLength = 160
+
+// excerpt cuts a window of text around the first occurrence of terms and
+// highlights it.
+func excerpt(text string, terms []string) string {
+	runes := []rune(text)
+	start := 0
+	if rx := termsRX(terms); rx != nil {
+		if m := rx.FindStringIndex(text); m != nil {
+			start = max(0, utf8.RuneCountInString(text[:m[0]])-excerptLength/3)
+		}
+	}
+	end := min(len(runes), start+excerptLength)
+	window := string(runes[start:end])
+	if start > 0 {
+		window = "…" + window
+	}
+	if end < len(runes) {
+		window += "…"
+	}
+	return highlightTerms(window, terms)
+}
+
+// code renders the content of a snippet with syntax highlighting. Snippets
+// saved before languages were recorded have theirs detected on the fly.
+func code(s models.Snippet) string {
+	language := s.Language
+	if language == "" {
+		language = highlight.Detect(s.Content)
+	}
+	return highlight.Render(s.Content, language)
+}
+
+var functions = template.FuncMap{
+	"humanDate":    humanDate,
+	"sortOptions":  func() []string { return models.SortOptions },
+	"highlight":    highlightTerms,
+	"excerpt":      excerpt,
+	"code":         code,
+	"markdown":     markdown.Render,
+	"languages":    func() []highlight.Language { return highlight.Languages },
+	"languageName": highlight.Name,
+	"auditEvent":   auditEvent,
+}
+
+// auditEvent describes an audit log event for the account page.
+func auditEvent(event string) string {
+	switch event {
+	case models.AuditLoginLockout:
+		return "Locked out after too many failed logins"
+	case models.AuditEmailChanged:
+		return "Email address changed"
+	case models.AuditPasswordChanged:
+		return "Password changed"
+	}
+	return event
+}
 
 func newTemplateCache() (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
@@ -49,5 +245,13 @@ func newTemplateCache() (map[string]*template.Template, error) {
 		}
 		cache[name] = ts
 	}
+
+	// The embed page is shown inside other sites, so it has a base of its
+	// own without the header, navigation and footer.
+	ts, err := template.New("embed.html").Funcs(functions).ParseFS(ui.Files, "html/embed.html", "html/partials/*.html")
+	if err != nil {
+		return nil, err
+	}
+	cache["embed.html"] = ts
 	return cache, nil
 }
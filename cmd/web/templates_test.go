@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -36,3 +37,45 @@ func TestHumanFate(t *testing.T) {
 		})
 	}
 }
+
+func TestHighlightTerms(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{
+			name: "No terms",
+			text: "<b>pond</b>",
+			want: "&lt;b&gt;pond&lt;/b&gt;",
+		},
+		{
+			name:  "Case insensitive",
+			text:  "An old silent Pond",
+			terms: []string{"pond", "old"},
+			want:  "An <mark>old</mark> silent <mark>Pond</mark>",
+		},
+		{
+			name:  "Escaped match",
+			text:  "a <script> tag",
+			terms: []string{"<script>"},
+			want:  "a <mark>&lt;script&gt;</mark> tag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, highlightTerms(tt.text, tt.terms), tt.want)
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	text := strings.Repeat("a ", 200) + "frog " + strings.Repeat("b ", 200)
+	got := excerpt(text, []string{"frog"})
+	assert.StringContains(t, got, "<mark>frog</mark>")
+	assert.Equal(t, strings.HasPrefix(got, "…"), true)
+	assert.Equal(t, strings.HasSuffix(got, "…"), true)
+
+	assert.Equal(t, excerpt("short", nil), "short")
+}
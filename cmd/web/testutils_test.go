@@ -10,10 +10,16 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"snippetbox/internal/authtoken"
+	"snippetbox/internal/mailer"
 	"snippetbox/internal/models"
+	"snippetbox/internal/models/memory"
+	"snippetbox/internal/models/mocks"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form"
@@ -40,15 +46,79 @@ func newTestApplication(t *testing.T) *application {
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
+	db := mocks.NewDB()
+
+	loginIPs, loginAccounts := newLoginLimiters(5, 15*time.Minute)
+
 	return &application{
 		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
-		snippets:       models.SnippetModel{},
-		users:          models.UserModel{},
+		snippets:       &memory.SnippetModel{DB: db},
+		users:          &memory.UserModel{DB: db},
+		commentary:     &memory.CommentaryModel{DB: db},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		lifecycle:      newLifecycle(),
+		mailer:         &testMailer{},
+		signer:         authtoken.NewSigner([]byte("0123456789abcdef0123456789abcdef")),
+		loginIPs:       loginIPs,
+		loginAccounts:  loginAccounts,
+	}
+
+}
+
+// addUser signs up a user whose address is verified, so that they can log
+// in with mocks.MockUserPassword.
+func addUser(t *testing.T, app *application, name, email string) models.User {
+	t.Helper()
+	err := app.users.Insert(name, email, mocks.MockUserPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := app.users.GetByEmail(email)
+	if err != nil {
+		t.Fatal(err)
 	}
+	err = app.users.SetEmailVerified(user.ID, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// testMailer keeps the messages sent instead of sending them.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *testMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// sentMail waits for the mail being sent in the background and returns all
+// messages sent so far.
+func sentMail(app *application) []mailer.Message {
+	app.lifecycle.wg.Wait()
+	m := app.mailer.(*testMailer)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.sent...)
+}
+
+var mailLinkRX = regexp.MustCompile(`https?://\S+(/user/\S+)`)
 
+// mailLink returns the path of the link in msg.
+func mailLink(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	matches := mailLinkRX.FindStringSubmatch(msg.Body)
+	if matches == nil {
+		t.Fatalf("no link found in %q", msg.Body)
+	}
+	return matches[1]
 }
 
 type testServer struct {
@@ -99,3 +169,44 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, strin
 	body = bytes.TrimSpace(body)
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// login signs in through the login form and returns a CSRF token that is
+// valid for the rest of the session.
+func (ts *testServer) login(t *testing.T, email, password string) string {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+	return csrfToken
+}
+
+// do sends a request with a JSON body, or none if body is empty.
+func (ts *testServer) do(t *testing.T, method, urlPath, body string) (int, http.Header, string) {
+	var rb io.Reader
+	if body != "" {
+		rb = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+urlPath, rb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	rsBody, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, rs.Header, string(bytes.TrimSpace(rsBody))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// sweepExpired purges expired snippets every interval until ctx is
+// cancelled. Expired snippets are already hidden by the stores, so a missed
+// sweep only costs storage.
+func (app *application) sweepExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := app.snippets.DeleteExpired()
+			if err != nil {
+				app.logger.Error(err.Error())
+				continue
+			}
+			if n > 0 {
+				app.logger.Info("purged expired snippets", "count", n)
+			}
+		}
+	}
+}
+
+// sweepLimiters drops the failed logins old enough to be forgotten every
+// interval until ctx is cancelled.
+func (app *application) sweepLimiters(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.loginIPs.Sweep()
+			app.loginAccounts.Sweep()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package authtoken signs the short-lived tokens carried by the links in
+// emails, such as those verifying an address or resetting a password. They
+// need no storage: a token names its subject and expiry and is signed with
+// HMAC-SHA256, and binds a stamp of whatever state it acts on, so that
+// acting on it, which changes that state, spends it.
+package authtoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("authtoken: invalid token")
+
+	ErrExpired = errors.New("authtoken: token has expired")
+
+	// ErrUsed is returned when the stamp of a token doesn't match the
+	// current state any more, which is usually because it was used.
+	ErrUsed = errors.New("authtoken: token has already been used")
+)
+
+var encoding = base64.RawURLEncoding
+
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign returns a token for purpose on behalf of subject, valid until
+// expires for as long as the state it acts on still has stamp.
+func (s *Signer) Sign(purpose, subject, stamp string, expires time.Time) string {
+	payload := encoding.EncodeToString([]byte(subject)) + "." +
+		strconv.FormatInt(expires.Unix(), 10) + "." +
+		encoding.EncodeToString(stampHash(stamp))
+	return payload + "." + encoding.EncodeToString(s.mac(purpose, payload))
+}
+
+// Parse checks the signature and expiry of a token signed for purpose and
+// returns its subject. The caller then checks the stamp with Claims.Check.
+func (s *Signer) Parse(purpose, token string, now time.Time) (Claims, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return Claims{}, ErrInvalid
+	}
+	payload := token[:i]
+	mac, err := encoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(mac, s.mac(purpose, payload)) {
+		return Claims{}, ErrInvalid
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalid
+	}
+	subject, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	stamp, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	claims := Claims{Subject: string(subject), Expires: time.Unix(expires, 0), stamp: stamp}
+	if !now.Before(claims.Expires) {
+		return Claims{}, ErrExpired
+	}
+	return claims, nil
+}
+
+// mac signs the payload together with the purpose, so that a token issued
+// for one purpose can't be used for another.
+func (s *Signer) mac(purpose, payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+type Claims struct {
+	Subject string
+	Expires time.Time
+	stamp   []byte
+}
+
+// Check returns ErrUsed unless stamp is the one the token was signed with.
+func (c Claims) Check(stamp string) error {
+	if subtle.ConstantTimeCompare(c.stamp, stampHash(stamp)) != 1 {
+		return ErrUsed
+	}
+	return nil
+}
+
+// stampHash keeps the stamp itself, which may be a password hash or an email
+// address, out of the token.
+func stampHash(stamp string) []byte {
+	sum := sha256.Sum256([]byte(stamp))
+	return sum[:16]
+}
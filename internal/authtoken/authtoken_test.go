@@ -0,0 +1,84 @@
+package authtoken
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+func TestSigner(t *testing.T) {
+	s := NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	token := s.Sign("reset", "65e0a1b2c3d4e5f601234500", "stamp", now.Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		signer  *Signer
+		purpose string
+		token   string
+		now     time.Time
+		wantErr error
+	}{
+		{
+			name:    "Valid",
+			signer:  s,
+			purpose: "reset",
+			token:   token,
+			now:     now,
+		},
+		{
+			name:    "Expired",
+			signer:  s,
+			purpose: "reset",
+			token:   token,
+			now:     now.Add(time.Hour),
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "Other purpose",
+			signer:  s,
+			purpose: "verify",
+			token:   token,
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Other key",
+			signer:  NewSigner([]byte("fedcba9876543210fedcba9876543210")),
+			purpose: "reset",
+			token:   token,
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Tampered",
+			signer:  s,
+			purpose: "reset",
+			token:   strings.Replace(token, ".", "A.", 1),
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Garbage",
+			signer:  s,
+			purpose: "reset",
+			token:   "not-a-token",
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.signer.Parse(tt.purpose, tt.token, tt.now)
+			assert.Equal(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.Equal(t, claims.Subject, "65e0a1b2c3d4e5f601234500")
+				assert.Equal(t, claims.Expires.Equal(now.Add(time.Hour)), true)
+				assert.NilError(t, claims.Check("stamp"))
+				assert.Equal(t, claims.Check("changed"), ErrUsed)
+			}
+		})
+	}
+}
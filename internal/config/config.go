@@ -0,0 +1,450 @@
+// Package config builds the effective application configuration by layering,
+// from lowest to highest precedence: built-in defaults, an optional config
+// file (YAML, TOML or JSON, chosen by extension), SNIPPETBOX_* environment
+// variables and command-line flags.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var Stores = []string{"mongo", "memory", "mysql", "sqlite"}
+
+var MailTransports = []string{"log", "smtp", "file"}
+
+type Config struct {
+	Addr    string  `json:"addr" yaml:"addr" toml:"addr"`
+	Store   string  `json:"store" yaml:"store" toml:"store"`
+	DSN     string  `json:"dsn" yaml:"dsn" toml:"dsn"`
+	Mongo   Mongo   `json:"mongo" yaml:"mongo" toml:"mongo"`
+	TLS     TLS     `json:"tls" yaml:"tls" toml:"tls"`
+	Session Session `json:"session" yaml:"session" toml:"session"`
+	Server  Server  `json:"server" yaml:"server" toml:"server"`
+	// SweepInterval is how often expired snippets are purged.
+	SweepInterval Duration `json:"sweep_interval" yaml:"sweep_interval" toml:"sweep_interval"`
+	// BaseURL is the public URL of the site, used for the links in emails
+	// and the single sign-on callback. It is required unless mail is only
+	// logged and single sign-on is off, when links fall back to the Host
+	// header of the request.
+	BaseURL string `json:"base_url" yaml:"base_url" toml:"base_url"`
+	// Secret is the key the links in emails are signed with. A random one
+	// is used when blank, so links stop working when the server restarts.
+	Secret string `json:"secret" yaml:"secret" toml:"secret"`
+	Mail   Mail   `json:"mail" yaml:"mail" toml:"mail"`
+	Login  Login  `json:"login" yaml:"login" toml:"login"`
+	OIDC   OIDC   `json:"oidc" yaml:"oidc" toml:"oidc"`
+
+	// File and DumpConfig only ever come from the command line.
+	File       string `json:"-" yaml:"-" toml:"-"`
+	DumpConfig bool   `json:"-" yaml:"-" toml:"-"`
+}
+
+type Mongo struct {
+	URI      string `json:"uri" yaml:"uri" toml:"uri"`
+	Database string `json:"database" yaml:"database" toml:"database"`
+}
+
+type TLS struct {
+	CertFile string `json:"cert_file" yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file" toml:"key_file"`
+}
+
+// Mail configures how emails are sent: logged, handed to an SMTP server or
+// written as .eml files to Dir.
+type Mail struct {
+	Transport string `json:"transport" yaml:"transport" toml:"transport"`
+	From      string `json:"from" yaml:"from" toml:"from"`
+	Dir       string `json:"dir" yaml:"dir" toml:"dir"`
+	SMTP      SMTP   `json:"smtp" yaml:"smtp" toml:"smtp"`
+}
+
+type SMTP struct {
+	Addr     string `json:"addr" yaml:"addr" toml:"addr"`
+	Username string `json:"username" yaml:"username" toml:"username"`
+	Password string `json:"password" yaml:"password" toml:"password"`
+}
+
+// Login configures the lockout of an account after MaxFailures failed
+// logins in a row, for Lockout.
+type Login struct {
+	MaxFailures int      `json:"max_failures" yaml:"max_failures" toml:"max_failures"`
+	Lockout     Duration `json:"lockout" yaml:"lockout" toml:"lockout"`
+}
+
+// OIDC configures single sign-on with an OpenID Connect provider, which
+// the login page offers when Issuer is set. Name labels the option.
+type OIDC struct {
+	Issuer       string `json:"issuer" yaml:"issuer" toml:"issuer"`
+	ClientID     string `json:"client_id" yaml:"client_id" toml:"client_id"`
+	ClientSecret string `json:"client_secret" yaml:"client_secret" toml:"client_secret"`
+	Name         string `json:"name" yaml:"name" toml:"name"`
+}
+
+type Session struct {
+	Lifetime Duration `json:"lifetime" yaml:"lifetime" toml:"lifetime"`
+}
+
+type Server struct {
+	IdleTimeout  Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout"`
+	ReadTimeout  Duration `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests and background
+	// tasks are given to finish once the server is asked to stop.
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+func Default() *Config {
+	return &Config{
+		Addr:  ":4000",
+		Store: "mongo",
+		Mongo: Mongo{
+			Database: "snippetbox",
+		},
+		TLS: TLS{
+			CertFile: "./tls/cert.pem",
+			KeyFile:  "./tls/key.pem",
+		},
+		Session: Session{
+			Lifetime: Duration{12 * time.Hour},
+		},
+		Server: Server{
+			IdleTimeout:     Duration{time.Minute},
+			ReadTimeout:     Duration{5 * time.Second},
+			WriteTimeout:    Duration{10 * time.Second},
+			ShutdownTimeout: Duration{30 * time.Second},
+		},
+		SweepInterval: Duration{time.Minute},
+		Mail: Mail{
+			Transport: "log",
+			From:      "Snippetbox <no-reply@localhost>",
+		},
+		Login: Login{
+			MaxFailures: 10,
+			Lockout:     Duration{15 * time.Minute},
+		},
+		OIDC: OIDC{
+			Name: "single sign-on",
+		},
+	}
+}
+
+// setting ties a single configuration value to its flag and environment
+// variable. Every value goes through flag.Value.Set, so flags and the
+// environment are parsed the same way.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"addr", "SNIPPETBOX_ADDR", "HTTP network address", (*stringValue)(&c.Addr)},
+		{"store", "SNIPPETBOX_STORE", "Storage backend (" + strings.Join(Stores, "|") + ")", (*stringValue)(&c.Store)},
+		{"dsn", "SNIPPETBOX_DSN", "Data source name for the mysql and sqlite backends", (*stringValue)(&c.DSN)},
+		{"mongo-uri", "MONGODB_URI", "MongoDB connection URI", (*stringValue)(&c.Mongo.URI)},
+		{"mongo-db", "SNIPPETBOX_MONGO_DATABASE", "MongoDB database name", (*stringValue)(&c.Mongo.Database)},
+		{"tls-cert", "SNIPPETBOX_TLS_CERT_FILE", "Path to the TLS certificate", (*stringValue)(&c.TLS.CertFile)},
+		{"tls-key", "SNIPPETBOX_TLS_KEY_FILE", "Path to the TLS private key", (*stringValue)(&c.TLS.KeyFile)},
+		{"session-lifetime", "SNIPPETBOX_SESSION_LIFETIME", "Session lifetime", &c.Session.Lifetime},
+		{"idle-timeout", "SNIPPETBOX_IDLE_TIMEOUT", "HTTP server idle timeout", &c.Server.IdleTimeout},
+		{"read-timeout", "SNIPPETBOX_READ_TIMEOUT", "HTTP server read timeout", &c.Server.ReadTimeout},
+		{"write-timeout", "SNIPPETBOX_WRITE_TIMEOUT", "HTTP server write timeout", &c.Server.WriteTimeout},
+		{"sweep-interval", "SNIPPETBOX_SWEEP_INTERVAL", "How often expired snippets are purged", &c.SweepInterval},
+		{"shutdown-timeout", "SNIPPETBOX_SHUTDOWN_TIMEOUT", "Time allowed for draining requests on shutdown", &c.Server.ShutdownTimeout},
+		{"base-url", "SNIPPETBOX_BASE_URL", "Public URL of the site, for links in emails and the single sign-on callback", (*stringValue)(&c.BaseURL)},
+		{"secret", "SNIPPETBOX_SECRET", "Key for signing links in emails (at least 32 characters)", (*stringValue)(&c.Secret)},
+		{"mail-transport", "SNIPPETBOX_MAIL_TRANSPORT", "How emails are sent (" + strings.Join(MailTransports, "|") + ")", (*stringValue)(&c.Mail.Transport)},
+		{"mail-from", "SNIPPETBOX_MAIL_FROM", "Sender address of emails", (*stringValue)(&c.Mail.From)},
+		{"mail-dir", "SNIPPETBOX_MAIL_DIR", "Directory emails are written to by the file transport", (*stringValue)(&c.Mail.Dir)},
+		{"smtp-addr", "SNIPPETBOX_SMTP_ADDR", "SMTP server host:port", (*stringValue)(&c.Mail.SMTP.Addr)},
+		{"smtp-username", "SNIPPETBOX_SMTP_USERNAME", "SMTP username", (*stringValue)(&c.Mail.SMTP.Username)},
+		{"smtp-password", "SNIPPETBOX_SMTP_PASSWORD", "SMTP password", (*stringValue)(&c.Mail.SMTP.Password)},
+		{"login-max-failures", "SNIPPETBOX_LOGIN_MAX_FAILURES", "Failed logins in a row before an account is locked out", (*intValue)(&c.Login.MaxFailures)},
+		{"login-lockout", "SNIPPETBOX_LOGIN_LOCKOUT", "How long an account is locked out for", &c.Login.Lockout},
+		{"oidc-issuer", "SNIPPETBOX_OIDC_ISSUER", "Issuer URL of the OpenID provider for single sign-on", (*stringValue)(&c.OIDC.Issuer)},
+		{"oidc-client-id", "SNIPPETBOX_OIDC_CLIENT_ID", "Client ID registered with the OpenID provider", (*stringValue)(&c.OIDC.ClientID)},
+		{"oidc-client-secret", "SNIPPETBOX_OIDC_CLIENT_SECRET", "Client secret registered with the OpenID provider", (*stringValue)(&c.OIDC.ClientSecret)},
+		{"oidc-name", "SNIPPETBOX_OIDC_NAME", "Name of the single sign-on option on the login page", (*stringValue)(&c.OIDC.Name)},
+	}
+}
+
+func (c *Config) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, s := range c.settings() {
+		fs.Var(s.value, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	fs.StringVar(&c.File, "config", "", "Path to a YAML, TOML or JSON config file (env SNIPPETBOX_CONFIG)")
+	fs.BoolVar(&c.DumpConfig, "dump-config", false, "Print the effective configuration and exit")
+	return fs
+}
+
+// Load parses args (without the program name) and the environment returned
+// by getenv into a Config. The result is not validated, so that an invalid
+// configuration can still be dumped; call Validate before using it.
+// flag.ErrHelp is returned as is when -h is passed.
+func Load(name string, args []string, getenv func(string) string) (*Config, error) {
+	cli := Default()
+	fs := cli.flagSet(name)
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	cfg := Default()
+	cfg.File = cli.File
+	cfg.DumpConfig = cli.DumpConfig
+	if cfg.File == "" {
+		cfg.File = getenv("SNIPPETBOX_CONFIG")
+	}
+	if cfg.File != "" {
+		err := cfg.loadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg.resolveTLSDefaults()
+	for _, s := range cfg.settings() {
+		if v := getenv(s.env); v != "" {
+			if err := s.value.Set(v); err != nil {
+				return nil, fmt.Errorf("config: invalid value %q for %s: %w", v, s.env, err)
+			}
+		}
+	}
+	for _, s := range cfg.settings() {
+		if v, ok := explicit[s.flag]; ok {
+			s.value.Set(v)
+		}
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	tls := c.TLS
+	mailDir := c.Mail.Dir
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), c)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown field %q", md.Undecoded()[0].String())
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	default:
+		return fmt.Errorf("config: unsupported config file extension %q (want .yaml, .yml, .toml or .json)", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	// Relative paths in a config file are relative to the file itself, so the
+	// binary behaves the same whatever directory it is started from.
+	dir := filepath.Dir(path)
+	if c.TLS.CertFile != tls.CertFile && !filepath.IsAbs(c.TLS.CertFile) {
+		c.TLS.CertFile = filepath.Join(dir, c.TLS.CertFile)
+	}
+	if c.TLS.KeyFile != tls.KeyFile && !filepath.IsAbs(c.TLS.KeyFile) {
+		c.TLS.KeyFile = filepath.Join(dir, c.TLS.KeyFile)
+	}
+	if c.Mail.Dir != mailDir && c.Mail.Dir != "" && !filepath.IsAbs(c.Mail.Dir) {
+		c.Mail.Dir = filepath.Join(dir, c.Mail.Dir)
+	}
+	return nil
+}
+
+// resolveTLSDefaults makes the default certificate and key paths, where
+// they weren't changed, relative to the config file or, without one, to the
+// executable, so that the working directory never matters.
+func (c *Config) resolveTLSDefaults() {
+	dir := filepath.Dir(c.File)
+	if c.File == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return
+		}
+		dir = filepath.Dir(exe)
+	}
+	tls := Default().TLS
+	if c.TLS.CertFile == tls.CertFile {
+		c.TLS.CertFile = filepath.Join(dir, tls.CertFile)
+	}
+	if c.TLS.KeyFile == tls.KeyFile {
+		c.TLS.KeyFile = filepath.Join(dir, tls.KeyFile)
+	}
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("config: "+format, args...))
+		}
+	}
+
+	check(strings.TrimSpace(c.Addr) != "", "addr cannot be blank")
+	check(slices.Contains(Stores, c.Store), "store must be one of %s, got %q", strings.Join(Stores, ", "), c.Store)
+	switch c.Store {
+	case "mongo":
+		check(c.Mongo.URI != "", "mongo.uri is required when store is mongo (set MONGODB_URI or -mongo-uri)")
+		check(c.Mongo.Database != "", "mongo.database cannot be blank")
+	case "mysql", "sqlite":
+		check(c.DSN != "", "dsn is required when store is %s (set SNIPPETBOX_DSN or -dsn)", c.Store)
+	}
+	_, err := os.Stat(c.TLS.CertFile)
+	check(err == nil, "tls.cert_file: %v", err)
+	_, err = os.Stat(c.TLS.KeyFile)
+	check(err == nil, "tls.key_file: %v", err)
+	check(c.Session.Lifetime.Duration > 0, "session.lifetime must be positive")
+	check(c.Server.IdleTimeout.Duration > 0, "server.idle_timeout must be positive")
+	check(c.Server.ReadTimeout.Duration > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout.Duration > 0, "server.write_timeout must be positive")
+	check(c.Server.ShutdownTimeout.Duration > 0, "server.shutdown_timeout must be positive")
+	check(c.SweepInterval.Duration > 0, "sweep_interval must be positive")
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "base_url must be an absolute http or https URL, got %q", c.BaseURL)
+	}
+	check(c.Secret == "" || len(c.Secret) >= 32, "secret must be at least 32 characters long")
+	check(slices.Contains(MailTransports, c.Mail.Transport), "mail.transport must be one of %s, got %q", strings.Join(MailTransports, ", "), c.Mail.Transport)
+	_, err = mail.ParseAddress(c.Mail.From)
+	check(err == nil, "mail.from: %v", err)
+	// Links in emails and the single sign-on callback are built from
+	// base_url; without it they would come from the client-chosen Host
+	// header, which is only good enough for logged mail.
+	check(c.BaseURL != "" || c.Mail.Transport == "log", "base_url is required when mail.transport is %s (set SNIPPETBOX_BASE_URL or -base-url)", c.Mail.Transport)
+	switch c.Mail.Transport {
+	case "smtp":
+		check(c.Mail.SMTP.Addr != "", "mail.smtp.addr is required when mail.transport is smtp (set SNIPPETBOX_SMTP_ADDR or -smtp-addr)")
+	case "file":
+		check(c.Mail.Dir != "", "mail.dir is required when mail.transport is file (set SNIPPETBOX_MAIL_DIR or -mail-dir)")
+	}
+	check(c.Login.MaxFailures > 0, "login.max_failures must be positive")
+	check(c.Login.Lockout.Duration > 0, "login.lockout must be positive")
+	if c.OIDC.Issuer != "" {
+		u, err := url.Parse(c.OIDC.Issuer)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "oidc.issuer must be an absolute http or https URL, got %q", c.OIDC.Issuer)
+		check(c.OIDC.ClientID != "", "oidc.client_id is required when oidc.issuer is set (set SNIPPETBOX_OIDC_CLIENT_ID or -oidc-client-id)")
+		check(c.BaseURL != "", "base_url is required when oidc.issuer is set (set SNIPPETBOX_BASE_URL or -base-url)")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Dump writes the configuration as YAML with credentials redacted.
+func (c *Config) Dump(w io.Writer) error {
+	redacted := *c
+	redacted.DSN = redact(c.DSN)
+	redacted.Mongo.URI = redact(c.Mongo.URI)
+	if c.Secret != "" {
+		redacted.Secret = "xxxxx"
+	}
+	if c.Mail.SMTP.Password != "" {
+		redacted.Mail.SMTP.Password = "xxxxx"
+	}
+	if c.OIDC.ClientSecret != "" {
+		redacted.OIDC.ClientSecret = "xxxxx"
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err := enc.Encode(redacted)
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// credentialsRX matches the "user:password@" part of both URLs and MySQL
+// style DSNs.
+var credentialsRX = regexp.MustCompile(`^([a-z0-9+.-]+://)?([^:@/]*):[^@]*@`)
+
+func redact(s string) string {
+	return credentialsRX.ReplaceAllString(s, "${1}${2}:xxxxx@")
+}
+
+type stringValue string
+
+func (s *stringValue) Set(v string) error {
+	*s = stringValue(v)
+	return nil
+}
+
+func (s *stringValue) String() string {
+	return string(*s)
+}
+
+type intValue int
+
+func (i *intValue) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*i = intValue(n)
+	return nil
+}
+
+func (i *intValue) String() string {
+	return strconv.Itoa(int(*i))
+}
+
+// Duration wraps time.Duration so it can be written as "12h" or "5s" in
+// config files, the environment and flags alike.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) Set(v string) error {
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
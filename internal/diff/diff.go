@@ -0,0 +1,290 @@
+// Package diff compares texts line by line and presents the differences as
+// unified diff hunks, the format of diff -u and git diff.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op says what happened to a line going from the old text to the new one.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// String returns the name of op, which the templates use as a CSS class.
+func (op Op) String() string {
+	switch op {
+	case Delete:
+		return "delete"
+	case Insert:
+		return "insert"
+	}
+	return "equal"
+}
+
+// maxEdits bounds the work spent on a diff. Texts that differ in more lines
+// than this are shown as replaced wholesale.
+const maxEdits = 4000
+
+// Line is a line of a diff. OldLine and NewLine are its 1-based numbers in
+// the old and new text, 0 where the line isn't part of that text.
+type Line struct {
+	Op      Op
+	Text    string
+	OldLine int
+	NewLine int
+
+	// oldPos and newPos count the old and new lines before this one.
+	oldPos, newPos int
+}
+
+// Hunk is a run of changed lines with the unchanged lines around them.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []Line
+}
+
+// Header returns the @@ line that starts the hunk in a unified diff.
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", span(h.OldStart, h.OldLines), span(h.NewStart, h.NewLines))
+}
+
+func span(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// Hunks compares old and new and returns the changes, each with up to
+// context unchanged lines on either side. Hunks whose context would overlap
+// are merged. Line endings are normalized to \n first.
+func Hunks(old, new string, context int) []Hunk {
+	lines := Lines(old, new)
+	var hunks []Hunk
+	i := 0
+	for i < len(lines) {
+		for i < len(lines) && lines[i].Op == Equal {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+		start := max(i-context, 0)
+		end := i
+		for {
+			j := end + 1
+			for j < len(lines) && lines[j].Op == Equal {
+				j++
+			}
+			if j < len(lines) && j-end-1 <= 2*context {
+				end = j
+				continue
+			}
+			break
+		}
+		stop := min(end+context+1, len(lines))
+		hunks = append(hunks, newHunk(lines[start:stop]))
+		i = stop
+	}
+	return hunks
+}
+
+func newHunk(lines []Line) Hunk {
+	h := Hunk{Lines: lines}
+	for _, l := range lines {
+		if l.Op != Insert {
+			h.OldLines++
+		}
+		if l.Op != Delete {
+			h.NewLines++
+		}
+	}
+	// An empty side starts at the line before, as diff -u has it.
+	h.OldStart, h.NewStart = lines[0].oldPos, lines[0].newPos
+	if h.OldLines > 0 {
+		h.OldStart++
+	}
+	if h.NewLines > 0 {
+		h.NewStart++
+	}
+	return h
+}
+
+// Unified returns the changes from old to new as a unified diff with three
+// lines of context, or "" if there are none.
+func Unified(old, new, oldName, newName string) string {
+	hunks := Hunks(old, new, 3)
+	if len(hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks {
+		b.WriteString(h.Header())
+		b.WriteByte('\n')
+		for _, l := range h.Lines {
+			switch l.Op {
+			case Equal:
+				b.WriteByte(' ')
+			case Delete:
+				b.WriteByte('-')
+			case Insert:
+				b.WriteByte('+')
+			}
+			b.WriteString(l.Text)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// Lines returns every line of old and new in diff order: unchanged lines
+// once, and removed lines before the lines added in their place.
+func Lines(old, new string) []Line {
+	a, b := split(old), split(new)
+	ops := edits(a, b)
+	lines := make([]Line, len(ops))
+	x, y := 0, 0
+	for i, op := range ops {
+		l := Line{Op: op, oldPos: x, newPos: y}
+		switch op {
+		case Equal:
+			l.Text = a[x]
+			x++
+			y++
+			l.OldLine, l.NewLine = x, y
+		case Delete:
+			l.Text = a[x]
+			x++
+			l.OldLine = x
+		case Insert:
+			l.Text = b[y]
+			y++
+			l.NewLine = y
+		}
+		lines[i] = l
+	}
+	return lines
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// edits finds a shortest edit script from a to b with Myers' algorithm.
+// Deletions come before insertions within each change.
+func edits(a, b []string) []Op {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	offset := limit + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v for diagonals -d..d after d edits.
+	var trace [][]int
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+	if !found {
+		return replaceAll(n, m)
+	}
+
+	var ops []Op
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, Equal)
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, Insert)
+			y--
+		} else {
+			ops = append(ops, Delete)
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, Equal)
+		x--
+		y--
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	// Within a run of changes the order is free, so put the deletions
+	// first, which reads best.
+	for i := 0; i < len(ops); {
+		if ops[i] == Equal {
+			i++
+			continue
+		}
+		j := i
+		deletes := 0
+		for ; j < len(ops) && ops[j] != Equal; j++ {
+			if ops[j] == Delete {
+				deletes++
+			}
+		}
+		for k := i; k < j; k++ {
+			if k-i < deletes {
+				ops[k] = Delete
+			} else {
+				ops[k] = Insert
+			}
+		}
+		i = j
+	}
+	return ops
+}
+
+func replaceAll(n, m int) []Op {
+	ops := make([]Op, 0, n+m)
+	for i := 0; i < n; i++ {
+		ops = append(ops, Delete)
+	}
+	for i := 0; i < m; i++ {
+		ops = append(ops, Insert)
+	}
+	return ops
+}
@@ -0,0 +1,126 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{
+			name: "Same",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "Changed line",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "From empty",
+			old:  "",
+			new:  "a\nb",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "To empty",
+			old:  "a",
+			new:  "",
+			want: "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "Line endings",
+			old:  "a\r\nb\r\n",
+			new:  "a\nb",
+			want: "",
+		},
+		{
+			name: "Separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "Merged hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want: "--- old\n+++ new\n@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			name: "Insertion",
+			old:  "a\nc\n",
+			new:  "a\nb\nc\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,3 @@\n a\n+b\n c\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Unified(tt.old, tt.new, "old", "new"), tt.want)
+		})
+	}
+}
+
+// TestLinesRoundTrip checks that the diff of two texts rebuilds both of
+// them, and that it is no longer than it needs to be.
+func TestLinesRoundTrip(t *testing.T) {
+	tests := []struct {
+		old, new string
+		edits    int
+	}{
+		{"a b c a b b a", "c b a b a c", 5},
+		{"x y z", "p q", 5},
+		{"1 2 3 4 5", "1 2 4 5 6", 2},
+	}
+	for _, tt := range tests {
+		old := strings.ReplaceAll(tt.old, " ", "\n")
+		new := strings.ReplaceAll(tt.new, " ", "\n")
+		var gotOld, gotNew []string
+		edits := 0
+		for _, l := range Lines(old, new) {
+			if l.Op != Insert {
+				gotOld = append(gotOld, l.Text)
+			}
+			if l.Op != Delete {
+				gotNew = append(gotNew, l.Text)
+			}
+			if l.Op != Equal {
+				edits++
+			}
+		}
+		assert.Equal(t, strings.Join(gotOld, "\n"), old)
+		assert.Equal(t, strings.Join(gotNew, "\n"), new)
+		assert.Equal(t, edits, tt.edits)
+	}
+}
+
+func TestHunkLineNumbers(t *testing.T) {
+	hunks := Hunks("a\nb\nc\n", "a\nc\nd\n", 0)
+	assert.Equal(t, len(hunks), 2)
+	assert.Equal(t, hunks[0].Header(), "@@ -2 +1,0 @@")
+	assert.Equal(t, hunks[0].Lines[0].OldLine, 2)
+	assert.Equal(t, hunks[1].Header(), "@@ -3,0 +3 @@")
+	assert.Equal(t, hunks[1].Lines[0].NewLine, 3)
+}
+
+func TestTooManyEdits(t *testing.T) {
+	var old, new strings.Builder
+	for i := 0; i < maxEdits; i++ {
+		fmt.Fprintln(&old, "old", i)
+		fmt.Fprintln(&new, "new", i)
+	}
+	lines := Lines(old.String(), new.String())
+	assert.Equal(t, len(lines), 2*maxEdits)
+	assert.Equal(t, lines[0].Op, Delete)
+	assert.Equal(t, lines[len(lines)-1].Op, Insert)
+}
@@ -0,0 +1,93 @@
+package highlight
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// interpreters maps the program named on a #! line to a language.
+var interpreters = map[string]string{
+	"sh":      "bash",
+	"bash":    "bash",
+	"zsh":     "bash",
+	"python":  "python",
+	"python3": "python",
+	"ruby":    "ruby",
+	"node":    "javascript",
+	"php":     "php",
+	"lua":     "lua",
+}
+
+// signatures are patterns that give a language away, tried in order. Earlier
+// ones are more specific: C++ has to be tried before C, for instance.
+var signatures = []struct {
+	language string
+	rx       *regexp.Regexp
+}{
+	{"php", regexp.MustCompile(`^\s*<\?php`)},
+	{"xml", regexp.MustCompile(`^\s*<\?xml`)},
+	{"html", regexp.MustCompile(`(?i)^\s*(<!doctype html|<html)`)},
+	{"diff", regexp.MustCompile(`(?m)^(diff --git |--- \S+.*\n\+\+\+ |@@ -\d+(,\d+)? \+\d+(,\d+)? @@)`)},
+	{"go", regexp.MustCompile(`(?m)^package \w+\s*$`)},
+	{"rust", regexp.MustCompile(`(?m)^\s*(pub )?fn \w+.*\{|\blet mut \w+`)},
+	{"cpp", regexp.MustCompile(`(?m)^#include <(iostream|vector|string|memory)>|\bstd::\w+`)},
+	{"c", regexp.MustCompile(`(?m)^#include [<"]\w+\.h[>"]`)},
+	{"csharp", regexp.MustCompile(`(?m)^using System(\.\w+)*;`)},
+	{"java", regexp.MustCompile(`(?m)^\s*public (final |abstract )?(class|interface) \w+|^import java\.`)},
+	{"kotlin", regexp.MustCompile(`(?m)^\s*fun \w+\(.*\)|^\s*val \w+ =`)},
+	{"dockerfile", regexp.MustCompile(`(?m)^FROM \S+.*\n(.*\n)*(RUN|COPY|CMD|ENTRYPOINT) `)},
+	{"python", regexp.MustCompile(`(?m)^\s*(def \w+\(.*\)|class \w+(\(.*\))?)\s*(->.*)?:\s*$|^(from \w+(\.\w+)* )?import \w+(, \w+)*\s*$`)},
+	{"sql", regexp.MustCompile(`(?im)^\s*(select .+ from |insert into |create (table|index) |update \w+ set |delete from )`)},
+	{"typescript", regexp.MustCompile(`(?m)^\s*(interface \w+ \{|(export )?type \w+ = )|\w+: (string|number|boolean)[;,)=]`)},
+	{"javascript", regexp.MustCompile(`(?m)^\s*(function \w*\(|(const|let|var) \w+ = )|=> \{|console\.log\(`)},
+	{"bash", regexp.MustCompile(`(?m)^\s*(echo |export \w+=|if \[\[? )`)},
+	{"css", regexp.MustCompile(`(?m)^\s*[.#]?[\w-]+(\s*[,>]?\s*[.#]?[\w-]+)*\s*\{\s*$(\n.*)*\n\s*[\w-]+\s*:\s*[^;]+;`)},
+}
+
+// Detect guesses the language of content from a #! line, from patterns
+// typical of the languages and lastly from chroma's own analysers, giving
+// Plaintext when nothing fits.
+func Detect(content string) string {
+	if lang, ok := detectShebang(content); ok {
+		return lang
+	}
+	trimmed := strings.TrimSpace(content)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	for _, sig := range signatures {
+		if sig.rx.MatchString(content) {
+			return sig.language
+		}
+	}
+	if lexer := lexers.Analyse(content); lexer != nil {
+		name := lexer.Config().Name
+		for _, lang := range Languages {
+			if strings.EqualFold(lang.Lexer, name) {
+				return lang.ID
+			}
+		}
+	}
+	return Plaintext
+}
+
+func detectShebang(content string) (string, bool) {
+	if !strings.HasPrefix(content, "#!") {
+		return "", false
+	}
+	line, _, _ := strings.Cut(content[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+	program := path.Base(fields[0])
+	if program == "env" && len(fields) > 1 {
+		program = fields[1]
+	}
+	lang, ok := interpreters[program]
+	return lang, ok
+}
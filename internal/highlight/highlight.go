@@ -0,0 +1,130 @@
+// Package highlight renders snippet content as syntax highlighted HTML. The
+// markup only carries CSS classes, so themes live in
+// ui/static/css/highlight.css; regenerate it for another chroma style with
+// Stylesheet.
+package highlight
+
+import (
+	"bytes"
+	"html"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// Plaintext is the language of snippets that aren't code, or whose language
+// couldn't be detected.
+const Plaintext = "plaintext"
+
+// Language is a language snippets can be written in. ID is what is stored
+// with a snippet and Lexer the name of the chroma lexer for it.
+type Language struct {
+	ID        string
+	Name      string
+	Lexer     string
+	Extension string
+}
+
+// Languages are the languages offered on the snippet forms. Markdown snippets
+// are rendered as documents by the templates rather than highlighted.
+var Languages = []Language{
+	{Plaintext, "Plain text", "plaintext", ".txt"},
+	{"bash", "Bash", "Bash", ".sh"},
+	{"c", "C", "C", ".c"},
+	{"cpp", "C++", "C++", ".cpp"},
+	{"csharp", "C#", "C#", ".cs"},
+	{"css", "CSS", "CSS", ".css"},
+	{"diff", "Diff", "Diff", ".diff"},
+	{"dockerfile", "Dockerfile", "Docker", ".dockerfile"},
+	{"go", "Go", "Go", ".go"},
+	{"haskell", "Haskell", "Haskell", ".hs"},
+	{"html", "HTML", "HTML", ".html"},
+	{"java", "Java", "Java", ".java"},
+	{"javascript", "JavaScript", "JavaScript", ".js"},
+	{"json", "JSON", "JSON", ".json"},
+	{"kotlin", "Kotlin", "Kotlin", ".kt"},
+	{"lua", "Lua", "Lua", ".lua"},
+	{"makefile", "Makefile", "Makefile", ".mk"},
+	{"markdown", "Markdown", "markdown", ".md"},
+	{"php", "PHP", "PHP", ".php"},
+	{"python", "Python", "Python", ".py"},
+	{"ruby", "Ruby", "Ruby", ".rb"},
+	{"rust", "Rust", "Rust", ".rs"},
+	{"sql", "SQL", "SQL", ".sql"},
+	{"swift", "Swift", "Swift", ".swift"},
+	{"toml", "TOML", "TOML", ".toml"},
+	{"typescript", "TypeScript", "TypeScript", ".ts"},
+	{"xml", "XML", "XML", ".xml"},
+	{"yaml", "YAML", "YAML", ".yaml"},
+}
+
+// Lookup returns the language with the given ID.
+func Lookup(id string) (Language, bool) {
+	for _, lang := range Languages {
+		if lang.ID == id {
+			return lang, true
+		}
+	}
+	return Language{}, false
+}
+
+// Valid reports whether id is one of Languages.
+func Valid(id string) bool {
+	_, ok := Lookup(id)
+	return ok
+}
+
+// Name returns the display name of a language, treating unknown ones as
+// plain text.
+func Name(id string) string {
+	lang, ok := Lookup(id)
+	if !ok {
+		lang, _ = Lookup(Plaintext)
+	}
+	return lang.Name
+}
+
+var formatter = chromahtml.New(
+	chromahtml.WithClasses(true),
+	chromahtml.WithLineNumbers(true),
+	chromahtml.LineNumbersInTable(true),
+	chromahtml.WithLinkableLineNumbers(true, "L"),
+	chromahtml.TabWidth(4),
+)
+
+// Render returns content as a highlighted table of lines, numbered and
+// linkable as #L1, #L2 and so on. Unknown languages are rendered as plain
+// text. Should highlighting fail, the content is returned escaped in a bare
+// pre block.
+func Render(content, language string) string {
+	lexer := lexers.Get(Plaintext)
+	if lang, ok := Lookup(language); ok {
+		if l := lexers.Get(lang.Lexer); l != nil {
+			lexer = l
+		}
+	}
+	lexer = chroma.Coalesce(lexer)
+	iterator, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return "<pre>" + html.EscapeString(content) + "</pre>"
+	}
+	var buf bytes.Buffer
+	err = formatter.Format(&buf, styles.Fallback, iterator)
+	if err != nil {
+		return "<pre>" + html.EscapeString(content) + "</pre>"
+	}
+	return buf.String()
+}
+
+// Stylesheet returns the CSS for the classes used by Render in the named
+// chroma style.
+func Stylesheet(style string) (string, error) {
+	var buf bytes.Buffer
+	err := formatter.WriteCSS(&buf, styles.Get(style))
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
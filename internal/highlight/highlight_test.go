@@ -0,0 +1,68 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"Shebang", "#!/bin/sh\nls -l\n", "bash"},
+		{"Env shebang", "#!/usr/bin/env python3\nprint(1)\n", "python"},
+		{"Unknown shebang", "#!/usr/bin/awk -f\n{ print }\n", Plaintext},
+		{"Go", "package main\n\nfunc main() {}\n", "go"},
+		{"Python", "def add(a, b):\n    return a + b\n", "python"},
+		{"PHP", "<?php echo 'hi';", "php"},
+		{"JSON", `{"a": [1, 2]}`, "json"},
+		{"Not JSON", `{a: 1}`, Plaintext},
+		{"HTML", "<!DOCTYPE html>\n<html></html>", "html"},
+		{"C", "#include <stdio.h>\nint main(void) { return 0; }\n", "c"},
+		{"C++", "#include <iostream>\nint main() { std::cout << 1; }\n", "cpp"},
+		{"SQL", "SELECT id FROM snippets;", "sql"},
+		{"Rust", "fn main() {\n    let mut x = 1;\n}\n", "rust"},
+		{"JavaScript", "const x = () => {\n  console.log(1);\n};\n", "javascript"},
+		{"Diff", "--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n", "diff"},
+		{"Prose", "An old silent pond...\nA frog jumps into the pond,\nsplash! Silence again.", Plaintext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Detect(tt.content), tt.want)
+		})
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	seen := map[string]bool{}
+	for _, lang := range Languages {
+		assert.Equal(t, seen[lang.ID], false)
+		seen[lang.ID] = true
+		assert.Equal(t, strings.HasPrefix(lang.Extension, "."), true)
+	}
+	for _, lang := range interpreters {
+		assert.Equal(t, Valid(lang), true)
+	}
+	for _, sig := range signatures {
+		assert.Equal(t, Valid(sig.language), true)
+	}
+	assert.Equal(t, Name("go"), "Go")
+	assert.Equal(t, Name("cobol"), "Plain text")
+}
+
+func TestRender(t *testing.T) {
+	out := Render("package main\n\nfunc main() {}\n", "go")
+	assert.StringContains(t, out, `<span class="kd">func</span>`)
+	assert.StringContains(t, out, `id="L3"`)
+	assert.StringContains(t, out, `href="#L3"`)
+	// Only classes, the CSP forbids inline styles.
+	assert.Equal(t, strings.Contains(out, "style="), false)
+
+	out = Render("<script>alert(1)</script>", "cobol")
+	assert.Equal(t, strings.Contains(out, "<script>"), false)
+	assert.StringContains(t, out, "&lt;script&gt;")
+}
@@ -0,0 +1,129 @@
+// Package mailer sends the plain text emails of the application through one
+// of several transports: an SMTP server, the log, or .eml files dropped in a
+// directory, the last two being meant for development and tests.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("mailer: header contains a line break")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTP hands messages to an SMTP server, authenticating with PLAIN auth when
+// Username is set. net/smtp upgrades to TLS whenever the server offers it,
+// and refuses to send credentials in the clear to anything but localhost.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTP) Send(msg Message) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("mailer: from: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("mailer: to: %w", err)
+	}
+	data, err := format(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return fmt.Errorf("mailer: %w", err)
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, from.Address, []string{to.Address}, data)
+}
+
+// Log writes messages to a logger instead of sending them.
+type Log struct {
+	Logger *slog.Logger
+}
+
+func (m *Log) Send(msg Message) error {
+	m.Logger.Info("email", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// File writes each message to its own .eml file in Dir, named so that they
+// sort in the order they were sent.
+type File struct {
+	Dir  string
+	From string
+}
+
+func (m *File) Send(msg Message) error {
+	now := time.Now()
+	data, err := format(m.From, msg, now)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 4)
+	_, err = rand.Read(b)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(b))
+	err = os.MkdirAll(m.Dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("mailer: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+	if err != nil {
+		return fmt.Errorf("mailer: %w", err)
+	}
+	return nil
+}
+
+// format renders msg as an RFC 5322 message. Header values may not contain
+// line breaks, which would let them smuggle in headers of their own.
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
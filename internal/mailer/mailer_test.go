@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+var testMessage = Message{
+	To:      "alice@example.com",
+	Subject: "Verify your email",
+	Body:    "Hello,\nfollow the link.\n",
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := &File{Dir: dir, From: "Snippetbox <no-reply@example.com>"}
+	assert.NilError(t, m.Send(testMessage))
+	assert.NilError(t, m.Send(testMessage))
+
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, filepath.Ext(entries[0].Name()), ".eml")
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	assert.NilError(t, err)
+	msg := string(data)
+	assert.StringContains(t, msg, "From: Snippetbox <no-reply@example.com>\r\n")
+	assert.StringContains(t, msg, "To: alice@example.com\r\n")
+	assert.StringContains(t, msg, "Subject: Verify your email\r\n")
+	assert.StringContains(t, msg, "\r\n\r\nHello,\r\nfollow the link.\r\n")
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Log{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	assert.NilError(t, m.Send(testMessage))
+	assert.StringContains(t, buf.String(), "to=alice@example.com")
+	assert.StringContains(t, buf.String(), `subject="Verify your email"`)
+}
+
+func TestHeaderInjection(t *testing.T) {
+	m := &File{Dir: t.TempDir(), From: "no-reply@example.com"}
+	msg := testMessage
+	msg.Subject = "Hi\r\nBcc: mallory@example.com"
+	assert.Equal(t, m.Send(msg), ErrInvalidHeader)
+}
+
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer ln.Close()
+
+	type envelope struct {
+		from, to, data string
+	}
+	received := make(chan envelope, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var env envelope
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "MAIL":
+				env.from = line
+				tp.PrintfLine("250 OK")
+			case "RCPT":
+				env.to = line
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 Go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				env.data = string(data)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 Bye")
+				received <- env
+				return
+			default:
+				tp.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+
+	m := &SMTP{Addr: ln.Addr().String(), From: "Snippetbox <no-reply@example.com>"}
+	msg := testMessage
+	msg.Body = ".leading dot\n"
+	assert.NilError(t, m.Send(msg))
+
+	env := <-received
+	assert.Equal(t, env.from, "MAIL FROM:<no-reply@example.com>")
+	assert.Equal(t, env.to, "RCPT TO:<alice@example.com>")
+	sc := bufio.NewScanner(strings.NewReader(env.data))
+	var body []string
+	inBody := false
+	for sc.Scan() {
+		if inBody {
+			body = append(body, sc.Text())
+		}
+		if sc.Text() == "" {
+			inBody = true
+		}
+	}
+	assert.Equal(t, strings.Join(body, "\n"), ".leading dot")
+}
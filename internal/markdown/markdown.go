@@ -0,0 +1,130 @@
+// Package markdown renders user-written Markdown into a safe subset of HTML.
+// Raw HTML in the source is dropped rather than passed through, and links and
+// images may only point at http, https and mailto URLs or at pages of the
+// site itself, so the output can't carry scripts past the
+// Content-Security-Policy.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// linkRel is set on every link, as the targets are chosen by users.
+const linkRel = "nofollow noopener ugc"
+
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(
+		parser.WithASTTransformers(util.Prioritized(linkPolicy{}, 100)),
+	),
+)
+
+// Render returns src as sanitized HTML. Should rendering fail, src is
+// returned escaped in a paragraph.
+func Render(src string) string {
+	var buf bytes.Buffer
+	err := md.Convert([]byte(src), &buf)
+	if err != nil {
+		return "<p>" + html.EscapeString(src) + "</p>"
+	}
+	return buf.String()
+}
+
+// SafeURL reports whether a link or image may point at dest: an absolute
+// http, https or mailto URL, or a reference without a scheme. dest is
+// checked the way it ends up in the browser: backslash escapes and entities
+// resolved as goldmark renders them, percent-encoding decoded, tabs and
+// newlines dropped and surrounding spaces and control characters trimmed.
+func SafeURL(dest string) bool {
+	b := util.UnescapePunctuations([]byte(dest))
+	b = util.ResolveNumericReferences(b)
+	b = util.ResolveEntityNames(b)
+	dest = string(b)
+	if decoded, err := url.PathUnescape(dest); err == nil {
+		dest = decoded
+	}
+	dest = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, dest)
+	dest = strings.TrimFunc(dest, func(r rune) bool { return r <= ' ' })
+	u, err := url.Parse(dest)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	case "":
+		// Opaque and host-only forms such as "//evil" are left out; they
+		// aren't needed for links within the site.
+		return u.Opaque == "" && u.Host == ""
+	}
+	return false
+}
+
+// linkPolicy unwraps links with unsafe destinations, leaving their text,
+// replaces such images with their alt text, and marks the remaining links
+// with linkRel.
+type linkPolicy struct{}
+
+func (linkPolicy) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var unsafe []ast.Node
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			if !SafeURL(string(n.Destination)) {
+				unsafe = append(unsafe, n)
+				return ast.WalkContinue, nil
+			}
+			n.SetAttributeString("rel", []byte(linkRel))
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL && !SafeURL(string(n.URL(source))) {
+				unsafe = append(unsafe, n)
+				return ast.WalkSkipChildren, nil
+			}
+			n.SetAttributeString("rel", []byte(linkRel))
+		case *ast.Image:
+			if !SafeURL(string(n.Destination)) {
+				unsafe = append(unsafe, n)
+				return ast.WalkSkipChildren, nil
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, n := range unsafe {
+		parent := n.Parent()
+		if parent == nil {
+			continue
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			for c := n.FirstChild(); c != nil; {
+				next := c.NextSibling()
+				parent.InsertBefore(parent, n, c)
+				c = next
+			}
+		case *ast.AutoLink:
+			parent.InsertBefore(parent, n, ast.NewString(n.Label(source)))
+		case *ast.Image:
+			parent.InsertBefore(parent, n, ast.NewString(n.Text(source)))
+		}
+		parent.RemoveChild(parent, n)
+	}
+}
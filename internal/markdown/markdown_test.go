@@ -0,0 +1,91 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		want     []string
+		wantNone []string
+	}{
+		{
+			name: "Formatting",
+			src:  "# Title\n\n*Some* **text** and `code`.\n\n- one\n- two\n\n~~gone~~",
+			want: []string{"<h1>Title</h1>", "<em>Some</em>", "<strong>text</strong>", "<code>code</code>", "<li>one</li>", "<del>gone</del>"},
+		},
+		{
+			name:     "Raw HTML",
+			src:      "<script>alert(1)</script>\n\nHi <b onclick='x()'>there</b>",
+			wantNone: []string{"<script", "<b", "onclick"},
+		},
+		{
+			name: "Safe link",
+			src:  "[docs](https://example.com/docs) and [home](/tag/go)",
+			want: []string{`<a href="https://example.com/docs" rel="nofollow noopener ugc">docs</a>`, `<a href="/tag/go" rel="nofollow noopener ugc">home</a>`},
+		},
+		{
+			name:     "Script link",
+			src:      "[click](javascript:alert(1)) [me](JavaScript:alert(1)) [data](data:text/html,x)",
+			want:     []string{"click", "me", "data"},
+			wantNone: []string{"<a", "javascript", "JavaScript", "data:"},
+		},
+		{
+			name:     "Entity-encoded script link",
+			src:      "[a](&#106;avascript:alert(1)) [b](&#x6A;avascript:alert(1)) [c](javascript&colon;alert(1)) [d](java&Tab;script:alert(1))",
+			want:     []string{"a", "b", "c", "d"},
+			wantNone: []string{"<a", "javascript", "alert"},
+		},
+		{
+			name:     "Percent-encoded script link",
+			src:      "[a](%6Aavascript:alert(1)) [b](javascript%3Aalert(1)) [c](java%09script:alert(1))",
+			want:     []string{"a", "b", "c"},
+			wantNone: []string{"<a", "alert"},
+		},
+		{
+			name:     "Whitespace-obfuscated script link",
+			src:      "[a](<java\tscript:alert(1)>) [b](<&#x20;javascript:alert(1)>) [c](&#x01;javascript:alert(1)) [d](\\javascript:alert(1))",
+			want:     []string{"a", "b", "c", "d"},
+			wantNone: []string{"<a", "alert"},
+		},
+		{
+			name:     "Protocol relative link",
+			src:      "[x](//evil.example.com)",
+			wantNone: []string{"<a"},
+		},
+		{
+			name: "Autolink",
+			src:  "see https://example.com",
+			want: []string{`<a href="https://example.com" rel="nofollow noopener ugc">https://example.com</a>`},
+		},
+		{
+			name:     "Script image",
+			src:      "![alt text](javascript:alert(1))",
+			want:     []string{"alt text"},
+			wantNone: []string{"<img", "javascript"},
+		},
+		{
+			name: "Fenced code",
+			src:  "```go\nfmt.Println(\"<hi>\")\n```",
+			want: []string{`<pre><code class="language-go">`, "&lt;hi&gt;"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := Render(tt.src)
+			for _, want := range tt.want {
+				assert.StringContains(t, out, want)
+			}
+			for _, unwanted := range tt.wantNone {
+				if strings.Contains(out, unwanted) {
+					t.Errorf("got %q; should not contain %q", out, unwanted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeletedAuthor is the name content is credited to once its author has
+// deleted their account, leaving it up. It comes with no user ID, so
+// nobody can edit it any more.
+const DeletedAuthor = "[deleted]"
+
+// DeletedAuthorMap returns the Author map of content whose author deleted
+// their account.
+func DeletedAuthorMap() map[string]string {
+	return map[string]string{DeletedAuthor: ""}
+}
+
+// SetName renames the user. The snippets, revisions and comments they
+// wrote, and the copies of their snippets in favourites, are credited to
+// the new name.
+func (m *UserModel) SetName(id primitive.ObjectID, name string) error {
+	user, err := m.GetByID(id)
+	if err != nil {
+		return err
+	}
+	_, err = m.DB.Collection("users").UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": bson.M{"name": name}})
+	if err != nil {
+		return err
+	}
+	return m.setAuthor(user, map[string]string{name: id.Hex()})
+}
+
+// setAuthor credits everything user wrote to author.
+func (m *UserModel) setAuthor(user User, author map[string]string) error {
+	filter := writtenBy(user.ID.Hex())
+	// The copies in favourites are found by the IDs of the snippets, since
+	// array filters can't search Author the way writtenBy does.
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cur, err := m.DB.Collection("snippets").Find(context.TODO(), filter, opts)
+	if err != nil {
+		return err
+	}
+	var snippets []Snippet
+	err = cur.All(context.TODO(), &snippets)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"snippets", "revisions", "commentaries"} {
+		_, err := m.DB.Collection(name).UpdateMany(context.TODO(), filter, bson.M{"$set": bson.M{"Author": author}})
+		if err != nil {
+			return err
+		}
+	}
+	if len(snippets) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(snippets))
+	for i, s := range snippets {
+		ids[i] = s.ID
+	}
+	in := bson.M{"$in": ids}
+	updateOpts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []any{bson.M{"f._id": in}},
+	})
+	_, err = m.DB.Collection("users").UpdateMany(context.TODO(),
+		bson.M{"favourites._id": in},
+		bson.M{"$set": bson.M{"favourites.$[f].Author": author}}, updateOpts)
+	return err
+}
+
+// SetEmail changes the address of the user, who has to verify it again.
+func (m *UserModel) SetEmail(id primitive.ObjectID, email string) error {
+	update := bson.M{"$set": bson.M{"email": email, "email_verified": false}}
+	result, err := m.DB.Collection("users").UpdateOne(context.TODO(), bson.M{"_id": id}, update)
+	if err != nil {
+		if strings.Contains(err.Error(), "E11000 duplicate key error") {
+			return ErrDuplicateEmail
+		}
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Delete removes the user along with their API tokens, audit log and
+// favourites. With keepContent their public and unlisted snippets and
+// their comments stay up, credited to DeletedAuthor; their private
+// snippets, which nobody else could see, go either way. Without it all
+// they wrote is removed, comments with the replies to them.
+func (m *UserModel) Delete(id primitive.ObjectID, keepContent bool) error {
+	var user User
+	err := m.DB.Collection("users").FindOne(context.TODO(), bson.M{"_id": id}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	authored := writtenBy(id.Hex())
+
+	snippets := authored
+	if keepContent {
+		snippets = writtenBy(id.Hex())
+		snippets["visibility"] = VisibilityPrivate
+	}
+	err = m.deleteSnippets(snippets)
+	if err != nil {
+		return err
+	}
+	if keepContent {
+		err = m.setAuthor(user, DeletedAuthorMap())
+	} else {
+		err = m.deleteComments(authored)
+	}
+	if err != nil {
+		return err
+	}
+
+	var favourites []primitive.ObjectID
+	for _, f := range user.Favourites {
+		favourites = append(favourites, f.ID)
+	}
+	if len(favourites) > 0 {
+		_, err = m.DB.Collection("snippets").UpdateMany(context.TODO(),
+			bson.M{"_id": bson.M{"$in": favourites}}, bson.M{"$inc": bson.M{"favourited": -1}})
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{"api_tokens", "audit_log"} {
+		_, err = m.DB.Collection(name).DeleteMany(context.TODO(), bson.M{"user_id": id})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = m.DB.Collection("users").DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// deleteSnippets removes the snippets matching filter the way
+// SnippetModel.Delete does.
+func (m *UserModel) deleteSnippets(filter bson.M) error {
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cur, err := m.DB.Collection("snippets").Find(context.TODO(), filter, opts)
+	if err != nil {
+		return err
+	}
+	var snippets []Snippet
+	err = cur.All(context.TODO(), &snippets)
+	if err != nil || len(snippets) == 0 {
+		return err
+	}
+	ids := make([]primitive.ObjectID, len(snippets))
+	for i, s := range snippets {
+		ids[i] = s.ID
+	}
+	in := bson.M{"$in": ids}
+	_, err = m.DB.Collection("snippets").DeleteMany(context.TODO(), bson.M{"_id": in})
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"revisions", "commentaries"} {
+		_, err = m.DB.Collection(name).DeleteMany(context.TODO(), bson.M{"snippet_id": in})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = m.DB.Collection("users").UpdateMany(context.TODO(),
+		bson.M{"favourites._id": in}, bson.M{"$pull": bson.M{"favourites": bson.M{"_id": in}}})
+	return err
+}
+
+// deleteComments removes the comments matching filter with the replies to
+// them, the way CommentaryModel.DeleteComentary does.
+func (m *UserModel) deleteComments(filter bson.M) error {
+	collection := m.DB.Collection("commentaries")
+	cur, err := collection.Find(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	var comments []Commentary
+	err = cur.All(context.TODO(), &comments)
+	if err != nil {
+		return err
+	}
+	removed := map[primitive.ObjectID]bool{}
+	for _, comment := range comments {
+		if removed[comment.ID] {
+			continue
+		}
+		cur, err := collection.Find(context.TODO(), bson.M{"thread_id": comment.ThreadID})
+		if err != nil {
+			return err
+		}
+		var thread []Commentary
+		err = cur.All(context.TODO(), &thread)
+		if err != nil {
+			return err
+		}
+		ids := Descendants(thread, comment.ID)
+		for _, id := range ids {
+			removed[id] = true
+		}
+		result, err := collection.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		_, err = m.DB.Collection("snippets").UpdateOne(context.TODO(),
+			bson.M{"_id": comment.SnippetID}, bson.M{"$inc": bson.M{"commented": -result.DeletedCount}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Events recorded in the audit log.
+const (
+	AuditLoginLockout    = "login.lockout"
+	AuditEmailChanged    = "account.email"
+	AuditPasswordChanged = "account.password"
+)
+
+// AuditEntry records a security event. UserID is zero when the event
+// concerns an email address no account has, such as a lockout after
+// guesses at one.
+type AuditEntry struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	UserID  primitive.ObjectID `bson:"user_id"`
+	Event   string             `bson:"event"`
+	Email   string             `bson:"email"`
+	IP      string             `bson:"ip"`
+	Created time.Time          `bson:"created"`
+}
+
+// NewAuditEntry returns an entry for event, timestamped now.
+func NewAuditEntry(event string, userID primitive.ObjectID, email, ip string) AuditEntry {
+	return AuditEntry{
+		ID:      primitive.NewObjectID(),
+		UserID:  userID,
+		Event:   event,
+		Email:   email,
+		IP:      ip,
+		Created: time.Now().UTC(),
+	}
+}
+
+func (m *UserModel) AddAuditEntry(entry AuditEntry) error {
+	_, err := m.DB.Collection("audit_log").InsertOne(context.TODO(), entry)
+	return err
+}
+
+// AuditEntries returns the latest limit audit entries of a user, newest
+// first.
+func (m *UserModel) AuditEntries(userID primitive.ObjectID, limit int) ([]AuditEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cur, err := m.DB.Collection("audit_log").Find(context.TODO(), bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []AuditEntry{}
+	err = cur.All(context.TODO(), &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a password"), 12)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// CompareDummyPassword does the work of checking password against a stored
+// hash, for when there is no user with the email given. Failing then takes
+// as long as a wrong password does, so the response time doesn't tell
+// whether an account exists.
+func CompareDummyPassword(password string) {
+	bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+}
@@ -1,40 +1,391 @@
 package models
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Commentary is a comment on a snippet. Markdown comments have their content
+// rendered as Markdown, the rest are shown as plain text. Replies carry the
+// ID of the comment they answer in ParentID; top level comments have the
+// zero ID there. ThreadID is the ID of the top level comment a reply is
+// under, and a top level comment's own ID.
 type Commentary struct {
-	Author  map[string]string `bson:"Author"`
-	Content string            `bson:"content"`
-	Created time.Time         `bson:"created"`
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	SnippetID primitive.ObjectID `bson:"snippet_id"`
+	ParentID  primitive.ObjectID `bson:"parent_id,omitempty"`
+	ThreadID  primitive.ObjectID `bson:"thread_id"`
+	Author    map[string]string  `bson:"Author"`
+	Content   string             `bson:"content"`
+	Markdown  bool               `bson:"markdown,omitempty"`
+	Created   time.Time          `bson:"created"`
+	// Edited is when the content was last changed, or the zero time.
+	Edited time.Time `bson:"edited,omitempty"`
+	// Expires mirrors the snippet's, so the TTL index removes the comments
+	// along with it.
+	Expires time.Time `bson:"expires,omitempty"`
+}
+
+// AuthorID returns the hex ID of the user who wrote the comment.
+func (c Commentary) AuthorID() string {
+	for _, id := range c.Author {
+		return id
+	}
+	return ""
+}
+
+// CommentaryStore is implemented by every storage backend. Comments are kept
+// apart from their snippet, so that popular snippets don't grow without
+// bound. Replies must answer a comment on the same snippet.
+// UpdateComentary and DeleteComentary return ErrNotAuthor unless userIDStr
+// wrote the comment or the snippet, and deleting a comment deletes the
+// replies to it as well. Commentaries and Commentary leave checking access
+// to the snippet to the caller.
+type CommentaryStore interface {
+	AddComentary(ID, ParentID primitive.ObjectID, Author map[string]string, Content string, Markdown bool) (primitive.ObjectID, error)
+	UpdateComentary(ID, CommentID primitive.ObjectID, userIDStr, Content string, Markdown bool) error
+	DeleteComentary(ID, CommentID primitive.ObjectID, userIDStr string) error
+	Commentaries(ID primitive.ObjectID, q CommentQuery) (CommentPage, error)
+	Commentary(ID, CommentID primitive.ObjectID) (Commentary, error)
+}
+
+const (
+	DefaultCommentLimit = 20
+	MaxCommentLimit     = 50
+)
+
+// CommentQuery selects one page of the threads on a snippet, newest thread
+// first. Like PageQuery it pages by keyset: After and Before are cursors
+// taken from a previous CommentPage, and at most one of them should be set.
+type CommentQuery struct {
+	Limit  int
+	After  string
+	Before string
+}
+
+// Normalize fills in the default limit and clamps it to MaxCommentLimit.
+func (q CommentQuery) Normalize() CommentQuery {
+	if q.Limit <= 0 {
+		q.Limit = DefaultCommentLimit
+	}
+	if q.Limit > MaxCommentLimit {
+		q.Limit = MaxCommentLimit
+	}
+	return q
+}
+
+// Backward reports whether the page is read backwards from the Before
+// cursor, in which case stores fetch the oldest threads first.
+func (q CommentQuery) Backward() bool {
+	return q.Before != ""
+}
+
+// Cursor decodes whichever of After or Before is set, which is the ID of a
+// top level comment. ok is false if neither is.
+func (q CommentQuery) Cursor() (id primitive.ObjectID, ok bool, err error) {
+	raw := q.After
+	if q.Backward() {
+		raw = q.Before
+	}
+	if raw == "" {
+		return primitive.NilObjectID, false, nil
+	}
+	id, err = primitive.ObjectIDFromHex(raw)
+	if err != nil {
+		return primitive.NilObjectID, false, ErrInvalidCursor
+	}
+	return id, true, nil
+}
+
+// CommentPage is one page of the threads on a snippet: the top level
+// comments, newest first, followed by every reply to them, oldest first.
+// Next and Prev are empty when there is nothing further in that direction.
+type CommentPage struct {
+	Commentaries []Commentary
+	Next         string
+	Prev         string
+}
+
+// NewCommentPage builds a CommentPage from the top level comments fetched by
+// a store in query order with a limit of q.Limit+1, as NewPage does. The
+// store then adds the replies to the threads of the page with AddReplies.
+func NewCommentPage(q CommentQuery, roots []Commentary) CommentPage {
+	more := len(roots) > q.Limit
+	if more {
+		roots = roots[:q.Limit]
+	}
+	if q.Backward() {
+		slices.Reverse(roots)
+	}
+	page := CommentPage{Commentaries: roots}
+	if len(roots) == 0 {
+		page.Commentaries = []Commentary{}
+		return page
+	}
+	first, last := roots[0].ID.Hex(), roots[len(roots)-1].ID.Hex()
+	if q.Backward() {
+		page.Next = last
+		if more {
+			page.Prev = first
+		}
+	} else {
+		if more {
+			page.Next = last
+		}
+		if q.After != "" {
+			page.Prev = first
+		}
+	}
+	return page
+}
+
+// ThreadIDs returns the IDs of the threads on the page.
+func (p CommentPage) ThreadIDs() []primitive.ObjectID {
+	var ids []primitive.ObjectID
+	for _, c := range p.Commentaries {
+		if c.ParentID.IsZero() {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids
+}
+
+// AddReplies appends the replies to the threads of the page, oldest first.
+func (p *CommentPage) AddReplies(replies []Commentary) {
+	slices.SortFunc(replies, func(a, b Commentary) int {
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+	p.Commentaries = append(p.Commentaries, replies...)
+}
+
+// SetThreads fills in the ThreadID of each of the comments on a snippet
+// from their parents. Replies to comments that aren't there start threads
+// of their own, as Threads shows them.
+func SetThreads(commentaries []Commentary) {
+	parents := map[primitive.ObjectID]primitive.ObjectID{}
+	for _, c := range commentaries {
+		parents[c.ID] = c.ParentID
+	}
+	for i, c := range commentaries {
+		root := c.ID
+		// The step limit guards against cycles in bad data.
+		for step := 0; step < len(commentaries); step++ {
+			parent, ok := parents[root]
+			if !ok || parent.IsZero() {
+				break
+			}
+			if _, ok := parents[parent]; !ok {
+				break
+			}
+			root = parent
+		}
+		commentaries[i].ThreadID = root
+	}
+}
+
+// Thread is a comment with the replies to it, oldest first.
+type Thread struct {
+	Commentary
+	Replies []Thread
+}
+
+// Threads arranges the comments of a snippet into threads, keeping the order
+// they are given in. Replies to comments that aren't there are shown at the
+// top level.
+func Threads(commentaries []Commentary) []Thread {
+	children := map[primitive.ObjectID][]Commentary{}
+	present := map[primitive.ObjectID]bool{}
+	for _, c := range commentaries {
+		present[c.ID] = true
+	}
+	var roots []Commentary
+	for _, c := range commentaries {
+		if c.ParentID.IsZero() || !present[c.ParentID] || c.ParentID == c.ID {
+			roots = append(roots, c)
+		} else {
+			children[c.ParentID] = append(children[c.ParentID], c)
+		}
+	}
+	var build func(cs []Commentary) []Thread
+	build = func(cs []Commentary) []Thread {
+		threads := make([]Thread, len(cs))
+		for i, c := range cs {
+			threads[i] = Thread{Commentary: c, Replies: build(children[c.ID])}
+		}
+		return threads
+	}
+	return build(roots)
+}
+
+// Descendants returns the IDs of id and of every reply below it.
+func Descendants(commentaries []Commentary, id primitive.ObjectID) []primitive.ObjectID {
+	ids := []primitive.ObjectID{id}
+	for i := 0; i < len(ids); i++ {
+		for _, c := range commentaries {
+			if c.ParentID == ids[i] && c.ID != ids[i] {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// CanModifyComment reports whether the user with the hex ID userIDStr may
+// edit or delete c, a comment on s.
+func CanModifyComment(s Snippet, c Commentary, userIDStr string) bool {
+	return userIDStr != "" && (c.AuthorID() == userIDStr || s.AuthorID() == userIDStr)
 }
 
 type CommentaryModel struct {
-	Client *mongo.Client
+	DB *mongo.Database
 }
 
-func (c *CommentaryModel) AddComentary(ID primitive.ObjectID, Author map[string]string, Content string) error {
-	collection := c.Client.Database("snippetbox").Collection("snippets")
+func (c *CommentaryModel) AddComentary(ID, ParentID primitive.ObjectID, Author map[string]string, Content string, Markdown bool) (primitive.ObjectID, error) {
+	snippet, err := (&SnippetModel{DB: c.DB}).Get(ID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
 	Commentary := Commentary{
-		Author:  Author,
-		Content: Content,
-		Created: time.Now().UTC(),
+		ID:        primitive.NewObjectID(),
+		SnippetID: ID,
+		ParentID:  ParentID,
+		Author:    Author,
+		Content:   Content,
+		Markdown:  Markdown,
+		Created:   time.Now().UTC(),
+		Expires:   snippet.Expires,
+	}
+	Commentary.ThreadID = Commentary.ID
+	if !ParentID.IsZero() {
+		parent, err := c.Commentary(ID, ParentID)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		Commentary.ThreadID = parent.ThreadID
+	}
+	_, err = c.DB.Collection("commentaries").InsertOne(context.TODO(), Commentary)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	_, err = c.DB.Collection("snippets").UpdateOne(context.TODO(), bson.M{"_id": ID}, bson.M{"$inc": bson.M{"commented": 1}})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return Commentary.ID, nil
+}
+
+func (c *CommentaryModel) Commentary(ID, CommentID primitive.ObjectID) (Commentary, error) {
+	var comment Commentary
+	err := c.DB.Collection("commentaries").FindOne(context.TODO(), bson.M{"_id": CommentID, "snippet_id": ID}).Decode(&comment)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Commentary{}, ErrNoRecord
+		}
+		return Commentary{}, err
+	}
+	return comment, nil
+}
+
+func (c *CommentaryModel) Commentaries(ID primitive.ObjectID, q CommentQuery) (CommentPage, error) {
+	q = q.Normalize()
+	collection := c.DB.Collection("commentaries")
+	// A thread starts at the comment it is named after. Replies whose parent
+	// was missing when the threads were set start threads too, so roots
+	// can't be told by parent_id alone.
+	filter := bson.M{"snippet_id": ID, "$expr": bson.M{"$eq": bson.A{"$thread_id", "$_id"}}}
+	direction, op := -1, "$lt"
+	if q.Backward() {
+		direction, op = 1, "$gt"
+	}
+	cursor, ok, err := q.Cursor()
+	if err != nil {
+		return CommentPage{}, err
+	}
+	if ok {
+		filter["_id"] = bson.M{op: cursor}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: direction}}).SetLimit(int64(q.Limit + 1))
+	cur, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	var roots []Commentary
+	err = cur.All(context.TODO(), &roots)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	page := NewCommentPage(q, roots)
+	if len(page.Commentaries) == 0 {
+		return page, nil
+	}
+	filter = bson.M{"thread_id": bson.M{"$in": page.ThreadIDs()}, "_id": bson.M{"$nin": page.ThreadIDs()}}
+	cur, err = collection.Find(context.TODO(), filter)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	var replies []Commentary
+	err = cur.All(context.TODO(), &replies)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	page.AddReplies(replies)
+	return page, nil
+}
+
+// comment loads the comment, checking that the user may change it.
+func (c *CommentaryModel) comment(ID, CommentID primitive.ObjectID, userIDStr string) (Commentary, error) {
+	snippet, err := (&SnippetModel{DB: c.DB}).Get(ID)
+	if err != nil {
+		return Commentary{}, err
+	}
+	comment, err := c.Commentary(ID, CommentID)
+	if err != nil {
+		return Commentary{}, err
+	}
+	if !CanModifyComment(snippet, comment, userIDStr) {
+		return Commentary{}, ErrNotAuthor
+	}
+	return comment, nil
+}
+
+func (c *CommentaryModel) UpdateComentary(ID, CommentID primitive.ObjectID, userIDStr, Content string, Markdown bool) error {
+	_, err := c.comment(ID, CommentID, userIDStr)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{"content": Content, "markdown": Markdown, "edited": time.Now().UTC()}}
+	_, err = c.DB.Collection("commentaries").UpdateOne(context.TODO(), bson.M{"_id": CommentID}, update)
+	return err
+}
+
+func (c *CommentaryModel) DeleteComentary(ID, CommentID primitive.ObjectID, userIDStr string) error {
+	comment, err := c.comment(ID, CommentID, userIDStr)
+	if err != nil {
+		return err
 	}
-	filter := bson.M{
-		"_id": ID,
+	collection := c.DB.Collection("commentaries")
+	cur, err := collection.Find(context.TODO(), bson.M{"thread_id": comment.ThreadID})
+	if err != nil {
+		return err
 	}
-	result, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$push": bson.M{"commentaries": Commentary}})
+	var thread []Commentary
+	err = cur.All(context.TODO(), &thread)
 	if err != nil {
 		return err
 	}
-	if result.MatchedCount == 0 {
-		return ErrNoRecord
+	ids := Descendants(thread, CommentID)
+	result, err := collection.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
 	}
-	return nil
+	_, err = c.DB.Collection("snippets").UpdateOne(context.TODO(), bson.M{"_id": ID}, bson.M{"$inc": bson.M{"commented": -result.DeletedCount}})
+	return err
 }
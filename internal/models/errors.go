@@ -8,4 +8,12 @@ var (
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+
+	ErrAlreadyFavourite = errors.New("models: post is already in favourites")
+
+	ErrNotAuthor = errors.New("models: user is not the author")
+
+	ErrInvalidCursor = errors.New("models: invalid page cursor")
+
+	ErrCodeUsed = errors.New("models: one-time code already used")
 )
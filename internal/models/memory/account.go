@@ -0,0 +1,144 @@
+package memory
+
+import (
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *UserModel) SetName(id primitive.ObjectID, name string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	user, ok := m.DB.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	user.Name = name
+	m.DB.users[id] = user
+	m.DB.setAuthor(id.Hex(), map[string]string{name: id.Hex()})
+	return nil
+}
+
+// setAuthor credits everything the user with the hex ID userIDStr wrote to
+// author. The caller must hold the write lock.
+func (db *DB) setAuthor(userIDStr string, author map[string]string) {
+	for id, s := range db.snippets {
+		if s.AuthorID() == userIDStr {
+			s.Author = copyAuthor(author)
+			db.snippets[id] = s
+		}
+	}
+	for _, revisions := range db.revisions {
+		for i, r := range revisions {
+			if r.AuthorID() == userIDStr {
+				revisions[i].Author = copyAuthor(author)
+			}
+		}
+	}
+	for _, commentaries := range db.commentaries {
+		for i, c := range commentaries {
+			if c.AuthorID() == userIDStr {
+				commentaries[i].Author = copyAuthor(author)
+			}
+		}
+	}
+	for _, u := range db.users {
+		for i, f := range u.Favourites {
+			if f.AuthorID() == userIDStr {
+				u.Favourites[i].Author = copyAuthor(author)
+			}
+		}
+	}
+}
+
+func (m *UserModel) SetEmail(id primitive.ObjectID, email string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	user, ok := m.DB.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	for _, u := range m.DB.users {
+		if u.Email == email && u.ID != id {
+			return models.ErrDuplicateEmail
+		}
+	}
+	user.Email = email
+	user.EmailVerified = false
+	m.DB.users[id] = user
+	return nil
+}
+
+func (m *UserModel) Delete(id primitive.ObjectID, keepContent bool) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	user, ok := m.DB.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	idStr := id.Hex()
+
+	for snippetID, s := range m.DB.snippets {
+		if s.AuthorID() == idStr && (!keepContent || s.Visibility == models.VisibilityPrivate) {
+			delete(m.DB.snippets, snippetID)
+			delete(m.DB.revisions, snippetID)
+			delete(m.DB.commentaries, snippetID)
+			m.DB.removeFavourite(snippetID)
+		}
+	}
+	if keepContent {
+		m.DB.setAuthor(idStr, models.DeletedAuthorMap())
+	} else {
+		m.DB.deleteComments(idStr)
+	}
+
+	for _, f := range user.Favourites {
+		if snippet, ok := m.DB.snippets[f.ID]; ok {
+			snippet.Favourited--
+			m.DB.snippets[f.ID] = snippet
+		}
+	}
+	for tokenID, t := range m.DB.tokens {
+		if t.UserID == id {
+			delete(m.DB.tokens, tokenID)
+		}
+	}
+	audit := m.DB.audit[:0]
+	for _, e := range m.DB.audit {
+		if e.UserID != id {
+			audit = append(audit, e)
+		}
+	}
+	m.DB.audit = audit
+	delete(m.DB.users, id)
+	return nil
+}
+
+// deleteComments removes the comments the user with the hex ID userIDStr
+// wrote, with the replies to them. The caller must hold the write lock.
+func (db *DB) deleteComments(userIDStr string) {
+	for snippetID, commentaries := range db.commentaries {
+		removed := map[primitive.ObjectID]bool{}
+		for _, c := range commentaries {
+			if c.AuthorID() == userIDStr && !removed[c.ID] {
+				for _, id := range models.Descendants(commentaries, c.ID) {
+					removed[id] = true
+				}
+			}
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		var kept []models.Commentary
+		for _, c := range commentaries {
+			if !removed[c.ID] {
+				kept = append(kept, c)
+			}
+		}
+		if snippet, ok := db.snippets[snippetID]; ok {
+			snippet.Commented -= len(commentaries) - len(kept)
+			db.snippets[snippetID] = snippet
+		}
+		db.commentaries[snippetID] = kept
+	}
+}
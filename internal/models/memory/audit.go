@@ -0,0 +1,26 @@
+package memory
+
+import (
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *UserModel) AddAuditEntry(entry models.AuditEntry) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	m.DB.audit = append(m.DB.audit, entry)
+	return nil
+}
+
+func (m *UserModel) AuditEntries(userID primitive.ObjectID, limit int) ([]models.AuditEntry, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	entries := []models.AuditEntry{}
+	for i := len(m.DB.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		if m.DB.audit[i].UserID == userID {
+			entries = append(entries, m.DB.audit[i])
+		}
+	}
+	return entries, nil
+}
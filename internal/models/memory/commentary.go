@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"bytes"
+	"slices"
+	"time"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CommentaryModel struct {
+	DB *DB
+}
+
+func (c *CommentaryModel) AddComentary(ID, ParentID primitive.ObjectID, Author map[string]string, Content string, Markdown bool) (primitive.ObjectID, error) {
+	c.DB.mu.Lock()
+	defer c.DB.mu.Unlock()
+	snippet, ok := c.DB.snippets[ID]
+	if !ok || snippet.IsExpired() {
+		return primitive.NilObjectID, models.ErrNoRecord
+	}
+	commentary := copyCommentary(models.Commentary{
+		ID:        primitive.NewObjectID(),
+		SnippetID: ID,
+		ParentID:  ParentID,
+		Author:    Author,
+		Content:   Content,
+		Markdown:  Markdown,
+		Created:   time.Now().UTC(),
+	})
+	commentary.ThreadID = commentary.ID
+	if !ParentID.IsZero() {
+		i := commentIndex(c.DB.commentaries[ID], ParentID)
+		if i < 0 {
+			return primitive.NilObjectID, models.ErrNoRecord
+		}
+		commentary.ThreadID = c.DB.commentaries[ID][i].ThreadID
+	}
+	c.DB.commentaries[ID] = append(c.DB.commentaries[ID], commentary)
+	snippet.Commented++
+	c.DB.snippets[ID] = snippet
+	return commentary.ID, nil
+}
+
+func (c *CommentaryModel) Commentary(ID, CommentID primitive.ObjectID) (models.Commentary, error) {
+	c.DB.mu.RLock()
+	defer c.DB.mu.RUnlock()
+	commentaries := c.DB.commentaries[ID]
+	i := commentIndex(commentaries, CommentID)
+	if i < 0 {
+		return models.Commentary{}, models.ErrNoRecord
+	}
+	return copyCommentary(commentaries[i]), nil
+}
+
+func (c *CommentaryModel) Commentaries(ID primitive.ObjectID, q models.CommentQuery) (models.CommentPage, error) {
+	q = q.Normalize()
+	cursor, ok, err := q.Cursor()
+	if err != nil {
+		return models.CommentPage{}, err
+	}
+	c.DB.mu.RLock()
+	defer c.DB.mu.RUnlock()
+	var roots []models.Commentary
+	for _, commentary := range c.DB.commentaries[ID] {
+		if commentary.ThreadID != commentary.ID {
+			continue
+		}
+		cmp := bytes.Compare(commentary.ID[:], cursor[:])
+		if ok && (q.Backward() && cmp <= 0 || !q.Backward() && cmp >= 0) {
+			continue
+		}
+		roots = append(roots, copyCommentary(commentary))
+	}
+	// Stored oldest first, the threads are read newest first unless going
+	// backwards.
+	if !q.Backward() {
+		slices.Reverse(roots)
+	}
+	if len(roots) > q.Limit+1 {
+		roots = roots[:q.Limit+1]
+	}
+	page := models.NewCommentPage(q, roots)
+	threads := map[primitive.ObjectID]bool{}
+	for _, id := range page.ThreadIDs() {
+		threads[id] = true
+	}
+	var replies []models.Commentary
+	for _, commentary := range c.DB.commentaries[ID] {
+		if commentary.ThreadID != commentary.ID && threads[commentary.ThreadID] {
+			replies = append(replies, copyCommentary(commentary))
+		}
+	}
+	page.AddReplies(replies)
+	return page, nil
+}
+
+func (c *CommentaryModel) UpdateComentary(ID, CommentID primitive.ObjectID, userIDStr, Content string, Markdown bool) error {
+	c.DB.mu.Lock()
+	defer c.DB.mu.Unlock()
+	i, err := c.DB.comment(ID, CommentID, userIDStr)
+	if err != nil {
+		return err
+	}
+	commentary := &c.DB.commentaries[ID][i]
+	commentary.Content = Content
+	commentary.Markdown = Markdown
+	commentary.Edited = time.Now().UTC()
+	return nil
+}
+
+func (c *CommentaryModel) DeleteComentary(ID, CommentID primitive.ObjectID, userIDStr string) error {
+	c.DB.mu.Lock()
+	defer c.DB.mu.Unlock()
+	_, err := c.DB.comment(ID, CommentID, userIDStr)
+	if err != nil {
+		return err
+	}
+	removed := map[primitive.ObjectID]bool{}
+	for _, id := range models.Descendants(c.DB.commentaries[ID], CommentID) {
+		removed[id] = true
+	}
+	var kept []models.Commentary
+	for _, commentary := range c.DB.commentaries[ID] {
+		if !removed[commentary.ID] {
+			kept = append(kept, commentary)
+		}
+	}
+	snippet := c.DB.snippets[ID]
+	snippet.Commented -= len(c.DB.commentaries[ID]) - len(kept)
+	c.DB.snippets[ID] = snippet
+	c.DB.commentaries[ID] = kept
+	return nil
+}
+
+// comment returns the index of the comment among those on the snippet,
+// checking that the user may change it. The caller must hold the write
+// lock.
+func (db *DB) comment(ID, CommentID primitive.ObjectID, userIDStr string) (int, error) {
+	snippet, ok := db.snippets[ID]
+	if !ok || snippet.IsExpired() {
+		return 0, models.ErrNoRecord
+	}
+	i := commentIndex(db.commentaries[ID], CommentID)
+	if i < 0 {
+		return 0, models.ErrNoRecord
+	}
+	if !models.CanModifyComment(snippet, db.commentaries[ID][i], userIDStr) {
+		return 0, models.ErrNotAuthor
+	}
+	return i, nil
+}
+
+func commentIndex(commentaries []models.Commentary, id primitive.ObjectID) int {
+	for i, c := range commentaries {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
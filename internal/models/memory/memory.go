@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"slices"
+	"sync"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DB is an in-memory replacement for the MongoDB database. It is safe for
+// concurrent use and every value handed out is a copy, so callers can never
+// mutate the stored records behind the lock.
+type DB struct {
+	mu       sync.RWMutex
+	snippets map[primitive.ObjectID]models.Snippet
+	users    map[primitive.ObjectID]models.User
+	tokens   map[primitive.ObjectID]models.APIToken
+	// revisions holds the history of each snippet, oldest first.
+	revisions map[primitive.ObjectID][]models.Revision
+	// commentaries holds the comments on each snippet, oldest first.
+	commentaries map[primitive.ObjectID][]models.Commentary
+	// audit holds the audit log, oldest first.
+	audit []models.AuditEntry
+}
+
+func New() *DB {
+	return &DB{
+		snippets:     make(map[primitive.ObjectID]models.Snippet),
+		users:        make(map[primitive.ObjectID]models.User),
+		tokens:       make(map[primitive.ObjectID]models.APIToken),
+		revisions:    make(map[primitive.ObjectID][]models.Revision),
+		commentaries: make(map[primitive.ObjectID][]models.Commentary),
+	}
+}
+
+// SeedSnippet stores s as is, keeping its ID, with s as its first revision.
+// It is meant for fixtures.
+func (db *DB) SeedSnippet(s models.Snippet) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s.IDStr = s.ID.Hex()
+	db.snippets[s.ID] = copySnippet(s)
+	revision := models.NewRevision(s, 1, 0)
+	revision.Created = s.Created
+	db.revisions[s.ID] = []models.Revision{revision}
+}
+
+// SeedUser stores u as is, keeping its ID. It is meant for fixtures.
+func (db *DB) SeedUser(u models.User) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u.IDStr = u.ID.Hex()
+	db.users[u.ID] = copyUser(u)
+}
+
+func copyAuthor(author map[string]string) map[string]string {
+	out := make(map[string]string, len(author))
+	for k, v := range author {
+		out[k] = v
+	}
+	return out
+}
+
+func copySnippet(s models.Snippet) models.Snippet {
+	s.Author = copyAuthor(s.Author)
+	s.Tags = slices.Clone(s.Tags)
+	return s
+}
+
+func copyRevision(r models.Revision) models.Revision {
+	r.Author = copyAuthor(r.Author)
+	r.Tags = slices.Clone(r.Tags)
+	return r
+}
+
+func copyCommentary(c models.Commentary) models.Commentary {
+	c.Author = copyAuthor(c.Author)
+	return c
+}
+
+func copySnippets(snippets []models.Snippet) []models.Snippet {
+	if snippets == nil {
+		return nil
+	}
+	out := make([]models.Snippet, len(snippets))
+	for i, s := range snippets {
+		out[i] = copySnippet(s)
+	}
+	return out
+}
+
+func copyUser(u models.User) models.User {
+	u.Favourites = copySnippets(u.Favourites)
+	u.CreatedSnippets = copySnippets(u.CreatedSnippets)
+	u.RecoveryCodes = slices.Clone(u.RecoveryCodes)
+	return u
+}
+
+// removeFavourite drops the snippet from every user's favourites. The
+// caller must hold the write lock.
+func (db *DB) removeFavourite(snippetID primitive.ObjectID) {
+	for userID, user := range db.users {
+		favourites := make([]models.Snippet, 0, len(user.Favourites))
+		for _, f := range user.Favourites {
+			if f.ID != snippetID {
+				favourites = append(favourites, f)
+			}
+		}
+		user.Favourites = favourites
+		db.users[userID] = user
+	}
+}
@@ -0,0 +1,323 @@
+package memory
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"time"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SnippetModel struct {
+	DB *DB
+}
+
+func (m *SnippetModel) Insert(title, content string, tags []string, language, visibility, username, userIDStr string, expires int) (primitive.ObjectID, error) {
+	return m.insert(models.Snippet{
+		Author:     map[string]string{username: userIDStr},
+		Title:      title,
+		Content:    content,
+		Tags:       slices.Clone(tags),
+		Language:   language,
+		Visibility: visibility,
+		Expires:    models.ExpiresAt(expires),
+	}), nil
+}
+
+// insert stores snippet under a new ID with its first revision.
+func (m *SnippetModel) insert(snippet models.Snippet) primitive.ObjectID {
+	id := primitive.NewObjectID()
+	snippet.ID = id
+	snippet.IDStr = id.Hex()
+	snippet.Created = time.Now().UTC()
+	if snippet.Visibility == "" {
+		snippet.Visibility = models.VisibilityPublic
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	m.DB.snippets[id] = snippet
+	m.DB.revisions[id] = []models.Revision{models.NewRevision(snippet, 1, 0)}
+	return id
+}
+
+func (m *SnippetModel) Fork(id primitive.ObjectID, username, userIDStr string) (primitive.ObjectID, error) {
+	parent, err := m.GetVisible(id, userIDStr)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return m.insert(models.Snippet{
+		Author:     map[string]string{username: userIDStr},
+		Title:      parent.Title,
+		Content:    parent.Content,
+		Tags:       parent.Tags,
+		Language:   parent.Language,
+		Visibility: parent.Visibility,
+		ForkedFrom: id,
+	}), nil
+}
+
+// Forks returns the public snippets forked from the snippet, and those of
+// the viewer, newest first.
+func (m *SnippetModel) Forks(id primitive.ObjectID, viewerIDStr string) ([]models.Snippet, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	forks := []models.Snippet{}
+	for _, s := range m.DB.snippets {
+		if s.ForkedFrom == id && !s.IsExpired() && (s.IsPublic() || s.AuthorID() == viewerIDStr) {
+			forks = append(forks, copySnippet(s))
+		}
+	}
+	sort.Slice(forks, func(i, j int) bool {
+		if !forks[i].Created.Equal(forks[j].Created) {
+			return forks[i].Created.After(forks[j].Created)
+		}
+		return forks[i].ID.Hex() > forks[j].ID.Hex()
+	})
+	return forks, nil
+}
+
+func (m *SnippetModel) Get(id primitive.ObjectID) (models.Snippet, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	snippet, ok := m.DB.snippets[id]
+	if !ok || snippet.IsExpired() {
+		return models.Snippet{}, models.ErrNoRecord
+	}
+	return copySnippet(snippet), nil
+}
+
+func (m *SnippetModel) GetVisible(id primitive.ObjectID, viewerIDStr string) (models.Snippet, error) {
+	snippet, err := m.Get(id)
+	if err != nil {
+		return models.Snippet{}, err
+	}
+	if !snippet.VisibleTo(viewerIDStr) {
+		return models.Snippet{}, models.ErrNoRecord
+	}
+	return snippet, nil
+}
+
+func (m *SnippetModel) Latest(q models.PageQuery) (models.Page, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	snippets := make([]models.Snippet, 0, len(m.DB.snippets))
+	for _, s := range m.DB.snippets {
+		if !s.IsExpired() && s.IsPublic() {
+			snippets = append(snippets, s)
+		}
+	}
+	return pageSnippets(snippets, q)
+}
+
+func (m *SnippetModel) ByTag(tag string, q models.PageQuery) (models.Page, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	var snippets []models.Snippet
+	for _, s := range m.DB.snippets {
+		if !s.IsExpired() && s.IsPublic() && s.HasTag(tag) {
+			snippets = append(snippets, s)
+		}
+	}
+	return pageSnippets(snippets, q)
+}
+
+func (m *SnippetModel) TagCounts() ([]models.TagCount, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	counts := map[string]int{}
+	for _, s := range m.DB.snippets {
+		if s.IsExpired() || !s.IsPublic() {
+			continue
+		}
+		for _, tag := range s.Tags {
+			counts[tag]++
+		}
+	}
+	var tags []models.TagCount
+	for name, count := range counts {
+		tags = append(tags, models.TagCount{Name: name, Count: count})
+	}
+	return models.TopTags(tags, models.TagCloudSize), nil
+}
+
+// pageSnippets mirrors the keyset pagination of the other backends over
+// candidates, which may be in any order. The snippets on the returned page
+// are copies.
+func pageSnippets(candidates []models.Snippet, q models.PageQuery) (models.Page, error) {
+	q = q.Normalize()
+	cursor, hasCursor, err := q.Cursor()
+	if err != nil {
+		return models.Page{}, err
+	}
+	// ahead reports whether the key (va, a) comes before (vb, b) on a page,
+	// where the highest sort value and then the highest ID come first.
+	ahead := func(va int64, a primitive.ObjectID, vb int64, b primitive.ObjectID) bool {
+		if va != vb {
+			return va > vb
+		}
+		return bytes.Compare(a[:], b[:]) > 0
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return ahead(q.SortValue(candidates[i]), candidates[i].ID, q.SortValue(candidates[j]), candidates[j].ID)
+	})
+	if q.Backward() {
+		slices.Reverse(candidates)
+	}
+
+	var rows []models.Snippet
+	for _, s := range candidates {
+		if len(rows) > q.Limit {
+			break
+		}
+		if hasCursor {
+			v := q.SortValue(s)
+			if q.Backward() && !ahead(v, s.ID, cursor.Value, cursor.ID) {
+				continue
+			}
+			if !q.Backward() && !ahead(cursor.Value, cursor.ID, v, s.ID) {
+				continue
+			}
+		}
+		rows = append(rows, copySnippet(s))
+	}
+	return models.NewPage(q, rows), nil
+}
+
+func (m *SnippetModel) Update(id primitive.ObjectID, userIDStr, title, content string, tags []string, language string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	return m.DB.updateSnippet(id, userIDStr, title, content, tags, language, 0)
+}
+
+// updateSnippet changes a snippet and records the change as a revision,
+// restored from the given one if not 0. The caller must hold the lock.
+func (db *DB) updateSnippet(id primitive.ObjectID, userIDStr, title, content string, tags []string, language string, restoredFrom int) error {
+	snippet, ok := db.snippets[id]
+	if !ok || snippet.IsExpired() {
+		return models.ErrNoRecord
+	}
+	if snippet.AuthorID() != userIDStr {
+		return models.ErrNotAuthor
+	}
+	snippet.Title = title
+	snippet.Content = content
+	snippet.Tags = slices.Clone(tags)
+	snippet.Language = language
+	db.snippets[id] = snippet
+	history := db.revisions[id]
+	db.revisions[id] = append(history, models.NewRevision(snippet, len(history)+1, restoredFrom))
+
+	for userID, user := range db.users {
+		for i, f := range user.Favourites {
+			if f.ID == id {
+				user.Favourites[i].Title = title
+				user.Favourites[i].Content = content
+				user.Favourites[i].Tags = slices.Clone(tags)
+				user.Favourites[i].Language = language
+				db.users[userID] = user
+			}
+		}
+	}
+	return nil
+}
+
+func (m *SnippetModel) Delete(id primitive.ObjectID, userIDStr string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	snippet, ok := m.DB.snippets[id]
+	if !ok || snippet.IsExpired() {
+		return models.ErrNoRecord
+	}
+	if snippet.AuthorID() != userIDStr {
+		return models.ErrNotAuthor
+	}
+	delete(m.DB.snippets, id)
+	delete(m.DB.revisions, id)
+	delete(m.DB.commentaries, id)
+	m.DB.removeFavourite(id)
+	return nil
+}
+
+func (m *SnippetModel) DeleteExpired() (int64, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	var n int64
+	for id, s := range m.DB.snippets {
+		if s.IsExpired() {
+			delete(m.DB.snippets, id)
+			delete(m.DB.revisions, id)
+			delete(m.DB.commentaries, id)
+			m.DB.removeFavourite(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *SnippetModel) Search(q models.SearchQuery) ([]models.SearchResult, error) {
+	q = q.Normalize()
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	var results []models.SearchResult
+	for _, s := range m.DB.snippets {
+		if s.IsExpired() || !s.IsPublic() || !q.Filter(s) {
+			continue
+		}
+		score := models.Score(s, m.DB.commentaries[s.ID], q.Terms)
+		if len(q.Terms) > 0 && score == 0 {
+			continue
+		}
+		results = append(results, models.SearchResult{Snippet: copySnippet(s), Score: score})
+	}
+	return models.Rank(results, q.Limit), nil
+}
+
+func (m *SnippetModel) Revisions(id primitive.ObjectID) ([]models.Revision, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	snippet, ok := m.DB.snippets[id]
+	if !ok || snippet.IsExpired() {
+		return nil, models.ErrNoRecord
+	}
+	history := m.DB.revisions[id]
+	revisions := make([]models.Revision, len(history))
+	for i, r := range history {
+		revisions[len(history)-1-i] = copyRevision(r)
+	}
+	return revisions, nil
+}
+
+func (m *SnippetModel) Revision(id primitive.ObjectID, number int) (models.Revision, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	return m.DB.revision(id, number)
+}
+
+// revision looks up a revision of a live snippet. The caller must hold the
+// lock.
+func (db *DB) revision(id primitive.ObjectID, number int) (models.Revision, error) {
+	snippet, ok := db.snippets[id]
+	if !ok || snippet.IsExpired() {
+		return models.Revision{}, models.ErrNoRecord
+	}
+	for _, r := range db.revisions[id] {
+		if r.Number == number {
+			return copyRevision(r), nil
+		}
+	}
+	return models.Revision{}, models.ErrNoRecord
+}
+
+func (m *SnippetModel) Restore(id primitive.ObjectID, userIDStr string, number int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	r, err := m.DB.revision(id, number)
+	if err != nil {
+		return err
+	}
+	return m.DB.updateSnippet(id, userIDStr, r.Title, r.Content, r.Tags, r.Language, number)
+}
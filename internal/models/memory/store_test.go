@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"snippetbox/internal/models/storetest"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Stores {
+		db := New()
+		return storetest.Stores{
+			Snippets:   &SnippetModel{DB: db},
+			Users:      &UserModel{DB: db},
+			Commentary: &CommentaryModel{DB: db},
+			Expire:     db.expire,
+			Count:      db.count,
+		}
+	})
+}
+
+// expire moves the expiry time of the snippet with the given ID, and of
+// the copies of it in favourites, into the past.
+func (db *DB) expire(id primitive.ObjectID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	past := time.Now().Add(-time.Minute)
+	snippet := db.snippets[id]
+	snippet.Expires = past
+	db.snippets[id] = snippet
+	for _, u := range db.users {
+		for i, f := range u.Favourites {
+			if f.ID == id {
+				u.Favourites[i].Expires = past
+			}
+		}
+	}
+	return nil
+}
+
+func (db *DB) count(what string) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	n := 0
+	switch what {
+	case "snippets":
+		n = len(db.snippets)
+	case "revisions":
+		for _, revisions := range db.revisions {
+			n += len(revisions)
+		}
+	case "commentaries":
+		for _, commentaries := range db.commentaries {
+			n += len(commentaries)
+		}
+	case "favourites":
+		for _, u := range db.users {
+			n += len(u.Favourites)
+		}
+	default:
+		return 0, fmt.Errorf("memory: can't count %q", what)
+	}
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *UserModel) AddToken(token models.APIToken) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	m.DB.tokens[token.ID] = token
+	return nil
+}
+
+func (m *UserModel) Tokens(userID primitive.ObjectID) ([]models.APIToken, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	tokens := []models.APIToken{}
+	for _, t := range m.DB.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return bytes.Compare(tokens[i].ID[:], tokens[j].ID[:]) > 0
+	})
+	return tokens, nil
+}
+
+func (m *UserModel) RevokeToken(userID, tokenID primitive.ObjectID) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	t, ok := m.DB.tokens[tokenID]
+	if !ok || t.UserID != userID {
+		return models.ErrNoRecord
+	}
+	delete(m.DB.tokens, tokenID)
+	return nil
+}
+
+func (m *UserModel) AuthenticateToken(plaintext string) (models.APIToken, string, error) {
+	hash := models.HashToken(plaintext)
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for id, t := range m.DB.tokens {
+		if t.Hash != hash {
+			continue
+		}
+		user, ok := m.DB.users[t.UserID]
+		if !ok {
+			break
+		}
+		t.LastUsed = time.Now().UTC()
+		m.DB.tokens[id] = t
+		return t, user.Name, nil
+	}
+	return models.APIToken{}, "", models.ErrInvalidCredentials
+}
@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"slices"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *UserModel) EnableTOTP(id primitive.ObjectID, secret string, recoveryCodes []string) error {
+	return m.update(id, func(u *models.User) error {
+		u.TOTPSecret = secret
+		u.TOTPStep = 0
+		u.RecoveryCodes = slices.Clone(recoveryCodes)
+		return nil
+	})
+}
+
+func (m *UserModel) DisableTOTP(id primitive.ObjectID) error {
+	return m.update(id, func(u *models.User) error {
+		u.TOTPSecret = ""
+		u.TOTPStep = 0
+		u.RecoveryCodes = nil
+		return nil
+	})
+}
+
+func (m *UserModel) UseTOTPStep(id primitive.ObjectID, step int64) error {
+	return m.update(id, func(u *models.User) error {
+		if step <= u.TOTPStep {
+			return models.ErrCodeUsed
+		}
+		u.TOTPStep = step
+		return nil
+	})
+}
+
+func (m *UserModel) UseRecoveryCode(id primitive.ObjectID, hash string) error {
+	return m.update(id, func(u *models.User) error {
+		i := slices.Index(u.RecoveryCodes, hash)
+		if i < 0 {
+			return models.ErrNoRecord
+		}
+		u.RecoveryCodes = slices.Delete(slices.Clone(u.RecoveryCodes), i, i+1)
+		return nil
+	})
+}
+
+func (m *UserModel) SetRecoveryCodes(id primitive.ObjectID, recoveryCodes []string) error {
+	return m.update(id, func(u *models.User) error {
+		u.RecoveryCodes = slices.Clone(recoveryCodes)
+		return nil
+	})
+}
+
+// update applies fn to the user under the write lock, storing the result
+// unless fn fails.
+func (m *UserModel) update(id primitive.ObjectID, fn func(u *models.User) error) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	user, ok := m.DB.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	err := fn(&user)
+	if err != nil {
+		return err
+	}
+	m.DB.users[id] = user
+	return nil
+}
@@ -0,0 +1,218 @@
+package memory
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UserModel struct {
+	DB *DB
+}
+
+func (m *UserModel) Insert(name, email, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for _, u := range m.DB.users {
+		if u.Email == email {
+			return models.ErrDuplicateEmail
+		}
+	}
+	id := primitive.NewObjectID()
+	m.DB.users[id] = models.User{
+		IDStr:           id.Hex(),
+		ID:              id,
+		Name:            name,
+		Email:           email,
+		HashedPassword:  string(hashedPassword),
+		Created:         time.Now().UTC(),
+		Favourites:      []models.Snippet{},
+		CreatedSnippets: []models.Snippet{},
+	}
+	return nil
+}
+
+func (m *UserModel) Authenticate(email, password string) (primitive.ObjectID, string, error) {
+	m.DB.mu.RLock()
+	var user models.User
+	var found bool
+	for _, u := range m.DB.users {
+		if u.Email == email {
+			user, found = u, true
+			break
+		}
+	}
+	m.DB.mu.RUnlock()
+	if !found {
+		models.CompareDummyPassword(password)
+		return primitive.NilObjectID, "", models.ErrInvalidCredentials
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return primitive.NilObjectID, "", models.ErrInvalidCredentials
+		}
+		return primitive.NilObjectID, "", err
+	}
+	return user.ID, user.Name, nil
+}
+
+func (m *UserModel) Exists(id primitive.ObjectID) (bool, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	_, ok := m.DB.users[id]
+	return ok, nil
+}
+
+func (m *UserModel) GetByID(id primitive.ObjectID) (models.User, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	user, ok := m.DB.users[id]
+	if !ok {
+		return models.User{}, models.ErrNoRecord
+	}
+	user.Favourites = nil
+	user.CreatedSnippets = nil
+	user.RecoveryCodes = slices.Clone(user.RecoveryCodes)
+	return user, nil
+}
+
+func (m *UserModel) GetByEmail(email string) (models.User, error) {
+	m.DB.mu.RLock()
+	var id primitive.ObjectID
+	var found bool
+	for _, u := range m.DB.users {
+		if u.Email == email {
+			id, found = u.ID, true
+			break
+		}
+	}
+	m.DB.mu.RUnlock()
+	if !found {
+		return models.User{}, models.ErrNoRecord
+	}
+	return m.GetByID(id)
+}
+
+func (m *UserModel) SetEmailVerified(id primitive.ObjectID, email string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	user, ok := m.DB.users[id]
+	if !ok || user.Email != email {
+		return models.ErrNoRecord
+	}
+	user.EmailVerified = true
+	m.DB.users[id] = user
+	return nil
+}
+
+func (m *UserModel) SetPassword(id primitive.ObjectID, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	user, ok := m.DB.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	user.HashedPassword = string(hashedPassword)
+	m.DB.users[id] = user
+	return nil
+}
+
+func (m *UserModel) Get(id primitive.ObjectID, viewerIDStr string, created models.PageQuery) (models.User, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+	user, ok := m.DB.users[id]
+	if !ok {
+		return models.User{}, models.ErrNoRecord
+	}
+	user = copyUser(user)
+
+	own := viewerIDStr == user.IDStr
+	favourites := user.Favourites[:0]
+	for _, f := range user.Favourites {
+		if !f.IsExpired() && (f.IsPublic() || own && f.VisibleTo(viewerIDStr)) {
+			favourites = append(favourites, f)
+		}
+	}
+	user.Favourites = favourites
+
+	var snippets []models.Snippet
+	for _, s := range m.DB.snippets {
+		if s.Author[user.Name] == user.IDStr && !s.IsExpired() && (own || s.IsPublic()) {
+			snippets = append(snippets, s)
+		}
+	}
+	page, err := pageSnippets(snippets, created)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.CreatedSnippets = page.Snippets
+	user.CreatedNext = page.Next
+	user.CreatedPrev = page.Prev
+	return user, nil
+}
+
+func (m *UserModel) AddFavourites(SnippetID primitive.ObjectID, ID primitive.ObjectID) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	user, ok := m.DB.users[ID]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	for _, f := range user.Favourites {
+		if f.ID == SnippetID {
+			return models.ErrAlreadyFavourite
+		}
+	}
+	snippet, ok := m.DB.snippets[SnippetID]
+	if !ok || snippet.IsExpired() || !snippet.VisibleTo(ID.Hex()) {
+		return models.ErrNoRecord
+	}
+	snippet.Favourited++
+	m.DB.snippets[SnippetID] = snippet
+
+	user.Favourites = append(user.Favourites, copySnippet(snippet))
+	m.DB.users[ID] = user
+	return nil
+}
+
+func (m *UserModel) RemoveFavourites(Snippet models.Snippet, SnippetID primitive.ObjectID, ID primitive.ObjectID) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	user, ok := m.DB.users[ID]
+	if !ok {
+		return nil
+	}
+	favourites := user.Favourites[:0]
+	for _, f := range user.Favourites {
+		if f.ID != SnippetID {
+			favourites = append(favourites, f)
+		}
+	}
+	// Only a favourite that was there counts, as in the other stores.
+	if len(favourites) == len(user.Favourites) {
+		return nil
+	}
+	user.Favourites = favourites
+	m.DB.users[ID] = user
+	if snippet, ok := m.DB.snippets[SnippetID]; ok {
+		snippet.Favourited--
+		m.DB.snippets[SnippetID] = snippet
+	}
+	return nil
+}
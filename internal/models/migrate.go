@@ -0,0 +1,268 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations are the data migrations of the Mongo models. They must only
+// ever be appended to; a released migration is never edited, since
+// databases in the wild have already applied it.
+var migrations = []struct {
+	version int
+	apply   func(db *mongo.Database) error
+}{
+	{version: 1, apply: splitTags},
+	{version: 2, apply: seedRevisions},
+	{version: 3, apply: identifyCommentaries},
+	{version: 4, apply: moveCommentaries},
+	{version: 5, apply: verifyExistingUsers},
+}
+
+// Migrate brings the Mongo database up to date: it applies the migrations
+// not recorded in the migrations collection yet, then creates the indexes.
+// It is safe to call on every start.
+func Migrate(db *mongo.Database) error {
+	collection := db.Collection("migrations")
+	for _, m := range migrations {
+		count, err := collection.CountDocuments(context.TODO(), bson.M{"_id": m.version})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		err = m.apply(db)
+		if err != nil {
+			return fmt.Errorf("models: migration %d: %w", m.version, err)
+		}
+		_, err = collection.InsertOne(context.TODO(), bson.M{"_id": m.version})
+		if err != nil {
+			return err
+		}
+	}
+	return ensureIndexes(db)
+}
+
+// splitTags replaces the single free-text tag of snippets, and of the copies
+// in users' favourites, with a normalized tags list. The text index covered
+// the old field, so it is dropped to be recreated by ensureIndexes.
+func splitTags(db *mongo.Database) error {
+	_, err := db.Collection("snippets").Indexes().DropOne(context.TODO(), "search")
+	var cmdErr mongo.CommandError
+	// 26 and 27 mean there was no collection or no index to drop.
+	if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27)) {
+		return err
+	}
+
+	snippets := db.Collection("snippets")
+	cur, err := snippets.Find(context.TODO(), bson.M{"tag": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var doc bson.M
+		err := cur.Decode(&doc)
+		if err != nil {
+			return err
+		}
+		tag, _ := doc["tag"].(string)
+		_, err = snippets.UpdateOne(context.TODO(), bson.M{"_id": doc["_id"]}, bson.M{
+			"$set":   bson.M{"tags": NormalizeTags(SplitTags(tag))},
+			"$unset": bson.M{"tag": ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	users := db.Collection("users")
+	cur, err = users.Find(context.TODO(), bson.M{"favourites.tag": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var doc struct {
+			ID         any      `bson:"_id"`
+			Favourites []bson.M `bson:"favourites"`
+		}
+		err := cur.Decode(&doc)
+		if err != nil {
+			return err
+		}
+		for _, f := range doc.Favourites {
+			if tag, ok := f["tag"].(string); ok {
+				f["tags"] = NormalizeTags(SplitTags(tag))
+				delete(f, "tag")
+			}
+		}
+		_, err = users.UpdateOne(context.TODO(), bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"favourites": doc.Favourites}})
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// seedRevisions records the current state of every snippet as its first
+// revision, for snippets created before revisions were kept.
+func seedRevisions(db *mongo.Database) error {
+	cur, err := db.Collection("snippets").Find(context.TODO(), bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+	revisions := db.Collection("revisions")
+	for cur.Next(context.TODO()) {
+		var snippet Snippet
+		err := cur.Decode(&snippet)
+		if err != nil {
+			return err
+		}
+		count, err := revisions.CountDocuments(context.TODO(), bson.M{"snippet_id": snippet.ID})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		revision := NewRevision(snippet, 1, 0)
+		revision.Created = snippet.Created
+		_, err = revisions.InsertOne(context.TODO(), revision)
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// identifyCommentaries gives an ID to the comments written before comments
+// had one, so they can be replied to, edited and deleted.
+func identifyCommentaries(db *mongo.Database) error {
+	snippets := db.Collection("snippets")
+	filter := bson.M{"commentaries": bson.M{"$elemMatch": bson.M{"_id": bson.M{"$exists": false}}}}
+	cur, err := snippets.Find(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var snippet Snippet
+		err := cur.Decode(&snippet)
+		if err != nil {
+			return err
+		}
+		for i, c := range snippet.Commentaries {
+			if c.ID.IsZero() {
+				snippet.Commentaries[i].ID = primitive.NewObjectID()
+			}
+		}
+		_, err = snippets.UpdateOne(context.TODO(), bson.M{"_id": snippet.ID}, bson.M{"$set": bson.M{"commentaries": snippet.Commentaries}})
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// dropSearchIndex drops the text index of snippets, for ensureIndexes to
+// recreate over the current fields.
+func dropSearchIndex(db *mongo.Database) error {
+	_, err := db.Collection("snippets").Indexes().DropOne(context.TODO(), "search")
+	var cmdErr mongo.CommandError
+	// 26 and 27 mean there was no collection or no index to drop.
+	if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27)) {
+		return err
+	}
+	return nil
+}
+
+// embeddedCommentaries is a snippet, or a favourite copy of one, as stored
+// while its commentaries were embedded in it.
+type embeddedCommentaries struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Commentaries []Commentary       `bson:"commentaries"`
+	Expires      time.Time          `bson:"expires,omitempty"`
+}
+
+// moveCommentaries moves the commentaries embedded in snippets into their
+// own collection, and drops the copies embedded in users' favourites. The
+// text index covered the embedded commentaries, so it is dropped to be
+// recreated by ensureIndexes. Snippets written before the commented counter
+// existed get it set from their commentaries.
+func moveCommentaries(db *mongo.Database) error {
+	err := dropSearchIndex(db)
+	if err != nil {
+		return err
+	}
+
+	snippets := db.Collection("snippets")
+	commentaries := db.Collection("commentaries")
+	cur, err := snippets.Find(context.TODO(), bson.M{"commentaries": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var snippet embeddedCommentaries
+		err := cur.Decode(&snippet)
+		if err != nil {
+			return err
+		}
+		SetThreads(snippet.Commentaries)
+		docs := make([]any, len(snippet.Commentaries))
+		for i, c := range snippet.Commentaries {
+			c.SnippetID = snippet.ID
+			c.Expires = snippet.Expires
+			docs[i] = c
+		}
+		if len(docs) > 0 {
+			// Ordered inserts stop at the first duplicate, which is
+			// where an interrupted run left off, so go on past them.
+			_, err = commentaries.InsertMany(context.TODO(), docs, options.InsertMany().SetOrdered(false))
+			if err != nil && !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+		}
+		_, err = snippets.UpdateOne(context.TODO(), bson.M{"_id": snippet.ID}, bson.M{
+			"$set":   bson.M{"commented": len(docs)},
+			"$unset": bson.M{"commentaries": ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	_, err = snippets.UpdateMany(context.TODO(), bson.M{"commented": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"commented": 0}})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("users").UpdateMany(context.TODO(),
+		bson.M{"favourites.commentaries": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"favourites.$[].commentaries": ""}})
+	return err
+}
+
+// verifyExistingUsers marks the addresses of users who signed up before
+// emails were verified as verified, so that they can still log in.
+func verifyExistingUsers(db *mongo.Database) error {
+	_, err := db.Collection("users").UpdateMany(context.TODO(),
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified": true}})
+	return err
+}
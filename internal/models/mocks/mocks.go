@@ -0,0 +1,22 @@
+// Package mocks provides an in-memory model layer pre-loaded with a known set
+// of fixtures, for use by handler tests.
+package mocks
+
+import "snippetbox/internal/models/memory"
+
+// NewDB returns a fresh in-memory database seeded with MockSnippet, MockUser
+// (whose password is MockUserPassword) and a user registered with DupeEmail.
+func NewDB() *memory.DB {
+	db := memory.New()
+
+	user := MockUser
+	user.HashedPassword = hashPassword(MockUserPassword)
+	db.SeedUser(user)
+
+	dupe := dupeUser
+	dupe.HashedPassword = hashPassword(MockUserPassword)
+	db.SeedUser(dupe)
+
+	db.SeedSnippet(MockSnippet)
+	return db
+}
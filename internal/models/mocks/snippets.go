@@ -1,26 +1,27 @@
 package mocks
 
-// var mockSnippet = models.Snippet{
-// 	ID:      "1",
-// 	Title:   "An old silent pond",
-// 	Content: "An old silent pond...",
-// 	Created: time.Now(),
-// 	Expires: time.Now(),
-// }
+import (
+	"time"
 
-// type SnippetModel struct{}
+	"snippetbox/internal/models"
 
-// func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-// 	return 2, nil
-// }
-// func (m *SnippetModel) Get(id int) (models.Snippet, error) {
-// 	switch id {
-// 	case 1:
-// 		return mockSnippet, nil
-// 	default:
-// 		return models.Snippet{}, models.ErrNoRecord
-// 	}
-// }
-// func (m *SnippetModel) Latest() ([]models.Snippet, error) {
-// 	return []models.Snippet{mockSnippet}, nil
-// }
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var MockSnippet = models.Snippet{
+	Author:   map[string]string{MockUser.Name: MockUser.ID.Hex()},
+	ID:       mustObjectID("65e0a1b2c3d4e5f601234567"),
+	Title:    "An old silent pond",
+	Content:  "An old silent pond...",
+	Created:  time.Now(),
+	Tags:     []string{"haiku"},
+	Language: "plaintext",
+}
+
+func mustObjectID(hex string) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
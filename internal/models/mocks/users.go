@@ -1,38 +1,38 @@
 package mocks
 
-// type UserModel struct{}
+import (
+	"time"
 
-// func (m *UserModel) Insert(name, email, password string) error {
-// 	switch email {
-// 	case "dupe@example.com":
-// 		return models.ErrDuplicateEmail
-// 	default:
-// 		return nil
-// 	}
-// }
-// func (m *UserModel) Authenticate(email, password string) (int, error) {
-// 	if email == "alice@example.com" && password == "pa$$word" {
-// 		return 1, nil
-// 	}
-// 	return 0, models.ErrInvalidCredentials
-// }
-// func (m *UserModel) Exists(id int) (bool, error) {
-// 	switch id {
-// 	case 1:
-// 		return true, nil
-// 	default:
-// 		return false, nil
-// 	}
-// }
-// func (m *UserModel) Get(id int) (models.User, error) {
-// 	if id == 1 {
-// 		u := models.User{
-// 			ID:      "1",
-// 			Name:    "Alice",
-// 			Email:   "alice@example.com",
-// 			Created: time.Now(),
-// 		}
-// 		return u, nil
-// 	}
-// 	return models.User{}, models.ErrNoRecord
-// }
+	"snippetbox/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MockUserPassword = "pa$$word"
+	DupeEmail        = "dupe@example.com"
+)
+
+var MockUser = models.User{
+	ID:            mustObjectID("65e0a1b2c3d4e5f601234500"),
+	Name:          "Alice",
+	Email:         "alice@example.com",
+	EmailVerified: true,
+	Created:       time.Now(),
+}
+
+var dupeUser = models.User{
+	ID:            mustObjectID("65e0a1b2c3d4e5f601234501"),
+	Name:          "Dupe",
+	Email:         DupeEmail,
+	EmailVerified: true,
+	Created:       time.Now(),
+}
+
+func hashPassword(password string) string {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hashedPassword)
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SortNewest     = "newest"
+	SortFavourited = "favourited"
+	SortCommented  = "commented"
+
+	DefaultPageSize = 10
+	MaxPageSize     = 50
+)
+
+var SortOptions = []string{SortNewest, SortFavourited, SortCommented}
+
+// PageQuery selects one page of a snippet listing. Listings are paginated
+// by keyset rather than offset: After and Before are cursors taken from a
+// previous Page, and at most one of them should be set.
+type PageQuery struct {
+	Sort   string
+	Limit  int
+	After  string
+	Before string
+}
+
+// Normalize fills in the default sort and clamps Limit to MaxPageSize.
+func (q PageQuery) Normalize() PageQuery {
+	if !slices.Contains(SortOptions, q.Sort) {
+		q.Sort = SortNewest
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+	return q
+}
+
+// Backward reports whether the page is read backwards from the Before
+// cursor, in which case stores fetch in ascending order.
+func (q PageQuery) Backward() bool {
+	return q.Before != ""
+}
+
+// Cursor decodes whichever of After or Before is set. ok is false if
+// neither is.
+func (q PageQuery) Cursor() (c Cursor, ok bool, err error) {
+	raw := q.After
+	if q.Backward() {
+		raw = q.Before
+	}
+	if raw == "" {
+		return Cursor{}, false, nil
+	}
+	c, err = DecodeCursor(raw)
+	return c, err == nil, err
+}
+
+// SortValue returns the key s is ordered by under q.Sort. For the newest
+// sort it is the creation time in nanoseconds.
+func (q PageQuery) SortValue(s Snippet) int64 {
+	switch q.Sort {
+	case SortFavourited:
+		return int64(s.Favourited)
+	case SortCommented:
+		return int64(s.Commented)
+	default:
+		return s.Created.UnixNano()
+	}
+}
+
+// CursorTime converts the value of a newest-sort cursor back into the
+// creation time it was taken from.
+func CursorTime(value int64) time.Time {
+	return time.Unix(0, value).UTC()
+}
+
+// Cursor is a position in a listing: the sort key of a snippet plus its ID
+// to break ties.
+type Cursor struct {
+	Value int64
+	ID    primitive.ObjectID
+}
+
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d_%s", c.Value, c.ID.Hex())
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	value, hex, found := strings.Cut(s, "_")
+	if !found {
+		return Cursor{}, ErrInvalidCursor
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Value: v, ID: id}, nil
+}
+
+// Page is one page of a snippet listing. Next and Prev are empty when there
+// is nothing further in that direction.
+type Page struct {
+	Snippets []Snippet
+	Next     string
+	Prev     string
+}
+
+// NewPage builds a Page from rows fetched by a store in query order (that
+// is, ascending when q.Backward()) with a limit of q.Limit+1; the extra row
+// only signals that another page exists.
+func NewPage(q PageQuery, rows []Snippet) Page {
+	more := len(rows) > q.Limit
+	if more {
+		rows = rows[:q.Limit]
+	}
+	if q.Backward() {
+		slices.Reverse(rows)
+	}
+	page := Page{Snippets: rows}
+	if len(rows) == 0 {
+		return page
+	}
+	first := Cursor{Value: q.SortValue(rows[0]), ID: rows[0].ID}.String()
+	last := Cursor{Value: q.SortValue(rows[len(rows)-1]), ID: rows[len(rows)-1].ID}.String()
+	if q.Backward() {
+		page.Next = last
+		if more {
+			page.Prev = first
+		}
+	} else {
+		if more {
+			page.Next = last
+		}
+		if q.After != "" {
+			page.Prev = first
+		}
+	}
+	return page
+}
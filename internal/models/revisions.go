@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Revision is a saved state of a snippet. One is recorded when the snippet is
+// created and another on every change after that, numbered from 1; revisions
+// are never changed afterwards. Author is who made the change.
+type Revision struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	SnippetID primitive.ObjectID `bson:"snippet_id"`
+	Number    int                `bson:"number"`
+	Author    map[string]string  `bson:"Author"`
+	Title     string             `bson:"title"`
+	Content   string             `bson:"content"`
+	Tags      []string           `bson:"tags"`
+	Language  string             `bson:"language,omitempty"`
+	// RestoredFrom is the number of the revision this one brought back, or
+	// 0 for ordinary edits.
+	RestoredFrom int       `bson:"restored_from,omitempty"`
+	Created      time.Time `bson:"created"`
+	// Expires mirrors the snippet's, so the TTL index removes the history
+	// along with the snippet.
+	Expires time.Time `bson:"expires,omitempty"`
+}
+
+// NewRevision records the current state of s as revision number.
+func NewRevision(s Snippet, number, restoredFrom int) Revision {
+	author := make(map[string]string, len(s.Author))
+	for k, v := range s.Author {
+		author[k] = v
+	}
+	tags := make([]string, len(s.Tags))
+	copy(tags, s.Tags)
+	return Revision{
+		ID:           primitive.NewObjectID(),
+		SnippetID:    s.ID,
+		Number:       number,
+		Author:       author,
+		Title:        s.Title,
+		Content:      s.Content,
+		Tags:         tags,
+		Language:     s.Language,
+		RestoredFrom: restoredFrom,
+		Created:      time.Now().UTC(),
+		Expires:      s.Expires,
+	}
+}
+
+// AuthorName returns the name of the user who made the revision.
+func (r Revision) AuthorName() string {
+	for name := range r.Author {
+		return name
+	}
+	return ""
+}
+
+// AuthorID returns the hex ID of the user who made the revision.
+func (r Revision) AuthorID() string {
+	for _, id := range r.Author {
+		return id
+	}
+	return ""
+}
+
+// Revisions returns the history of a snippet, newest first.
+func (m *SnippetModel) Revisions(id primitive.ObjectID) ([]Revision, error) {
+	_, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: -1}})
+	cur, err := m.DB.Collection("revisions").Find(context.TODO(), bson.M{"snippet_id": id}, opts)
+	if err != nil {
+		return nil, err
+	}
+	revisions := []Revision{}
+	err = cur.All(context.TODO(), &revisions)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (m *SnippetModel) Revision(id primitive.ObjectID, number int) (Revision, error) {
+	_, err := m.Get(id)
+	if err != nil {
+		return Revision{}, err
+	}
+	var revision Revision
+	err = m.DB.Collection("revisions").FindOne(context.TODO(), bson.M{"snippet_id": id, "number": number}).Decode(&revision)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Revision{}, ErrNoRecord
+		}
+		return Revision{}, err
+	}
+	return revision, nil
+}
+
+// Restore makes the snippet look like revision number again, recording that
+// as a new revision.
+func (m *SnippetModel) Restore(id primitive.ObjectID, userIDStr string, number int) error {
+	revision, err := m.Revision(id, number)
+	if err != nil {
+		return err
+	}
+	return m.update(id, userIDStr, revision.Title, revision.Content, revision.Tags, revision.Language, number)
+}
+
+// addRevision records the state of s as the revision following the latest.
+func (m *SnippetModel) addRevision(s Snippet, restoredFrom int) error {
+	collection := m.DB.Collection("revisions")
+	var latest Revision
+	opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}})
+	err := collection.FindOne(context.TODO(), bson.M{"snippet_id": s.ID}, opts).Decode(&latest)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	_, err = collection.InsertOne(context.TODO(), NewRevision(s, latest.Number+1, restoredFrom))
+	return err
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 50
+)
+
+// Weights given to a term found in each part of a snippet when ranking
+// search results.
+const (
+	titleWeight      = 5
+	tagWeight        = 3
+	contentWeight    = 1
+	commentaryWeight = 1
+)
+
+// SearchQuery describes a search. A snippet matches if it contains any of
+// Terms (or Terms is empty) and passes every filter that is set. Author is
+// the author's name. From and To bound the creation time, To exclusive.
+type SearchQuery struct {
+	Terms  []string
+	Tag    string
+	Author string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// SearchResult is a matching snippet and its relevance, higher is better.
+type SearchResult struct {
+	Snippet Snippet
+	Score   float64
+}
+
+// ParseTerms splits a search box entry into lower-cased, distinct terms.
+func ParseTerms(s string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, term := range strings.Fields(strings.ToLower(s)) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// IsEmpty reports whether q has neither terms nor filters, in which case
+// there is nothing to search for.
+func (q SearchQuery) IsEmpty() bool {
+	return len(q.Terms) == 0 && q.Tag == "" && q.Author == "" && q.From.IsZero() && q.To.IsZero()
+}
+
+func (q SearchQuery) Normalize() SearchQuery {
+	if q.Limit <= 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit > MaxSearchLimit {
+		q.Limit = MaxSearchLimit
+	}
+	return q
+}
+
+// Filter reports whether s passes the tag, author and date filters of q.
+func (q SearchQuery) Filter(s Snippet) bool {
+	if q.Tag != "" && !s.HasTag(q.Tag) {
+		return false
+	}
+	if q.Author != "" && s.AuthorName() != q.Author {
+		return false
+	}
+	if !q.From.IsZero() && s.Created.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !s.Created.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// Score is the portable relevance of s, with commentaries on it, for terms,
+// used by backends without a text index of their own. It counts occurrences
+// of each term, weighted by where they were found.
+func Score(s Snippet, commentaries []Commentary, terms []string) float64 {
+	title := strings.ToLower(s.Title)
+	tags := strings.Join(s.Tags, " ")
+	content := strings.ToLower(s.Content)
+	var score float64
+	for _, term := range terms {
+		score += titleWeight * float64(strings.Count(title, term))
+		score += tagWeight * float64(strings.Count(tags, term))
+		score += contentWeight * float64(strings.Count(content, term))
+		for _, c := range commentaries {
+			score += commentaryWeight * float64(strings.Count(strings.ToLower(c.Content), term))
+		}
+	}
+	return score
+}
+
+// Rank orders results by score and then newest first, and keeps the first
+// limit of them.
+func Rank(results []SearchResult, limit int) []SearchResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Snippet.Created.After(results[j].Snippet.Created)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"context"
 	"errors"
+	"slices"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,30 +14,219 @@ import (
 )
 
 type Snippet struct {
-	Author       map[string]string  `bson:"Author"`
-	IDStr        string             `bson:"idstr"`
-	ID           primitive.ObjectID `bson:"_id,omitempty"`
-	Title        string             `bson:"title"`
-	Content      string             `bson:"content"`
-	Created      time.Time          `bson:"created"`
-	Tag          string             `bson:"tag"`
-	Favourited   int                `bson:"favourited"`
-	Commentaries []Commentary       `bson:"commentaries"`
+	Author     map[string]string  `bson:"Author"`
+	IDStr      string             `bson:"idstr"`
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Title      string             `bson:"title"`
+	Content    string             `bson:"content"`
+	Created    time.Time          `bson:"created"`
+	Tags       []string           `bson:"tags"`
+	Language   string             `bson:"language,omitempty"`
+	Visibility string             `bson:"visibility,omitempty"`
+	Favourited int                `bson:"favourited"`
+	Commented  int                `bson:"commented"`
+	// Commentaries were embedded in snippets until migration 4 moved them
+	// to a collection of their own. Only migration 3 still reads them.
+	Commentaries []Commentary `bson:"commentaries,omitempty"`
+	// Expires is the zero time for snippets that never expire. It is left
+	// out of the document in that case, which keeps the TTL index off it.
+	Expires time.Time `bson:"expires,omitempty"`
+	// ForkedFrom is the ID of the snippet this one was forked from, or the
+	// zero ID. The parent may since have been deleted.
+	ForkedFrom primitive.ObjectID `bson:"forked_from,omitempty"`
+}
+
+// Visibilities a snippet can have. Public snippets are listed on the site,
+// unlisted ones can only be reached through their link and private ones only
+// by their author. Snippets saved before visibility was recorded have none
+// and count as public.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+var Visibilities = []string{VisibilityPublic, VisibilityUnlisted, VisibilityPrivate}
+
+// IsPublic reports whether the snippet may be listed.
+func (s Snippet) IsPublic() bool {
+	return s.Visibility == "" || s.Visibility == VisibilityPublic
+}
+
+// VisibleTo reports whether the user with the hex ID userIDStr may see the
+// snippet. Anonymous visitors have an empty ID.
+func (s Snippet) VisibleTo(userIDStr string) bool {
+	return s.Visibility != VisibilityPrivate || (userIDStr != "" && s.AuthorID() == userIDStr)
+}
+
+// AuthorID returns the hex ID of the user who wrote the snippet.
+func (s Snippet) AuthorID() string {
+	for _, id := range s.Author {
+		return id
+	}
+	return ""
+}
+
+// HasTag reports whether the snippet carries tag.
+func (s Snippet) HasTag(tag string) bool {
+	return slices.Contains(s.Tags, tag)
+}
+
+// IsExpired reports whether the snippet has passed its expiry time.
+func (s Snippet) IsExpired() bool {
+	return !s.Expires.IsZero() && !s.Expires.After(time.Now())
+}
+
+// ExpiresAt turns the number of days chosen on the create form into an
+// expiry time, with 0 meaning never.
+func ExpiresAt(days int) time.Time {
+	if days == 0 {
+		return time.Time{}
+	}
+	return time.Now().UTC().AddDate(0, 0, days)
+}
+
+// AuthorName returns the display name of the user who wrote the snippet.
+func (s Snippet) AuthorName() string {
+	for name := range s.Author {
+		return name
+	}
+	return ""
+}
+
+// SnippetStore is implemented by every storage backend. The language of a
+// snippet is stored as given; picking or detecting it is up to the caller.
+// An empty visibility is stored as public. Insert, Fork, Update and Restore
+// each record a Revision, and Update, Restore and Delete return
+// ErrNotAuthor unless userIDStr is the snippet's author. Latest, ByTag,
+// TagCounts and Search only cover public snippets, and GetVisible, Fork
+// and Forks treat the private snippets of other users as missing; Get is
+// for the callers that check access themselves. Expired snippets are
+// treated as if they had already been deleted, and DeleteExpired purges
+// them for good.
+type SnippetStore interface {
+	Insert(title, content string, tags []string, language, visibility, username, userIDStr string, expires int) (primitive.ObjectID, error)
+	Get(id primitive.ObjectID) (Snippet, error)
+	GetVisible(id primitive.ObjectID, viewerIDStr string) (Snippet, error)
+	Latest(q PageQuery) (Page, error)
+	ByTag(tag string, q PageQuery) (Page, error)
+	TagCounts() ([]TagCount, error)
+	Update(id primitive.ObjectID, userIDStr, title, content string, tags []string, language string) error
+	Delete(id primitive.ObjectID, userIDStr string) error
+	DeleteExpired() (int64, error)
+	Search(q SearchQuery) ([]SearchResult, error)
+	Revisions(id primitive.ObjectID) ([]Revision, error)
+	Revision(id primitive.ObjectID, number int) (Revision, error)
+	Restore(id primitive.ObjectID, userIDStr string, number int) error
+	Fork(id primitive.ObjectID, username, userIDStr string) (primitive.ObjectID, error)
+	Forks(id primitive.ObjectID, viewerIDStr string) ([]Snippet, error)
 }
 
 type SnippetModel struct {
-	Client *mongo.Client
+	DB *mongo.Database
 }
 
-func (m *SnippetModel) Insert(title, content, tag, username, userIDStr string) (primitive.ObjectID, error) {
-	collection := m.Client.Database("snippetbox").Collection("snippets")
-	snippet := Snippet{
-		Author:       map[string]string{username: userIDStr},
-		Title:        title,
-		Content:      content,
-		Created:      time.Now().UTC(),
-		Tag:          tag,
-		Commentaries: []Commentary{},
+// notExpired matches snippets without an expiry time or with one that is
+// still in the future.
+func notExpired() bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"expires": bson.M{"$exists": false}},
+		bson.M{"expires": bson.M{"$gt": time.Now().UTC()}},
+	}}
+}
+
+// listed matches the snippets that may be listed: public ones, including
+// those saved before visibility was recorded.
+func listed() bson.M {
+	return bson.M{"visibility": bson.M{"$in": bson.A{VisibilityPublic, nil}}}
+}
+
+// ensureIndexes creates the indexes the Mongo models rely on. It is safe to
+// call on every start.
+func ensureIndexes(db *mongo.Database) error {
+	_, err := db.Collection("snippets").Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expires", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		// Listings are sorted by one of these and then _id.
+		{Keys: bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "favourited", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "commented", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "forked_from", Value: 1}}},
+		{
+			Keys: bson.D{
+				{Key: "title", Value: "text"},
+				{Key: "tags", Value: "text"},
+				{Key: "content", Value: "text"},
+			},
+			Options: options.Index().SetName("search").SetWeights(bson.M{
+				"title":   titleWeight,
+				"tags":    tagWeight,
+				"content": contentWeight,
+			}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection("commentaries").Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		// Pages of threads are read newest first, and then their replies.
+		{Keys: bson.D{{Key: "snippet_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "thread_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expires", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{Keys: bson.D{{Key: "content", Value: "text"}}, Options: options.Index().SetName("search")},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection("revisions").Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "snippet_id", Value: 1}, {Key: "number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection("api_tokens").Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection("audit_log").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}},
+	})
+	return err
+}
+
+func (m *SnippetModel) Insert(title, content string, tags []string, language, visibility, username, userIDStr string, expires int) (primitive.ObjectID, error) {
+	return m.insert(Snippet{
+		Author:     map[string]string{username: userIDStr},
+		Title:      title,
+		Content:    content,
+		Tags:       tags,
+		Language:   language,
+		Visibility: visibility,
+		Expires:    ExpiresAt(expires),
+	})
+}
+
+// insert stores snippet as a new snippet with its first revision.
+func (m *SnippetModel) insert(snippet Snippet) (primitive.ObjectID, error) {
+	collection := m.DB.Collection("snippets")
+	snippet.Created = time.Now().UTC()
+	if snippet.Visibility == "" {
+		snippet.Visibility = VisibilityPublic
 	}
 	result, err := collection.InsertOne(context.TODO(), snippet)
 	if err != nil {
@@ -48,14 +239,61 @@ func (m *SnippetModel) Insert(title, content, tag, username, userIDStr string) (
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
+	err = m.addRevision(snippet, 0)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
 	id := result.InsertedID.(primitive.ObjectID)
 	return id, nil
 }
 
+// Fork copies the snippet as a new one written by the given user, which
+// keeps its visibility, doesn't expire and starts without favourites or
+// commentaries.
+func (m *SnippetModel) Fork(id primitive.ObjectID, username, userIDStr string) (primitive.ObjectID, error) {
+	parent, err := m.GetVisible(id, userIDStr)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return m.insert(Snippet{
+		Author:     map[string]string{username: userIDStr},
+		Title:      parent.Title,
+		Content:    parent.Content,
+		Tags:       parent.Tags,
+		Language:   parent.Language,
+		Visibility: parent.Visibility,
+		ForkedFrom: id,
+	})
+}
+
+// Forks returns the public snippets forked from the snippet, and those of the
+// viewer, newest first.
+func (m *SnippetModel) Forks(id primitive.ObjectID, viewerIDStr string) ([]Snippet, error) {
+	filter := bson.M{"forked_from": id, "$and": bson.A{notExpired()}}
+	opts := options.Find().SetSort(bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}})
+	cur, err := m.DB.Collection("snippets").Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var all []Snippet
+	err = cur.All(context.TODO(), &all)
+	if err != nil {
+		return nil, err
+	}
+	forks := []Snippet{}
+	for _, s := range all {
+		if s.IsPublic() || s.AuthorID() == viewerIDStr {
+			forks = append(forks, s)
+		}
+	}
+	return forks, nil
+}
+
 func (m *SnippetModel) Get(id primitive.ObjectID) (Snippet, error) {
-	collection := m.Client.Database("snippetbox").Collection("snippets")
+	collection := m.DB.Collection("snippets")
 	filter := bson.M{
-		"_id": id,
+		"_id":  id,
+		"$and": bson.A{notExpired()},
 	}
 	var snippet Snippet
 	err := collection.FindOne(context.TODO(), filter).Decode(&snippet)
@@ -69,26 +307,355 @@ func (m *SnippetModel) Get(id primitive.ObjectID) (Snippet, error) {
 	return snippet, nil
 }
 
-func (m *SnippetModel) Latest() ([]Snippet, error) {
-	collection := m.Client.Database("snippetbox").Collection("snippets")
-	filter := bson.M{}
-	options := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(10)
-	cur, err := collection.Find(context.TODO(), filter, options)
+func (m *SnippetModel) GetVisible(id primitive.ObjectID, viewerIDStr string) (Snippet, error) {
+	snippet, err := m.Get(id)
+	if err != nil {
+		return Snippet{}, err
+	}
+	if !snippet.VisibleTo(viewerIDStr) {
+		return Snippet{}, ErrNoRecord
+	}
+	return snippet, nil
+}
+
+func (m *SnippetModel) Latest(q PageQuery) (Page, error) {
+	filter := bson.M{"$and": bson.A{notExpired(), listed()}}
+	return pageSnippets(m.DB.Collection("snippets"), filter, q)
+}
+
+func (m *SnippetModel) ByTag(tag string, q PageQuery) (Page, error) {
+	filter := bson.M{"tags": tag, "$and": bson.A{notExpired(), listed()}}
+	return pageSnippets(m.DB.Collection("snippets"), filter, q)
+}
+
+func (m *SnippetModel) TagCounts() ([]TagCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{notExpired(), listed()}}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: TagCloudSize}},
+	}
+	cur, err := m.DB.Collection("snippets").Aggregate(context.TODO(), pipeline)
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(context.TODO())
+	var counts []TagCount
+	for cur.Next(context.TODO()) {
+		var row struct {
+			Name  string `bson:"_id"`
+			Count int    `bson:"count"`
+		}
+		err := cur.Decode(&row)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, TagCount{Name: row.Name, Count: row.Count})
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// pageSnippets reads one page of the snippets matching filter, ordered by
+// the sort key of q and then _id, both descending.
+func pageSnippets(collection *mongo.Collection, filter bson.M, q PageQuery) (Page, error) {
+	q = q.Normalize()
+	field := "created"
+	switch q.Sort {
+	case SortFavourited:
+		field = "favourited"
+	case SortCommented:
+		field = "commented"
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+	}
+
+	direction, op := -1, "$lt"
+	if q.Backward() {
+		direction, op = 1, "$gt"
+	}
+	cursor, ok, err := q.Cursor()
+	if err != nil {
+		return Page{}, err
+	}
+	if ok {
+		var value any = cursor.Value
+		if q.Sort == SortNewest {
+			value = CursorTime(cursor.Value)
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{field: bson.M{op: value}},
+			bson.M{field: value, "_id": bson.M{op: cursor.ID}},
+		}}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}}},
+		bson.D{{Key: "$limit", Value: q.Limit + 1}},
+	)
+
+	cur, err := collection.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return Page{}, err
+	}
+	defer cur.Close(context.TODO())
 	var snippets []Snippet
 	for cur.Next(context.TODO()) {
 		var snippet Snippet
 		err := cur.Decode(&snippet)
 		if err != nil {
-			return nil, err
+			return Page{}, err
 		}
 		snippets = append(snippets, snippet)
 	}
+	if err := cur.Err(); err != nil {
+		return Page{}, err
+	}
+	return NewPage(q, snippets), nil
+}
+
+func (m *SnippetModel) Update(id primitive.ObjectID, userIDStr, title, content string, tags []string, language string) error {
+	return m.update(id, userIDStr, title, content, tags, language, 0)
+}
+
+// update changes a snippet and records the change as a revision, restored
+// from the given one if not 0.
+func (m *SnippetModel) update(id primitive.ObjectID, userIDStr, title, content string, tags []string, language string, restoredFrom int) error {
+	snippet, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	if snippet.AuthorID() != userIDStr {
+		return ErrNotAuthor
+	}
+	// The filter checks the author again, as they may have changed since.
+	collection := m.DB.Collection("snippets")
+	update := bson.M{"title": title, "content": content, "tags": tags, "language": language}
+	result, err := collection.UpdateOne(context.TODO(), authoredBy(id, userIDStr), bson.M{"$set": update})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotAuthor
+	}
+	snippet.Title, snippet.Content, snippet.Tags, snippet.Language = title, content, tags, language
+	err = m.addRevision(snippet, restoredFrom)
+	if err != nil {
+		return err
+	}
+
+	// Users keep their own copy of each favourite post, refresh them too.
+	collection = m.DB.Collection("users")
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"f._id": id}},
+	})
+	_, err = collection.UpdateMany(context.TODO(), bson.M{"favourites._id": id}, bson.M{"$set": bson.M{
+		"favourites.$[f].title":    title,
+		"favourites.$[f].content":  content,
+		"favourites.$[f].tags":     tags,
+		"favourites.$[f].language": language,
+	}}, opts)
+	return err
+}
+
+func (m *SnippetModel) Delete(id primitive.ObjectID, userIDStr string) error {
+	snippet, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	if snippet.AuthorID() != userIDStr {
+		return ErrNotAuthor
+	}
+	collection := m.DB.Collection("snippets")
+	result, err := collection.DeleteOne(context.TODO(), authoredBy(id, userIDStr))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotAuthor
+	}
+	_, err = m.DB.Collection("revisions").DeleteMany(context.TODO(), bson.M{"snippet_id": id})
+	if err != nil {
+		return err
+	}
+	_, err = m.DB.Collection("commentaries").DeleteMany(context.TODO(), bson.M{"snippet_id": id})
+	if err != nil {
+		return err
+	}
+	collection = m.DB.Collection("users")
+	_, err = collection.UpdateMany(context.TODO(), bson.M{"favourites._id": id}, bson.M{"$pull": bson.M{"favourites": bson.M{"_id": id}}})
+	return err
+}
+
+// authoredBy matches the snippet with the given ID as long as it is still
+// credited to the user with the hex ID userIDStr.
+func authoredBy(id primitive.ObjectID, userIDStr string) bson.M {
+	filter := writtenBy(userIDStr)
+	filter["_id"] = id
+	return filter
+}
+
+// writtenBy matches the snippets, revisions or comments credited to the
+// user with the hex ID userIDStr.
+func writtenBy(userIDStr string) bson.M {
+	return authorHas("v", userIDStr)
+}
+
+// authorHas matches the documents whose Author has s as its name, with
+// part "k", or its ID, with part "v". Author maps the name to the ID, and
+// as names may hold the dots and dollar signs of field paths, it is
+// searched as an array of key-value pairs.
+func authorHas(part, s string) bson.M {
+	pairs := bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$Author", bson.M{}}}}
+	values := bson.M{"$map": bson.M{"input": pairs, "in": "$$this." + part}}
+	return bson.M{"$expr": bson.M{"$in": bson.A{bson.M{"$literal": s}, values}}}
+}
+
+// DeleteExpired removes expired snippets along with their revisions,
+// commentaries and the copies kept in users' favourites. The TTL indexes
+// would get to all but the embedded copies eventually.
+func (m *SnippetModel) DeleteExpired() (int64, error) {
+	now := time.Now().UTC()
+	result, err := m.DB.Collection("snippets").DeleteMany(context.TODO(), bson.M{"expires": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+	_, err = m.DB.Collection("commentaries").DeleteMany(context.TODO(), bson.M{"expires": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+	_, err = m.DB.Collection("revisions").DeleteMany(context.TODO(), bson.M{"expires": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+	_, err = m.DB.Collection("users").UpdateMany(context.TODO(),
+		bson.M{"favourites.expires": bson.M{"$lte": now}},
+		bson.M{"$pull": bson.M{"favourites": bson.M{"expires": bson.M{"$lte": now}}}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// Search ranks matches by the text index score, which unlike the portable
+// Score also understands stemming and stop words.
+func (m *SnippetModel) Search(q SearchQuery) ([]SearchResult, error) {
+	q = q.Normalize()
+	filter := bson.M{"$and": bson.A{notExpired(), listed()}}
+	if q.Tag != "" {
+		filter["tags"] = q.Tag
+	}
+	if q.Author != "" {
+		filter["$expr"] = authorHas("k", q.Author)["$expr"]
+	}
+	created := bson.M{}
+	if !q.From.IsZero() {
+		created["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		created["$lt"] = q.To
+	}
+	if len(created) > 0 {
+		filter["created"] = created
+	}
+	if len(q.Terms) == 0 {
+		opts := options.Find().SetLimit(int64(q.Limit)).SetSort(bson.D{{Key: "created", Value: -1}})
+		return m.searchResults(filter, opts)
+	}
+
+	search := bson.M{"$search": strings.Join(q.Terms, " ")}
+	text := bson.M{"$text": search}
+	for k, v := range filter {
+		text[k] = v
+	}
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().SetLimit(int64(q.Limit)).SetProjection(bson.M{"score": score})
+	opts.SetSort(bson.D{{Key: "score", Value: score}, {Key: "created", Value: -1}})
+	results, err := m.searchResults(text, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commentaries are kept apart, with a text index of their own. Their
+	// scores are added to those of their snippets, which may only match
+	// through them.
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$text": search}}},
+		{{Key: "$addFields", Value: bson.M{"score": score}}},
+		{{Key: "$group", Value: bson.M{"_id": "$snippet_id", "score": bson.M{"$sum": "$score"}}}},
+		{{Key: "$sort", Value: bson.M{"score": -1}}},
+		{{Key: "$limit", Value: MaxSearchLimit}},
+	}
+	cur, err := m.DB.Collection("commentaries").Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var commented []struct {
+		SnippetID primitive.ObjectID `bson:"_id"`
+		Score     float64            `bson:"score"`
+	}
+	err = cur.All(context.TODO(), &commented)
+	if err != nil {
+		return nil, err
+	}
+	found := map[primitive.ObjectID]int{}
+	for i, r := range results {
+		found[r.Snippet.ID] = i
+	}
+	var missing bson.A
+	scores := map[primitive.ObjectID]float64{}
+	for _, c := range commented {
+		if i, ok := found[c.SnippetID]; ok {
+			results[i].Score += commentaryWeight * c.Score
+		} else {
+			missing = append(missing, c.SnippetID)
+			scores[c.SnippetID] = commentaryWeight * c.Score
+		}
+	}
+	if len(missing) > 0 {
+		filter["_id"] = bson.M{"$in": missing}
+		more, err := m.searchResults(filter, options.Find())
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range more {
+			r.Score = scores[r.Snippet.ID]
+			results = append(results, r)
+		}
+	}
+	return Rank(results, q.Limit), nil
+}
+
+// searchResults runs the find behind a search, reading the text score too
+// when opts project it.
+func (m *SnippetModel) searchResults(filter bson.M, opts *options.FindOptions) ([]SearchResult, error) {
+	cur, err := m.DB.Collection("snippets").Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+	var results []SearchResult
+	for cur.Next(context.TODO()) {
+		var result SearchResult
+		err := cur.Decode(&result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		var score struct {
+			Score float64 `bson:"score"`
+		}
+		err = cur.Decode(&score)
+		if err != nil {
+			return nil, err
+		}
+		result.Score = score.Score
+		results = append(results, result)
+	}
 	if err := cur.Err(); err != nil {
 		return nil, err
 	}
-	return snippets, nil
+	return results, nil
 }
@@ -0,0 +1,182 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// userExists reports whether the user with the given ID exists. MySQL
+// only counts rows an UPDATE changed, so updates that may leave a row as it
+// was check for it up front.
+func userExists(tx *sql.Tx, id primitive.ObjectID) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, id.Hex()).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// SetName renames the user, along with the author name kept on everything
+// they wrote.
+func (m *UserModel) SetName(id primitive.ObjectID, name string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = userExists(tx, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE users SET name = ? WHERE id = ?`, name, id.Hex())
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"snippets", "revisions", "commentaries"} {
+		_, err = tx.Exec(`UPDATE `+table+` SET author_name = ? WHERE author_id = ?`, name, id.Hex())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *UserModel) SetEmail(id primitive.ObjectID, email string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = userExists(tx, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE users SET email = ?, email_verified = FALSE WHERE id = ?`, email, id.Hex())
+	if err != nil {
+		if isDuplicateKey(err) {
+			return models.ErrDuplicateEmail
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *UserModel) Delete(id primitive.ObjectID, keepContent bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = userExists(tx, id)
+	if err != nil {
+		return err
+	}
+	idStr := id.Hex()
+
+	stmt := `SELECT id FROM snippets WHERE author_id = ?`
+	if keepContent {
+		stmt += ` AND visibility = 'private'`
+	}
+	snippets, err := queryStrings(tx, stmt, idStr)
+	if err != nil {
+		return err
+	}
+	for _, snippetID := range snippets {
+		err = deleteSnippet(tx, snippetID)
+		if err != nil {
+			return err
+		}
+	}
+	if keepContent {
+		for _, table := range []string{"snippets", "revisions", "commentaries"} {
+			_, err = tx.Exec(`UPDATE `+table+` SET author_id = '', author_name = ? WHERE author_id = ?`, models.DeletedAuthor, idStr)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err = deleteComments(tx, idStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, stmt := range []string{
+		`UPDATE snippets SET favourited = favourited - 1 WHERE id IN (SELECT snippet_id FROM favourites WHERE user_id = ?)`,
+		`DELETE FROM favourites WHERE user_id = ?`,
+		`DELETE FROM api_tokens WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM audit_log WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	} {
+		_, err = tx.Exec(stmt, idStr)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteComments removes the comments the user with the hex ID userIDStr
+// wrote, with the replies to them.
+func deleteComments(tx *sql.Tx, userIDStr string) error {
+	comments, err := queryCommentaries(tx, `SELECT `+commentaryColumns+` FROM commentaries
+	WHERE author_id = ?`, userIDStr)
+	if err != nil {
+		return err
+	}
+	removed := map[primitive.ObjectID]bool{}
+	for _, comment := range comments {
+		if removed[comment.ID] {
+			continue
+		}
+		thread, err := queryCommentaries(tx, `SELECT `+commentaryColumns+` FROM commentaries
+		WHERE thread_id = ?`, comment.ThreadID.Hex())
+		if err != nil {
+			return err
+		}
+		ids := models.Descendants(thread, comment.ID)
+		for _, id := range ids {
+			removed[id] = true
+			_, err = tx.Exec(`DELETE FROM commentaries WHERE id = ?`, id.Hex())
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`UPDATE snippets SET commented = commented - ? WHERE id = ?`, len(ids), comment.SnippetID.Hex())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryStrings runs stmt, which selects a single string column.
+func queryStrings(tx *sql.Tx, stmt string, args ...any) ([]string, error) {
+	rows, err := tx.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		err := rows.Scan(&v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,40 @@
+package sqlstore
+
+import (
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *UserModel) AddAuditEntry(entry models.AuditEntry) error {
+	stmt := `INSERT INTO audit_log (id, user_id, event, email, ip, created) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := m.DB.Exec(stmt, entry.ID.Hex(), entry.UserID.Hex(), entry.Event, entry.Email, entry.IP, entry.Created)
+	return err
+}
+
+func (m *UserModel) AuditEntries(userID primitive.ObjectID, limit int) ([]models.AuditEntry, error) {
+	stmt := `SELECT id, event, email, ip, created FROM audit_log WHERE user_id = ? ORDER BY id DESC LIMIT ?`
+	rows, err := m.DB.Query(stmt, userID.Hex(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		e := models.AuditEntry{UserID: userID}
+		var id string
+		err := rows.Scan(&id, &e.Event, &e.Email, &e.IP, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		e.ID, err = primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
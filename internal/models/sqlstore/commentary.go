@@ -0,0 +1,217 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CommentaryModel struct {
+	DB *sql.DB
+}
+
+func (c *CommentaryModel) AddComentary(ID, ParentID primitive.ObjectID, Author map[string]string, Content string, Markdown bool) (primitive.ObjectID, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM snippets s WHERE s.id = ? AND `+notExpired+`)`, ID.Hex(), now()).Scan(&exists)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if !exists {
+		return primitive.NilObjectID, models.ErrNoRecord
+	}
+	commentID := primitive.NewObjectID()
+	parentID, threadID := "", commentID.Hex()
+	if !ParentID.IsZero() {
+		parentID = ParentID.Hex()
+		err = tx.QueryRow(`SELECT thread_id FROM commentaries WHERE id = ? AND snippet_id = ?`, parentID, ID.Hex()).Scan(&threadID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return primitive.NilObjectID, models.ErrNoRecord
+			}
+			return primitive.NilObjectID, err
+		}
+	}
+	var authorName, authorID string
+	for name, id := range Author {
+		authorName, authorID = name, id
+	}
+	stmt := `INSERT INTO commentaries (id, snippet_id, parent_id, thread_id, author_id, author_name, content, markdown, created)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(stmt, commentID.Hex(), ID.Hex(), parentID, threadID, authorID, authorName, Content, Markdown, time.Now().UTC())
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	_, err = tx.Exec(`UPDATE snippets SET commented = commented + 1 WHERE id = ?`, ID.Hex())
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return commentID, tx.Commit()
+}
+
+func (c *CommentaryModel) Commentary(ID, CommentID primitive.ObjectID) (models.Commentary, error) {
+	commentaries, err := queryCommentaries(c.DB, `SELECT `+commentaryColumns+` FROM commentaries
+	WHERE id = ? AND snippet_id = ?`, CommentID.Hex(), ID.Hex())
+	if err != nil {
+		return models.Commentary{}, err
+	}
+	if len(commentaries) == 0 {
+		return models.Commentary{}, models.ErrNoRecord
+	}
+	return commentaries[0], nil
+}
+
+func (c *CommentaryModel) Commentaries(ID primitive.ObjectID, q models.CommentQuery) (models.CommentPage, error) {
+	q = q.Normalize()
+	stmt := `SELECT ` + commentaryColumns + ` FROM commentaries WHERE snippet_id = ? AND thread_id = id`
+	args := []any{ID.Hex()}
+	op, direction := "<", "DESC"
+	if q.Backward() {
+		op, direction = ">", "ASC"
+	}
+	cursor, ok, err := q.Cursor()
+	if err != nil {
+		return models.CommentPage{}, err
+	}
+	if ok {
+		stmt += ` AND id ` + op + ` ?`
+		args = append(args, cursor.Hex())
+	}
+	stmt += ` ORDER BY id ` + direction + ` LIMIT ?`
+	args = append(args, q.Limit+1)
+	roots, err := queryCommentaries(c.DB, stmt, args...)
+	if err != nil {
+		return models.CommentPage{}, err
+	}
+	page := models.NewCommentPage(q, roots)
+	var replies []models.Commentary
+	for _, id := range page.ThreadIDs() {
+		thread, err := queryCommentaries(c.DB, `SELECT `+commentaryColumns+` FROM commentaries
+		WHERE thread_id = ? AND id <> thread_id`, id.Hex())
+		if err != nil {
+			return models.CommentPage{}, err
+		}
+		replies = append(replies, thread...)
+	}
+	page.AddReplies(replies)
+	return page, nil
+}
+
+// comment loads the comment, checking that the user may change it.
+func (c *CommentaryModel) comment(ID, CommentID primitive.ObjectID, userIDStr string) (models.Commentary, error) {
+	snippet, err := (&SnippetModel{DB: c.DB}).Get(ID)
+	if err != nil {
+		return models.Commentary{}, err
+	}
+	comment, err := c.Commentary(ID, CommentID)
+	if err != nil {
+		return models.Commentary{}, err
+	}
+	if !models.CanModifyComment(snippet, comment, userIDStr) {
+		return models.Commentary{}, models.ErrNotAuthor
+	}
+	return comment, nil
+}
+
+func (c *CommentaryModel) UpdateComentary(ID, CommentID primitive.ObjectID, userIDStr, Content string, Markdown bool) error {
+	_, err := c.comment(ID, CommentID, userIDStr)
+	if err != nil {
+		return err
+	}
+	stmt := `UPDATE commentaries SET content = ?, markdown = ?, edited = ? WHERE id = ? AND snippet_id = ?`
+	_, err = c.DB.Exec(stmt, Content, Markdown, time.Now().UTC(), CommentID.Hex(), ID.Hex())
+	return err
+}
+
+func (c *CommentaryModel) DeleteComentary(ID, CommentID primitive.ObjectID, userIDStr string) error {
+	comment, err := c.comment(ID, CommentID, userIDStr)
+	if err != nil {
+		return err
+	}
+	thread, err := queryCommentaries(c.DB, `SELECT `+commentaryColumns+` FROM commentaries
+	WHERE thread_id = ?`, comment.ThreadID.Hex())
+	if err != nil {
+		return err
+	}
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := models.Descendants(thread, CommentID)
+	for _, id := range ids {
+		_, err = tx.Exec(`DELETE FROM commentaries WHERE id = ? AND snippet_id = ?`, id.Hex(), ID.Hex())
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`UPDATE snippets SET commented = commented - ? WHERE id = ?`, len(ids), ID.Hex())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const commentaryColumns = `id, snippet_id, parent_id, thread_id, author_id, author_name, content, markdown, created, edited`
+
+// queryCommentaries runs stmt, which selects commentaryColumns.
+func queryCommentaries(db interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, stmt string, args ...any) ([]models.Commentary, error) {
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	commentaries := []models.Commentary{}
+	for rows.Next() {
+		var c models.Commentary
+		var id, snippetID, parentID, threadID, authorID, authorName string
+		var edited sql.NullTime
+		err := rows.Scan(&id, &snippetID, &parentID, &threadID, &authorID, &authorName, &c.Content, &c.Markdown, &c.Created, &edited)
+		if err != nil {
+			return nil, err
+		}
+		c.ID, err = primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		c.SnippetID, err = primitive.ObjectIDFromHex(snippetID)
+		if err != nil {
+			return nil, err
+		}
+		if parentID != "" {
+			c.ParentID, err = primitive.ObjectIDFromHex(parentID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// Comments the migration hasn't threaded yet have no thread.
+		if threadID != "" {
+			c.ThreadID, err = primitive.ObjectIDFromHex(threadID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if edited.Valid {
+			c.Edited = edited.Time
+		}
+		c.Author = map[string]string{authorName: authorID}
+		commentaries = append(commentaries, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return commentaries, nil
+}
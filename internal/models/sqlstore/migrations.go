@@ -0,0 +1,372 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// migration is a single schema change. Statements in common run on every
+// dialect, followed by the ones specific to the dialect in use, and then
+// data, if set, for changes that can't be written in portable SQL.
+type migration struct {
+	version int
+	common  []string
+	mysql   []string
+	sqlite  []string
+	data    func(tx *sql.Tx) error
+}
+
+// migrations must only ever be appended to; a released migration is never
+// edited, since databases in the wild have already applied it.
+var migrations = []migration{
+	{
+		version: 1,
+		common: []string{
+			`CREATE TABLE users (
+				id CHAR(24) NOT NULL PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				email VARCHAR(255) NOT NULL,
+				hashed_password CHAR(60) NOT NULL,
+				created DATETIME NOT NULL,
+				CONSTRAINT users_uc_email UNIQUE (email)
+			)`,
+			`CREATE TABLE snippets (
+				id CHAR(24) NOT NULL PRIMARY KEY,
+				author_id CHAR(24) NOT NULL,
+				author_name VARCHAR(255) NOT NULL,
+				title VARCHAR(100) NOT NULL,
+				content TEXT NOT NULL,
+				created DATETIME NOT NULL,
+				tag VARCHAR(255) NOT NULL,
+				favourited INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX idx_snippets_author ON snippets (author_id)`,
+			`CREATE TABLE favourites (
+				user_id CHAR(24) NOT NULL,
+				snippet_id CHAR(24) NOT NULL,
+				created DATETIME NOT NULL,
+				PRIMARY KEY (user_id, snippet_id)
+			)`,
+			`CREATE TABLE commentaries (
+				id CHAR(24) NOT NULL PRIMARY KEY,
+				snippet_id CHAR(24) NOT NULL,
+				author_id CHAR(24) NOT NULL,
+				author_name VARCHAR(255) NOT NULL,
+				content TEXT NOT NULL,
+				created DATETIME NOT NULL
+			)`,
+			`CREATE INDEX idx_commentaries_snippet ON commentaries (snippet_id)`,
+		},
+		mysql: []string{
+			`CREATE TABLE sessions (
+				token CHAR(43) PRIMARY KEY,
+				data BLOB NOT NULL,
+				expiry TIMESTAMP(6) NOT NULL
+			)`,
+			`CREATE INDEX sessions_expiry_idx ON sessions (expiry)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE sessions (
+				token TEXT PRIMARY KEY,
+				data BLOB NOT NULL,
+				expiry REAL NOT NULL
+			)`,
+			`CREATE INDEX sessions_expiry_idx ON sessions (expiry)`,
+		},
+	},
+	{
+		version: 2,
+		common: []string{
+			`ALTER TABLE snippets ADD COLUMN expires DATETIME NULL`,
+			`CREATE INDEX idx_snippets_expires ON snippets (expires)`,
+		},
+	},
+	{
+		version: 3,
+		common: []string{
+			`ALTER TABLE snippets ADD COLUMN commented INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE snippets SET commented = (SELECT COUNT(*) FROM commentaries c WHERE c.snippet_id = snippets.id)`,
+			`CREATE INDEX idx_snippets_created ON snippets (created, id)`,
+			`CREATE INDEX idx_snippets_favourited ON snippets (favourited, id)`,
+			`CREATE INDEX idx_snippets_commented ON snippets (commented, id)`,
+		},
+	},
+	{
+		version: 4,
+		common: []string{
+			`CREATE TABLE snippet_tags (
+				snippet_id CHAR(24) NOT NULL,
+				position INTEGER NOT NULL,
+				tag VARCHAR(32) NOT NULL,
+				PRIMARY KEY (snippet_id, tag)
+			)`,
+			`CREATE INDEX idx_snippet_tags_tag ON snippet_tags (tag)`,
+		},
+		data: splitTags,
+	},
+	{
+		version: 5,
+		common: []string{
+			`ALTER TABLE snippets DROP COLUMN tag`,
+		},
+	},
+	{
+		version: 6,
+		common: []string{
+			`CREATE TABLE api_tokens (
+				id CHAR(24) NOT NULL PRIMARY KEY,
+				user_id CHAR(24) NOT NULL,
+				name VARCHAR(100) NOT NULL,
+				scope VARCHAR(16) NOT NULL,
+				hash CHAR(64) NOT NULL,
+				created DATETIME NOT NULL,
+				last_used DATETIME NULL,
+				CONSTRAINT api_tokens_uc_hash UNIQUE (hash)
+			)`,
+			`CREATE INDEX idx_api_tokens_user ON api_tokens (user_id)`,
+		},
+	},
+	{
+		version: 7,
+		common: []string{
+			`ALTER TABLE snippets ADD COLUMN language VARCHAR(32) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 8,
+		common: []string{
+			`ALTER TABLE commentaries ADD COLUMN markdown BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+	{
+		version: 9,
+		common: []string{
+			`CREATE TABLE revisions (
+				id CHAR(24) NOT NULL PRIMARY KEY,
+				snippet_id CHAR(24) NOT NULL,
+				number INTEGER NOT NULL,
+				author_id CHAR(24) NOT NULL,
+				author_name VARCHAR(255) NOT NULL,
+				title VARCHAR(100) NOT NULL,
+				content TEXT NOT NULL,
+				tags VARCHAR(400) NOT NULL,
+				language VARCHAR(32) NOT NULL,
+				restored_from INTEGER NOT NULL DEFAULT 0,
+				created DATETIME NOT NULL,
+				CONSTRAINT revisions_uc_number UNIQUE (snippet_id, number)
+			)`,
+		},
+		data: seedRevisions,
+	},
+	{
+		version: 10,
+		common: []string{
+			`ALTER TABLE snippets ADD COLUMN forked_from CHAR(24) NOT NULL DEFAULT ''`,
+			`CREATE INDEX idx_snippets_forked_from ON snippets (forked_from)`,
+		},
+	},
+	{
+		version: 11,
+		common: []string{
+			`ALTER TABLE snippets ADD COLUMN visibility VARCHAR(16) NOT NULL DEFAULT 'public'`,
+		},
+	},
+	{
+		version: 12,
+		common: []string{
+			`ALTER TABLE commentaries ADD COLUMN parent_id CHAR(24) NOT NULL DEFAULT ''`,
+			`ALTER TABLE commentaries ADD COLUMN edited DATETIME NULL`,
+		},
+	},
+	{
+		version: 13,
+		common: []string{
+			`ALTER TABLE commentaries ADD COLUMN thread_id CHAR(24) NOT NULL DEFAULT ''`,
+			`CREATE INDEX idx_commentaries_thread ON commentaries (thread_id)`,
+		},
+		data: setThreads,
+	},
+	{
+		version: 14,
+		common: []string{
+			`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+			// Users who signed up before addresses were verified can still
+			// log in.
+			`UPDATE users SET email_verified = TRUE`,
+		},
+	},
+	{
+		version: 15,
+		common: []string{
+			`ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN totp_step BIGINT NOT NULL DEFAULT 0`,
+			`CREATE TABLE recovery_codes (
+				user_id CHAR(24) NOT NULL,
+				hash CHAR(64) NOT NULL,
+				PRIMARY KEY (user_id, hash)
+			)`,
+		},
+	},
+	{
+		version: 16,
+		common: []string{
+			`CREATE TABLE audit_log (
+				id CHAR(24) NOT NULL PRIMARY KEY,
+				user_id CHAR(24) NOT NULL,
+				event VARCHAR(64) NOT NULL,
+				email VARCHAR(255) NOT NULL,
+				ip VARCHAR(64) NOT NULL,
+				created DATETIME NOT NULL
+			)`,
+			`CREATE INDEX idx_audit_log_user ON audit_log (user_id, id)`,
+		},
+	},
+}
+
+// splitTags fills snippet_tags from the single free-text tag column.
+func splitTags(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, tag FROM snippets`)
+	if err != nil {
+		return err
+	}
+	tags := map[string][]string{}
+	for rows.Next() {
+		var id, tag string
+		err := rows.Scan(&id, &tag)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tags[id] = models.NormalizeTags(models.SplitTags(tag))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, t := range tags {
+		err := insertTags(tx, id, t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedRevisions records the current state of every snippet as its first
+// revision, dated when the snippet was created.
+func seedRevisions(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id FROM snippets`)
+	if err != nil {
+		return err
+	}
+	var ids []primitive.ObjectID
+	for rows.Next() {
+		var idStr string
+		err := rows.Scan(&idStr)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		id, err := primitive.ObjectIDFromHex(idStr)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := addRevision(tx, id, 0)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`UPDATE revisions SET created = (SELECT s.created FROM snippets s WHERE s.id = revisions.snippet_id)`)
+	return err
+}
+
+// setThreads fills in the thread of every comment from its parents.
+func setThreads(tx *sql.Tx) error {
+	commentaries, err := queryCommentaries(tx, `SELECT `+commentaryColumns+` FROM commentaries`)
+	if err != nil {
+		return err
+	}
+	bySnippet := map[primitive.ObjectID][]models.Commentary{}
+	for _, c := range commentaries {
+		bySnippet[c.SnippetID] = append(bySnippet[c.SnippetID], c)
+	}
+	for _, commentaries := range bySnippet {
+		models.SetThreads(commentaries)
+		for _, c := range commentaries {
+			_, err := tx.Exec(`UPDATE commentaries SET thread_id = ? WHERE id = ?`, c.ThreadID.Hex(), c.ID.Hex())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Migrate brings the schema up to date, recording each applied version in
+// the schema_migrations table. Every migration runs in its own transaction,
+// although MySQL commits DDL statements implicitly.
+func Migrate(db *sql.DB, dialect string) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		statements := m.common
+		switch dialect {
+		case MySQL:
+			statements = append(statements[:len(statements):len(statements)], m.mysql...)
+		case SQLite:
+			statements = append(statements[:len(statements):len(statements)], m.sqlite...)
+		}
+		err := applyMigration(db, m.version, statements, m.data)
+		if err != nil {
+			return fmt.Errorf("sqlstore: migration %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, version int, statements []string, data func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range statements {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	if data != nil {
+		err := data(tx)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
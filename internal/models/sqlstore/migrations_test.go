@@ -0,0 +1,134 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMigrateSplitsTags(t *testing.T) {
+	db, err := sql.Open(SQLite, filepath.Join(t.TempDir(), "test_snippetbox.db"))
+	assert.NilError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Stop short of the tags migration and store a snippet the old way.
+	_, err = db.Exec(`CREATE TABLE schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`)
+	assert.NilError(t, err)
+	for _, m := range migrations[:3] {
+		assert.NilError(t, applyMigration(db, m.version, append(m.common, m.sqlite...), m.data))
+	}
+	id := primitive.NewObjectID()
+	_, err = db.Exec(`INSERT INTO snippets (id, author_id, author_name, title, content, created, tag)
+	VALUES (?, ?, 'Alice', 'Title', 'Content', ?, 'Go, web  GO c/c++')`, id.Hex(), primitive.NewObjectID().Hex(), time.Now().UTC())
+	assert.NilError(t, err)
+
+	assert.NilError(t, Migrate(db, SQLite))
+	snippets := SnippetModel{DB: db}
+	snippet, err := snippets.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippet.Tags), 3)
+	assert.Equal(t, snippet.Tags[0], "go")
+	assert.Equal(t, snippet.Tags[1], "web")
+	assert.Equal(t, snippet.Tags[2], "cc++")
+}
+
+func TestMigrateSeedsRevisions(t *testing.T) {
+	db, err := sql.Open(SQLite, filepath.Join(t.TempDir(), "test_snippetbox.db"))
+	assert.NilError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Stop short of the revisions migration and store a snippet without any.
+	_, err = db.Exec(`CREATE TABLE schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`)
+	assert.NilError(t, err)
+	for _, m := range migrations[:8] {
+		assert.NilError(t, applyMigration(db, m.version, append(m.common, m.sqlite...), m.data))
+	}
+	id := primitive.NewObjectID()
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, err = db.Exec(`INSERT INTO snippets (id, author_id, author_name, title, content, created)
+	VALUES (?, ?, 'Alice', 'Title', 'Content', ?)`, id.Hex(), primitive.NewObjectID().Hex(), created)
+	assert.NilError(t, err)
+	_, err = db.Exec(`INSERT INTO snippet_tags (snippet_id, position, tag) VALUES (?, 0, 'go')`, id.Hex())
+	assert.NilError(t, err)
+
+	assert.NilError(t, Migrate(db, SQLite))
+	snippets := SnippetModel{DB: db}
+	revisions, err := snippets.Revisions(id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions), 1)
+	assert.Equal(t, revisions[0].Number, 1)
+	assert.Equal(t, revisions[0].Tags[0], "go")
+	assert.Equal(t, revisions[0].Created.Equal(created), true)
+}
+
+func TestMigrateSetsThreads(t *testing.T) {
+	db, err := sql.Open(SQLite, filepath.Join(t.TempDir(), "test_snippetbox.db"))
+	assert.NilError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Stop short of the threads migration and store a comment with a reply,
+	// and a reply to a comment that is gone.
+	_, err = db.Exec(`CREATE TABLE schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`)
+	assert.NilError(t, err)
+	for _, m := range migrations[:12] {
+		assert.NilError(t, applyMigration(db, m.version, append(m.common, m.sqlite...), m.data))
+	}
+	id, first, reply := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	orphan := primitive.NewObjectID()
+	_, err = db.Exec(`INSERT INTO snippets (id, author_id, author_name, title, content, created, commented)
+	VALUES (?, ?, 'Alice', 'Title', 'Content', ?, 3)`, id.Hex(), primitive.NewObjectID().Hex(), time.Now().UTC())
+	assert.NilError(t, err)
+	for _, c := range [][2]string{{first.Hex(), ""}, {reply.Hex(), first.Hex()}, {orphan.Hex(), primitive.NewObjectID().Hex()}} {
+		_, err = db.Exec(`INSERT INTO commentaries (id, snippet_id, parent_id, author_id, author_name, content, created)
+		VALUES (?, ?, ?, '', 'Bob', 'Nice', ?)`, c[0], id.Hex(), c[1], time.Now().UTC())
+		assert.NilError(t, err)
+	}
+
+	assert.NilError(t, Migrate(db, SQLite))
+	commentary := CommentaryModel{DB: db}
+	page, err := commentary.Commentaries(id, models.CommentQuery{})
+	assert.NilError(t, err)
+	// The orphaned reply is a thread of its own, the newest.
+	assert.Equal(t, len(page.Commentaries), 3)
+	assert.Equal(t, page.Commentaries[0].ID, orphan)
+	assert.Equal(t, page.Commentaries[0].ThreadID, orphan)
+	assert.Equal(t, page.Commentaries[1].ThreadID, first)
+	assert.Equal(t, page.Commentaries[2].ThreadID, first)
+}
+
+func TestMigrateVerifiesExistingUsers(t *testing.T) {
+	db, err := sql.Open(SQLite, filepath.Join(t.TempDir(), "test_snippetbox.db"))
+	assert.NilError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Stop short of email verification and store a user signed up before it.
+	_, err = db.Exec(`CREATE TABLE schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`)
+	assert.NilError(t, err)
+	for _, m := range migrations[:13] {
+		assert.NilError(t, applyMigration(db, m.version, append(m.common, m.sqlite...), m.data))
+	}
+	id := primitive.NewObjectID()
+	_, err = db.Exec(`INSERT INTO users (id, name, email, hashed_password, created)
+	VALUES (?, 'Alice', 'alice@example.com', '', ?)`, id.Hex(), time.Now().UTC())
+	assert.NilError(t, err)
+
+	assert.NilError(t, Migrate(db, SQLite))
+	users := UserModel{DB: db}
+	user, err := users.GetByID(id)
+	assert.NilError(t, err)
+	assert.Equal(t, user.EmailVerified, true)
+	assert.NilError(t, users.Insert("Bob", "bob@example.com", "pa$$word"))
+	user, err = users.GetByEmail("bob@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, user.EmailVerified, false)
+}
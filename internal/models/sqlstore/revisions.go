@@ -0,0 +1,123 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tags are kept in the revisions table as a single space separated column,
+// which is safe since tags can't contain spaces.
+const revisionColumns = `r.id, r.snippet_id, r.number, r.author_id, r.author_name, r.title, r.content, r.tags, r.language, r.restored_from, r.created`
+
+func scanRevision(row rowScanner) (models.Revision, error) {
+	var r models.Revision
+	var id, snippetID, authorID, authorName, tags string
+	err := row.Scan(&id, &snippetID, &r.Number, &authorID, &authorName, &r.Title, &r.Content, &tags, &r.Language, &r.RestoredFrom, &r.Created)
+	if err != nil {
+		return models.Revision{}, err
+	}
+	r.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return models.Revision{}, err
+	}
+	r.SnippetID, err = primitive.ObjectIDFromHex(snippetID)
+	if err != nil {
+		return models.Revision{}, err
+	}
+	r.Author = map[string]string{authorName: authorID}
+	r.Tags = strings.Fields(tags)
+	return r, nil
+}
+
+// addRevision records the state of a snippet, as seen by tx, as the revision
+// following its latest.
+func addRevision(tx *sql.Tx, id primitive.ObjectID, restoredFrom int) error {
+	var authorID, authorName, title, content, language string
+	err := tx.QueryRow(`SELECT author_id, author_name, title, content, language FROM snippets WHERE id = ?`, id.Hex()).
+		Scan(&authorID, &authorName, &title, &content, &language)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT tag FROM snippet_tags WHERE snippet_id = ? ORDER BY position`, id.Hex())
+	if err != nil {
+		return err
+	}
+	var tags []string
+	for rows.Next() {
+		var tag string
+		err := rows.Scan(&tag)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tags = append(tags, tag)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	var number int
+	err = tx.QueryRow(`SELECT COALESCE(MAX(number), 0) + 1 FROM revisions WHERE snippet_id = ?`, id.Hex()).Scan(&number)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO revisions (id, snippet_id, number, author_id, author_name, title, content, tags, language, restored_from, created)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(stmt, primitive.NewObjectID().Hex(), id.Hex(), number, authorID, authorName, title, content,
+		strings.Join(tags, " "), language, restoredFrom, now())
+	return err
+}
+
+// Revisions returns the history of a snippet, newest first.
+func (m *SnippetModel) Revisions(id primitive.ObjectID) ([]models.Revision, error) {
+	_, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.DB.Query(`SELECT `+revisionColumns+` FROM revisions r WHERE r.snippet_id = ? ORDER BY r.number DESC`, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.Revision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (m *SnippetModel) Revision(id primitive.ObjectID, number int) (models.Revision, error) {
+	stmt := `SELECT ` + revisionColumns + ` FROM revisions r JOIN snippets s ON s.id = r.snippet_id
+	WHERE r.snippet_id = ? AND r.number = ? AND ` + notExpired
+	r, err := scanRevision(m.DB.QueryRow(stmt, id.Hex(), number, now()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Revision{}, models.ErrNoRecord
+		}
+		return models.Revision{}, err
+	}
+	return r, nil
+}
+
+// Restore makes the snippet look like revision number again, recording that
+// as a new revision.
+func (m *SnippetModel) Restore(id primitive.ObjectID, userIDStr string, number int) error {
+	r, err := m.Revision(id, number)
+	if err != nil {
+		return err
+	}
+	return m.update(id, userIDStr, r.Title, r.Content, r.Tags, r.Language, number)
+}
@@ -0,0 +1,67 @@
+package sqlstore
+
+import (
+	"strings"
+
+	"snippetbox/internal/models"
+)
+
+// searchCandidates bounds how many of the newest matching snippets are
+// scored in Go. SQL has no portable text index, so matching is done with
+// LIKE and ranking with models.Score.
+const searchCandidates = 500
+
+// likeEscaper escapes the LIKE wildcards with !, which unlike a backslash
+// means the same to MySQL and SQLite.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (m *SnippetModel) Search(q models.SearchQuery) ([]models.SearchResult, error) {
+	q = q.Normalize()
+	where := []string{notExpired, listed}
+	args := []any{now()}
+	if q.Tag != "" {
+		where = append(where, hasTag)
+		args = append(args, q.Tag)
+	}
+	if q.Author != "" {
+		where = append(where, `s.author_name = ?`)
+		args = append(args, q.Author)
+	}
+	if !q.From.IsZero() {
+		where = append(where, `s.created >= ?`)
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		where = append(where, `s.created < ?`)
+		args = append(args, q.To.UTC())
+	}
+	if len(q.Terms) > 0 {
+		var matches []string
+		for _, term := range q.Terms {
+			pattern := "%" + likeEscaper.Replace(term) + "%"
+			matches = append(matches, `(LOWER(s.title) LIKE ? ESCAPE '!' OR LOWER(s.content) LIKE ? ESCAPE '!'
+			OR EXISTS (SELECT 1 FROM snippet_tags t WHERE t.snippet_id = s.id AND t.tag LIKE ? ESCAPE '!')
+			OR EXISTS (SELECT 1 FROM commentaries c WHERE c.snippet_id = s.id AND LOWER(c.content) LIKE ? ESCAPE '!'))`)
+			args = append(args, pattern, pattern, pattern, pattern)
+		}
+		where = append(where, "("+strings.Join(matches, " OR ")+")")
+	}
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s WHERE ` + strings.Join(where, " AND ") +
+		` ORDER BY s.created DESC, s.id DESC LIMIT ?`
+	args = append(args, searchCandidates)
+
+	snippets, err := querySnippets(m.DB, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]models.SearchResult, 0, len(snippets))
+	for _, s := range snippets {
+		commentaries, err := queryCommentaries(m.DB, `SELECT `+commentaryColumns+` FROM commentaries
+		WHERE snippet_id = ?`, s.ID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, models.SearchResult{Snippet: s, Score: models.Score(s, commentaries, q.Terms)})
+	}
+	return models.Rank(results, q.Limit), nil
+}
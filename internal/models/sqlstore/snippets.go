@@ -0,0 +1,357 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const snippetColumns = `s.id, s.author_id, s.author_name, s.title, s.content, s.created, s.favourited, s.commented, s.expires, s.language, s.forked_from, s.visibility`
+
+// notExpired is a condition on the snippets table aliased as s. It takes the
+// current time as its only argument.
+const notExpired = `(s.expires IS NULL OR s.expires > ?)`
+
+// listed is a condition on the snippets table aliased as s matching the
+// snippets that may be listed.
+const listed = `s.visibility = 'public'`
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+type SnippetModel struct {
+	DB *sql.DB
+}
+
+func (m *SnippetModel) Insert(title, content string, tags []string, language, visibility, username, userIDStr string, expires int) (primitive.ObjectID, error) {
+	var expiresAt sql.NullTime
+	if expires > 0 {
+		expiresAt = sql.NullTime{Time: models.ExpiresAt(expires), Valid: true}
+	}
+	return m.insert(models.Snippet{
+		Author:     map[string]string{username: userIDStr},
+		Title:      title,
+		Content:    content,
+		Tags:       tags,
+		Language:   language,
+		Visibility: visibility,
+	}, expiresAt)
+}
+
+// insert stores s as a new snippet with its first revision.
+func (m *SnippetModel) insert(s models.Snippet, expires sql.NullTime) (primitive.ObjectID, error) {
+	id := primitive.NewObjectID()
+	var forkedFrom string
+	if !s.ForkedFrom.IsZero() {
+		forkedFrom = s.ForkedFrom.Hex()
+	}
+	if s.Visibility == "" {
+		s.Visibility = models.VisibilityPublic
+	}
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO snippets (id, author_id, author_name, title, content, language, created, favourited, expires, forked_from, visibility)
+	VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?)`
+	_, err = tx.Exec(stmt, id.Hex(), s.AuthorID(), s.AuthorName(), s.Title, s.Content, s.Language, time.Now().UTC(), expires, forkedFrom, s.Visibility)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	err = insertTags(tx, id.Hex(), s.Tags)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	err = addRevision(tx, id, 0)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return id, tx.Commit()
+}
+
+// Fork copies the snippet as a new one written by the given user, which
+// keeps its visibility, doesn't expire and starts without favourites or
+// commentaries.
+func (m *SnippetModel) Fork(id primitive.ObjectID, username, userIDStr string) (primitive.ObjectID, error) {
+	parent, err := m.GetVisible(id, userIDStr)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return m.insert(models.Snippet{
+		Author:     map[string]string{username: userIDStr},
+		Title:      parent.Title,
+		Content:    parent.Content,
+		Tags:       parent.Tags,
+		Language:   parent.Language,
+		Visibility: parent.Visibility,
+		ForkedFrom: id,
+	}, sql.NullTime{})
+}
+
+// Forks returns the public snippets forked from the snippet, and those of
+// the viewer, newest first.
+func (m *SnippetModel) Forks(id primitive.ObjectID, viewerIDStr string) ([]models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s WHERE s.forked_from = ? AND ` + notExpired + `
+	AND (` + listed + ` OR s.author_id = ?) ORDER BY s.created DESC, s.id DESC`
+	forks, err := querySnippets(m.DB, stmt, id.Hex(), now(), viewerIDStr)
+	if err != nil {
+		return nil, err
+	}
+	if forks == nil {
+		forks = []models.Snippet{}
+	}
+	return forks, nil
+}
+
+func (m *SnippetModel) Get(id primitive.ObjectID) (models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s WHERE s.id = ? AND ` + notExpired
+	snippet, err := scanSnippet(m.DB.QueryRow(stmt, id.Hex(), now()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Snippet{}, models.ErrNoRecord
+		}
+		return models.Snippet{}, err
+	}
+	snippet.Tags, err = tagsFor(m.DB, id)
+	if err != nil {
+		return models.Snippet{}, err
+	}
+	return snippet, nil
+}
+
+func (m *SnippetModel) GetVisible(id primitive.ObjectID, viewerIDStr string) (models.Snippet, error) {
+	snippet, err := m.Get(id)
+	if err != nil {
+		return models.Snippet{}, err
+	}
+	if !snippet.VisibleTo(viewerIDStr) {
+		return models.Snippet{}, models.ErrNoRecord
+	}
+	return snippet, nil
+}
+
+func (m *SnippetModel) Latest(q models.PageQuery) (models.Page, error) {
+	return pageSnippets(m.DB, notExpired+` AND `+listed, []any{now()}, q)
+}
+
+// pageSnippets reads one page of the snippets matching where, a condition on
+// the snippets table aliased as s, ordered by the sort key of q and then id.
+func pageSnippets(db *sql.DB, where string, args []any, q models.PageQuery) (models.Page, error) {
+	q = q.Normalize()
+	column := "s.created"
+	switch q.Sort {
+	case models.SortFavourited:
+		column = "s.favourited"
+	case models.SortCommented:
+		column = "s.commented"
+	}
+	direction, op := "DESC", "<"
+	if q.Backward() {
+		direction, op = "ASC", ">"
+	}
+	cursor, ok, err := q.Cursor()
+	if err != nil {
+		return models.Page{}, err
+	}
+	if ok {
+		var value any = cursor.Value
+		if q.Sort == models.SortNewest {
+			value = models.CursorTime(cursor.Value)
+		}
+		where += fmt.Sprintf(` AND (%[1]s %[2]s ? OR (%[1]s = ? AND s.id %[2]s ?))`, column, op)
+		args = append(args, value, value, cursor.ID.Hex())
+	}
+	stmt := fmt.Sprintf(`SELECT %s FROM snippets s WHERE %s ORDER BY %s %s, s.id %[4]s LIMIT ?`,
+		snippetColumns, where, column, direction)
+	args = append(args, q.Limit+1)
+
+	snippets, err := querySnippets(db, stmt, args...)
+	if err != nil {
+		return models.Page{}, err
+	}
+	return models.NewPage(q, snippets), nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSnippet(row rowScanner) (models.Snippet, error) {
+	var s models.Snippet
+	var authorID, authorName string
+	var expires sql.NullTime
+	var forkedFrom string
+	err := row.Scan(&s.IDStr, &authorID, &authorName, &s.Title, &s.Content, &s.Created, &s.Favourited, &s.Commented, &expires, &s.Language, &forkedFrom, &s.Visibility)
+	if err != nil {
+		return models.Snippet{}, err
+	}
+	if forkedFrom != "" {
+		s.ForkedFrom, err = primitive.ObjectIDFromHex(forkedFrom)
+		if err != nil {
+			return models.Snippet{}, err
+		}
+	}
+	s.Expires = expires.Time
+	s.ID, err = primitive.ObjectIDFromHex(s.IDStr)
+	if err != nil {
+		return models.Snippet{}, err
+	}
+	s.Author = map[string]string{authorName: authorID}
+	return s, nil
+}
+
+func querySnippets(db *sql.DB, stmt string, args ...any) ([]models.Snippet, error) {
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []models.Snippet
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	err = loadTags(db, snippets)
+	if err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// authorize returns ErrNoRecord if the snippet doesn't exist and
+// ErrNotAuthor if it wasn't written by userIDStr.
+func authorize(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, id primitive.ObjectID, userIDStr string) error {
+	var authorID string
+	err := q.QueryRow(`SELECT s.author_id FROM snippets s WHERE s.id = ? AND `+notExpired, id.Hex(), now()).Scan(&authorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrNoRecord
+		}
+		return err
+	}
+	if authorID != userIDStr {
+		return models.ErrNotAuthor
+	}
+	return nil
+}
+
+func (m *SnippetModel) Update(id primitive.ObjectID, userIDStr, title, content string, tags []string, language string) error {
+	return m.update(id, userIDStr, title, content, tags, language, 0)
+}
+
+// update changes a snippet and records the change as a revision, restored
+// from the given one if not 0.
+func (m *SnippetModel) update(id primitive.ObjectID, userIDStr, title, content string, tags []string, language string, restoredFrom int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorize(tx, id, userIDStr)
+	if err != nil {
+		return err
+	}
+	stmt := `UPDATE snippets SET title = ?, content = ?, language = ? WHERE id = ?`
+	_, err = tx.Exec(stmt, title, content, language, id.Hex())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM snippet_tags WHERE snippet_id = ?`, id.Hex())
+	if err != nil {
+		return err
+	}
+	err = insertTags(tx, id.Hex(), tags)
+	if err != nil {
+		return err
+	}
+	err = addRevision(tx, id, restoredFrom)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *SnippetModel) Delete(id primitive.ObjectID, userIDStr string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorize(tx, id, userIDStr)
+	if err != nil {
+		return err
+	}
+	err = deleteSnippet(tx, id.Hex())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteSnippet removes the snippet with the hex ID idStr along with its
+// favourites, commentaries, tags and revisions.
+func deleteSnippet(tx *sql.Tx, idStr string) error {
+	for _, stmt := range []string{
+		`DELETE FROM favourites WHERE snippet_id = ?`,
+		`DELETE FROM commentaries WHERE snippet_id = ?`,
+		`DELETE FROM snippet_tags WHERE snippet_id = ?`,
+		`DELETE FROM revisions WHERE snippet_id = ?`,
+		`DELETE FROM snippets WHERE id = ?`,
+	} {
+		_, err := tx.Exec(stmt, idStr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SnippetModel) DeleteExpired() (int64, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	t := now()
+	for _, stmt := range []string{
+		`DELETE FROM favourites WHERE snippet_id IN (SELECT id FROM snippets WHERE expires <= ?)`,
+		`DELETE FROM commentaries WHERE snippet_id IN (SELECT id FROM snippets WHERE expires <= ?)`,
+		`DELETE FROM snippet_tags WHERE snippet_id IN (SELECT id FROM snippets WHERE expires <= ?)`,
+		`DELETE FROM revisions WHERE snippet_id IN (SELECT id FROM snippets WHERE expires <= ?)`,
+	} {
+		_, err := tx.Exec(stmt, t)
+		if err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM snippets WHERE expires <= ?`, t)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
@@ -0,0 +1,77 @@
+// Package sqlstore implements the model stores on top of a relational
+// database. Both MySQL and SQLite are supported; IDs are still MongoDB
+// ObjectIDs, stored as their 24 character hex form, so the rest of the
+// application does not need to know which backend it is talking to.
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	MySQL  = "mysql"
+	SQLite = "sqlite"
+)
+
+// Open opens a database for the given dialect, checks the connection and
+// applies any outstanding migrations.
+func Open(dialect, dsn string) (*sql.DB, error) {
+	if dialect != MySQL && dialect != SQLite {
+		return nil, fmt.Errorf("sqlstore: unsupported dialect %q", dialect)
+	}
+	if dialect == MySQL {
+		var err error
+		dsn, err = mysqlDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == SQLite {
+		// SQLite only allows a single writer; serialising access through one
+		// connection avoids "database is locked" errors under load.
+		db.SetMaxOpenConns(1)
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	err = Migrate(db, dialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// mysqlDSN returns dsn with the options the stores rely on: DATETIME
+// columns are scanned into time.Time, which needs parseTime, and times are
+// stored in UTC.
+func mysqlDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("sqlstore: %w", err)
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	return cfg.FormatDSN(), nil
+}
+
+func isDuplicateKey(err error) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		return mySQLError.Number == 1062
+	}
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
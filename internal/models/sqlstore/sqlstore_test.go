@@ -0,0 +1,34 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLDSN(t *testing.T) {
+	for _, dsn := range []string{
+		"web:pass@tcp(localhost:3306)/snippetbox",
+		"web:pass@tcp(localhost:3306)/snippetbox?parseTime=false&loc=Local",
+		"web:pass@tcp(localhost:3306)/snippetbox?parseTime=true",
+	} {
+		t.Run(dsn, func(t *testing.T) {
+			got, err := mysqlDSN(dsn)
+			assert.NilError(t, err)
+			cfg, err := mysql.ParseDSN(got)
+			assert.NilError(t, err)
+			assert.Equal(t, cfg.ParseTime, true)
+			assert.Equal(t, cfg.Loc, time.UTC)
+			assert.Equal(t, cfg.DBName, "snippetbox")
+			assert.Equal(t, cfg.User, "web")
+		})
+	}
+
+	_, err := Open(MySQL, "web:pass@tcp(localhost:3306")
+	if err == nil {
+		t.Fatal("want an error for a malformed DSN")
+	}
+}
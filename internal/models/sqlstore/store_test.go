@@ -0,0 +1,32 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"snippetbox/internal/models/storetest"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Stores {
+		db := newTestDB(t)
+		return storetest.Stores{
+			Snippets:   &SnippetModel{DB: db},
+			Users:      &UserModel{DB: db},
+			Commentary: &CommentaryModel{DB: db},
+			// Favourites aren't copies here, so the snippet is all there is
+			// to wind back.
+			Expire: func(id primitive.ObjectID) error {
+				_, err := db.Exec(`UPDATE snippets SET expires = ? WHERE id = ?`, time.Now().UTC().Add(-time.Minute), id.Hex())
+				return err
+			},
+			Count: func(table string) (int, error) {
+				var n int
+				err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n)
+				return n, err
+			},
+		}
+	})
+}
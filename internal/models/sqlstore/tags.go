@@ -0,0 +1,90 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"strings"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// hasTag is a condition on the snippets table aliased as s. It takes the tag
+// as its only argument.
+const hasTag = `EXISTS (SELECT 1 FROM snippet_tags t WHERE t.snippet_id = s.id AND t.tag = ?)`
+
+func insertTags(tx *sql.Tx, snippetID string, tags []string) error {
+	for i, tag := range tags {
+		_, err := tx.Exec(`INSERT INTO snippet_tags (snippet_id, position, tag) VALUES (?, ?, ?)`, snippetID, i, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTags fills in the tags of snippets with a single query.
+func loadTags(db *sql.DB, snippets []models.Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(snippets))
+	args := make([]any, len(snippets))
+	for i, s := range snippets {
+		index[s.IDStr] = i
+		args[i] = s.IDStr
+		snippets[i].Tags = []string{}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(snippets)), ", ")
+	rows, err := db.Query(`SELECT snippet_id, tag FROM snippet_tags
+	WHERE snippet_id IN (`+placeholders+`) ORDER BY snippet_id, position`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, tag string
+		err := rows.Scan(&id, &tag)
+		if err != nil {
+			return err
+		}
+		i := index[id]
+		snippets[i].Tags = append(snippets[i].Tags, tag)
+	}
+	return rows.Err()
+}
+
+func (m *SnippetModel) ByTag(tag string, q models.PageQuery) (models.Page, error) {
+	return pageSnippets(m.DB, notExpired+` AND `+listed+` AND `+hasTag, []any{now(), tag}, q)
+}
+
+func (m *SnippetModel) TagCounts() ([]models.TagCount, error) {
+	stmt := `SELECT t.tag, COUNT(*) AS n FROM snippet_tags t
+	JOIN snippets s ON s.id = t.snippet_id
+	WHERE ` + notExpired + ` AND ` + listed + `
+	GROUP BY t.tag ORDER BY n DESC, t.tag LIMIT ?`
+	rows, err := m.DB.Query(stmt, now(), models.TagCloudSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []models.TagCount
+	for rows.Next() {
+		var c models.TagCount
+		err := rows.Scan(&c.Name, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func tagsFor(db *sql.DB, snippetID primitive.ObjectID) ([]string, error) {
+	snippets := []models.Snippet{{IDStr: snippetID.Hex()}}
+	err := loadTags(db, snippets)
+	return snippets[0].Tags, err
+}
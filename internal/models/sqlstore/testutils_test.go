@@ -0,0 +1,18 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := Open(SQLite, filepath.Join(t.TempDir(), "test_snippetbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
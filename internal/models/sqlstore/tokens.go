@@ -0,0 +1,98 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const tokenColumns = `id, user_id, name, scope, hash, created, last_used`
+
+func scanToken(row rowScanner) (models.APIToken, error) {
+	var t models.APIToken
+	var id, userID string
+	var lastUsed sql.NullTime
+	err := row.Scan(&id, &userID, &t.Name, &t.Scope, &t.Hash, &t.Created, &lastUsed)
+	if err != nil {
+		return models.APIToken{}, err
+	}
+	t.LastUsed = lastUsed.Time
+	t.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return models.APIToken{}, err
+	}
+	t.UserID, err = primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return models.APIToken{}, err
+	}
+	return t, nil
+}
+
+func (m *UserModel) AddToken(token models.APIToken) error {
+	stmt := `INSERT INTO api_tokens (id, user_id, name, scope, hash, created) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := m.DB.Exec(stmt, token.ID.Hex(), token.UserID.Hex(), token.Name, token.Scope, token.Hash, token.Created)
+	return err
+}
+
+func (m *UserModel) Tokens(userID primitive.ObjectID) ([]models.APIToken, error) {
+	rows, err := m.DB.Query(`SELECT `+tokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY id DESC`, userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (m *UserModel) RevokeToken(userID, tokenID primitive.ObjectID) error {
+	result, err := m.DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, tokenID.Hex(), userID.Hex())
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+func (m *UserModel) AuthenticateToken(plaintext string) (models.APIToken, string, error) {
+	row := m.DB.QueryRow(`SELECT `+tokenColumns+` FROM api_tokens WHERE hash = ?`, models.HashToken(plaintext))
+	token, err := scanToken(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIToken{}, "", models.ErrInvalidCredentials
+		}
+		return models.APIToken{}, "", err
+	}
+	var name string
+	err = m.DB.QueryRow(`SELECT name FROM users WHERE id = ?`, token.UserID.Hex()).Scan(&name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIToken{}, "", models.ErrInvalidCredentials
+		}
+		return models.APIToken{}, "", err
+	}
+	token.LastUsed = now()
+	_, err = m.DB.Exec(`UPDATE api_tokens SET last_used = ? WHERE id = ?`, token.LastUsed, token.ID.Hex())
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+	return token, name, nil
+}
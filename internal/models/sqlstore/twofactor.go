@@ -0,0 +1,149 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *UserModel) EnableTOTP(id primitive.ObjectID, secret string, recoveryCodes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET totp_secret = ?, totp_step = 0 WHERE id = ?`, secret, id.Hex())
+	if err != nil {
+		return err
+	}
+	err = requireRow(result)
+	if err != nil {
+		return err
+	}
+	err = replaceRecoveryCodes(tx, id, recoveryCodes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *UserModel) DisableTOTP(id primitive.ObjectID) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, id.Hex()).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return models.ErrNoRecord
+	}
+	_, err = tx.Exec(`UPDATE users SET totp_secret = '', totp_step = 0 WHERE id = ?`, id.Hex())
+	if err != nil {
+		return err
+	}
+	err = replaceRecoveryCodes(tx, id, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for the time step was accepted. The
+// condition on the current step makes the check and the update a single
+// atomic statement.
+func (m *UserModel) UseTOTPStep(id primitive.ObjectID, step int64) error {
+	result, err := m.DB.Exec(`UPDATE users SET totp_step = ? WHERE id = ? AND totp_step < ?`, step, id.Hex(), step)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrCodeUsed
+	}
+	return nil
+}
+
+func (m *UserModel) UseRecoveryCode(id primitive.ObjectID, hash string) error {
+	result, err := m.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`, id.Hex(), hash)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (m *UserModel) SetRecoveryCodes(id primitive.ObjectID, recoveryCodes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, id.Hex()).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return models.ErrNoRecord
+	}
+	err = replaceRecoveryCodes(tx, id, recoveryCodes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *UserModel) recoveryCodes(userIDStr string) ([]string, error) {
+	rows, err := m.DB.Query(`SELECT hash FROM recovery_codes WHERE user_id = ?`, userIDStr)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		err := rows.Scan(&hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, id primitive.ObjectID, recoveryCodes []string) error {
+	_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, id.Hex())
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodes {
+		_, err := tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES (?, ?)`, id.Hex(), hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireRow returns ErrNoRecord unless the statement affected a row.
+func requireRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UserModel struct {
+	DB *sql.DB
+}
+
+func (m *UserModel) Insert(name, email, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO users (id, name, email, hashed_password, created) VALUES (?, ?, ?, ?, ?)`
+	_, err = m.DB.Exec(stmt, primitive.NewObjectID().Hex(), name, email, string(hashedPassword), time.Now().UTC())
+	if err != nil {
+		if isDuplicateKey(err) {
+			return models.ErrDuplicateEmail
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *UserModel) Authenticate(email, password string) (primitive.ObjectID, string, error) {
+	var idStr, name, hashedPassword string
+	stmt := `SELECT id, name, hashed_password FROM users WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&idStr, &name, &hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			models.CompareDummyPassword(password)
+			return primitive.NilObjectID, "", models.ErrInvalidCredentials
+		}
+		return primitive.NilObjectID, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return primitive.NilObjectID, "", models.ErrInvalidCredentials
+		}
+		return primitive.NilObjectID, "", err
+	}
+	id, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+	return id, name, nil
+}
+
+func (m *UserModel) Exists(id primitive.ObjectID) (bool, error) {
+	var exists bool
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, id.Hex()).Scan(&exists)
+	return exists, err
+}
+
+const userColumns = `id, name, email, hashed_password, email_verified, totp_secret, totp_step, created`
+
+// GetByID returns the user without their snippets and favourites.
+func (m *UserModel) GetByID(id primitive.ObjectID) (models.User, error) {
+	return m.getOne(`SELECT `+userColumns+` FROM users WHERE id = ?`, id.Hex())
+}
+
+func (m *UserModel) GetByEmail(email string) (models.User, error) {
+	return m.getOne(`SELECT `+userColumns+` FROM users WHERE email = ?`, email)
+}
+
+func (m *UserModel) getOne(stmt string, args ...any) (models.User, error) {
+	var user models.User
+	err := m.DB.QueryRow(stmt, args...).Scan(&user.IDStr, &user.Name, &user.Email, &user.HashedPassword, &user.EmailVerified, &user.TOTPSecret, &user.TOTPStep, &user.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, models.ErrNoRecord
+		}
+		return models.User{}, err
+	}
+	user.ID, err = primitive.ObjectIDFromHex(user.IDStr)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.RecoveryCodes, err = m.recoveryCodes(user.IDStr)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// SetEmailVerified marks email as verified for the user, provided it is
+// still their address; ErrNoRecord is returned otherwise. MySQL only counts
+// rows an UPDATE changed, so the match is checked up front.
+func (m *UserModel) SetEmailVerified(id primitive.ObjectID, email string) error {
+	var exists bool
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND email = ?)`, id.Hex(), email).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return models.ErrNoRecord
+	}
+	_, err = m.DB.Exec(`UPDATE users SET email_verified = TRUE WHERE id = ? AND email = ?`, id.Hex(), email)
+	return err
+}
+
+func (m *UserModel) SetPassword(id primitive.ObjectID, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+	result, err := m.DB.Exec(`UPDATE users SET hashed_password = ? WHERE id = ?`, string(hashedPassword), id.Hex())
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+func (m *UserModel) Get(id primitive.ObjectID, viewerIDStr string, created models.PageQuery) (models.User, error) {
+	var user models.User
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id.Hex()).Scan(&user.IDStr, &user.Name, &user.Email, &user.HashedPassword, &user.EmailVerified, &user.TOTPSecret, &user.TOTPStep, &user.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, models.ErrNoRecord
+		}
+		return models.User{}, err
+	}
+	user.ID = id
+	user.RecoveryCodes, err = m.recoveryCodes(user.IDStr)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// Users see all they may on their own account, others only what is
+	// public.
+	favouritesWhere := `f.user_id = ? AND ` + notExpired + ` AND (s.visibility <> 'private' OR s.author_id = f.user_id)`
+	createdWhere := `s.author_id = ? AND ` + notExpired
+	if viewerIDStr != user.IDStr {
+		favouritesWhere = `f.user_id = ? AND ` + notExpired + ` AND ` + listed
+		createdWhere += ` AND ` + listed
+	}
+	stmt = `SELECT ` + snippetColumns + ` FROM snippets s
+	JOIN favourites f ON f.snippet_id = s.id
+	WHERE ` + favouritesWhere + ` ORDER BY f.created`
+	user.Favourites, err = querySnippets(m.DB, stmt, user.IDStr, now())
+	if err != nil {
+		return models.User{}, err
+	}
+	page, err := pageSnippets(m.DB, createdWhere, []any{user.IDStr, now()}, created)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.CreatedSnippets = page.Snippets
+	user.CreatedNext = page.Next
+	user.CreatedPrev = page.Prev
+	return user, nil
+}
+
+func (m *UserModel) AddFavourites(SnippetID primitive.ObjectID, ID primitive.ObjectID) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	stmt := `SELECT EXISTS(SELECT 1 FROM snippets s WHERE s.id = ? AND ` + notExpired + `
+	AND (s.visibility <> 'private' OR s.author_id = ?))`
+	err = tx.QueryRow(stmt, SnippetID.Hex(), now(), ID.Hex()).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return models.ErrNoRecord
+	}
+	stmt = `INSERT INTO favourites (user_id, snippet_id, created) VALUES (?, ?, ?)`
+	_, err = tx.Exec(stmt, ID.Hex(), SnippetID.Hex(), time.Now().UTC())
+	if err != nil {
+		if isDuplicateKey(err) {
+			return models.ErrAlreadyFavourite
+		}
+		return err
+	}
+	_, err = tx.Exec(`UPDATE snippets SET favourited = favourited + 1 WHERE id = ?`, SnippetID.Hex())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *UserModel) RemoveFavourites(Snippet models.Snippet, SnippetID primitive.ObjectID, ID primitive.ObjectID) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM favourites WHERE user_id = ? AND snippet_id = ?`, ID.Hex(), SnippetID.Hex())
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		_, err = tx.Exec(`UPDATE snippets SET favourited = favourited - 1 WHERE id = ?`, SnippetID.Hex())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
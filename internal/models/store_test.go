@@ -0,0 +1,83 @@
+package models_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"snippetbox/internal/models"
+	"snippetbox/internal/models/storetest"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestStore runs against the MongoDB server at SNIPPETBOX_TEST_MONGODB_URI,
+// in a throwaway database per test.
+func TestStore(t *testing.T) {
+	uri := os.Getenv("SNIPPETBOX_TEST_MONGODB_URI")
+	if uri == "" || testing.Short() {
+		t.Skip("models: set SNIPPETBOX_TEST_MONGODB_URI to run the MongoDB integration tests")
+	}
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Disconnect(context.TODO())
+	})
+
+	storetest.Run(t, func(t *testing.T) storetest.Stores {
+		db := client.Database("test_snippetbox_" + primitive.NewObjectID().Hex())
+		t.Cleanup(func() {
+			db.Drop(context.TODO())
+		})
+		err := models.Migrate(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storetest.Stores{
+			Snippets:   &models.SnippetModel{DB: db},
+			Users:      &models.UserModel{DB: db},
+			Commentary: &models.CommentaryModel{DB: db},
+			Expire: func(id primitive.ObjectID) error {
+				past := time.Now().UTC().Add(-time.Minute)
+				_, err := db.Collection("snippets").UpdateOne(context.TODO(),
+					bson.M{"_id": id}, bson.M{"$set": bson.M{"expires": past}})
+				if err != nil {
+					return err
+				}
+				opts := options.Update().SetArrayFilters(options.ArrayFilters{
+					Filters: []any{bson.M{"f._id": id}},
+				})
+				_, err = db.Collection("users").UpdateMany(context.TODO(),
+					bson.M{"favourites._id": id}, bson.M{"$set": bson.M{"favourites.$[f].expires": past}}, opts)
+				return err
+			},
+			Count: func(what string) (int, error) {
+				if what != "favourites" {
+					n, err := db.Collection(what).CountDocuments(context.TODO(), bson.M{})
+					return int(n), err
+				}
+				// Favourites are embedded in the users.
+				cur, err := db.Collection("users").Aggregate(context.TODO(), bson.A{
+					bson.M{"$group": bson.M{"_id": nil, "n": bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": bson.A{"$favourites", bson.A{}}}}}}},
+				})
+				if err != nil {
+					return 0, err
+				}
+				var result []struct {
+					N int `bson:"n"`
+				}
+				err = cur.All(context.TODO(), &result)
+				if err != nil || len(result) == 0 {
+					return 0, err
+				}
+				return result[0].N, nil
+			},
+		}
+	})
+}
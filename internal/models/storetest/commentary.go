@@ -0,0 +1,115 @@
+package storetest
+
+import (
+	"errors"
+	"testing"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testCommentaryThreads(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	commentary := st.Commentary
+	aliceID, bobID, eveID := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	id, err := snippets.Insert("Title", "Content", nil, "", "", "Alice", aliceID, 0)
+	assert.NilError(t, err)
+	otherID, err := snippets.Insert("Other", "Content", nil, "", "", "Alice", aliceID, 0)
+	assert.NilError(t, err)
+
+	first, err := commentary.AddComentary(id, primitive.NilObjectID, map[string]string{"Bob": bobID}, "First", false)
+	assert.NilError(t, err)
+	reply, err := commentary.AddComentary(id, first, map[string]string{"Alice": aliceID}, "Reply", false)
+	assert.NilError(t, err)
+	_, err = commentary.AddComentary(id, reply, map[string]string{"Bob": bobID}, "Reply to reply", false)
+	assert.NilError(t, err)
+	second, err := commentary.AddComentary(id, primitive.NilObjectID, map[string]string{"Eve": eveID}, "Second", false)
+	assert.NilError(t, err)
+	// Replies must answer a comment on the same snippet.
+	_, err = commentary.AddComentary(otherID, first, map[string]string{"Bob": bobID}, "Elsewhere", false)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	page, err := commentary.Commentaries(id, models.CommentQuery{})
+	assert.NilError(t, err)
+	threads := models.Threads(page.Commentaries)
+	assert.Equal(t, len(threads), 2)
+	assert.Equal(t, threads[0].ID, second)
+	assert.Equal(t, threads[1].ID, first)
+	assert.Equal(t, threads[1].Replies[0].ID, reply)
+	assert.Equal(t, threads[1].Replies[0].Replies[0].Content, "Reply to reply")
+	snippet, err := snippets.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Commented, 4)
+
+	err = commentary.UpdateComentary(id, first, eveID, "Defaced", false)
+	assert.Equal(t, errors.Is(err, models.ErrNotAuthor), true)
+	err = commentary.UpdateComentary(id, primitive.NewObjectID(), bobID, "Gone", false)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	assert.NilError(t, commentary.UpdateComentary(id, first, bobID, "*First*", true))
+	comment, err := commentary.Commentary(id, first)
+	assert.NilError(t, err)
+	assert.Equal(t, comment.Content, "*First*")
+	assert.Equal(t, comment.Markdown, true)
+	assert.Equal(t, comment.Edited.IsZero(), false)
+	_, err = commentary.Commentary(otherID, first)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	// The author of the snippet may delete any comment on it, and the
+	// replies go with it.
+	err = commentary.DeleteComentary(id, first, eveID)
+	assert.Equal(t, errors.Is(err, models.ErrNotAuthor), true)
+	assert.NilError(t, commentary.DeleteComentary(id, first, aliceID))
+	page, err = commentary.Commentaries(id, models.CommentQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Commentaries), 1)
+	assert.Equal(t, page.Commentaries[0].ID, second)
+	snippet, err = snippets.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Commented, 1)
+}
+
+func testCommentaryPages(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	commentary := st.Commentary
+	id, err := snippets.Insert("Title", "Content", nil, "", "", "Alice", primitive.NewObjectID().Hex(), 0)
+	assert.NilError(t, err)
+	var threads []primitive.ObjectID
+	for i := 0; i < 5; i++ {
+		thread, err := commentary.AddComentary(id, primitive.NilObjectID, map[string]string{"Bob": ""}, "Thread", false)
+		assert.NilError(t, err)
+		_, err = commentary.AddComentary(id, thread, map[string]string{"Bob": ""}, "Reply", false)
+		assert.NilError(t, err)
+		threads = append(threads, thread)
+	}
+
+	// Newest thread first, followed by the replies to them, oldest first.
+	page, err := commentary.Commentaries(id, models.CommentQuery{Limit: 2})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Commentaries), 4)
+	assert.Equal(t, page.Commentaries[0].ID, threads[4])
+	assert.Equal(t, page.Commentaries[1].ID, threads[3])
+	assert.Equal(t, page.Commentaries[2].ParentID, threads[3])
+	assert.Equal(t, page.Commentaries[3].ParentID, threads[4])
+	assert.Equal(t, page.Prev, "")
+
+	page, err = commentary.Commentaries(id, models.CommentQuery{Limit: 2, After: page.Next})
+	assert.NilError(t, err)
+	assert.Equal(t, page.Commentaries[0].ID, threads[2])
+	assert.Equal(t, page.Commentaries[1].ID, threads[1])
+	page, err = commentary.Commentaries(id, models.CommentQuery{Limit: 2, After: page.Next})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Commentaries), 2)
+	assert.Equal(t, page.Commentaries[0].ID, threads[0])
+	assert.Equal(t, page.Next, "")
+
+	page, err = commentary.Commentaries(id, models.CommentQuery{Limit: 2, Before: page.Prev})
+	assert.NilError(t, err)
+	assert.Equal(t, page.Commentaries[0].ID, threads[2])
+	assert.Equal(t, page.Commentaries[1].ID, threads[1])
+	assert.Equal(t, page.Prev, threads[2].Hex())
+
+	_, err = commentary.Commentaries(id, models.CommentQuery{After: "nope"})
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCursor), true)
+}
@@ -0,0 +1,432 @@
+package storetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testSnippetGet(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	commentary := st.Commentary
+	authorID := primitive.NewObjectID().Hex()
+
+	id, err := snippets.Insert("An old silent pond", "An old silent pond...", []string{"haiku"}, "plaintext", "", "Alice", authorID, 0)
+	assert.NilError(t, err)
+	_, err = commentary.AddComentary(id, primitive.NilObjectID, map[string]string{"Bob": primitive.NewObjectID().Hex()}, "*Nice*", true)
+	assert.NilError(t, err)
+
+	snippet, err := snippets.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.ID, id)
+	assert.Equal(t, snippet.IDStr, id.Hex())
+	assert.Equal(t, snippet.Title, "An old silent pond")
+	assert.Equal(t, snippet.Language, "plaintext")
+	assert.Equal(t, snippet.Author["Alice"], authorID)
+	assert.Equal(t, snippet.Created.IsZero(), false)
+	assert.Equal(t, snippet.Commented, 1)
+	page, err := commentary.Commentaries(id, models.CommentQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Commentaries), 1)
+	assert.Equal(t, page.Commentaries[0].Content, "*Nice*")
+	assert.Equal(t, page.Commentaries[0].Markdown, true)
+
+	_, err = snippets.Get(primitive.NewObjectID())
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	_, err = commentary.AddComentary(primitive.NewObjectID(), primitive.NilObjectID, map[string]string{"Bob": ""}, "Nice", false)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+}
+
+func testSnippetLatest(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	commentary := st.Commentary
+	var ids []primitive.ObjectID
+	for i := 0; i < 12; i++ {
+		id, err := snippets.Insert("Title", "Content", []string{"tag"}, "", "", "Alice", primitive.NewObjectID().Hex(), 0)
+		assert.NilError(t, err)
+		ids = append(ids, id)
+	}
+	userID := addUser(t, st, "Carol", "carol@example.com")
+	err := st.Users.AddFavourites(ids[4], userID)
+	assert.NilError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = commentary.AddComentary(ids[7], primitive.NilObjectID, map[string]string{"Bob": ""}, "Nice", false)
+		assert.NilError(t, err)
+	}
+
+	tests := []struct {
+		sort  string
+		first primitive.ObjectID
+	}{
+		{models.SortNewest, ids[11]},
+		{models.SortFavourited, ids[4]},
+		{models.SortCommented, ids[7]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			q := models.PageQuery{Sort: tt.sort, Limit: 5}
+			first, err := snippets.Latest(q)
+			assert.NilError(t, err)
+			assert.Equal(t, len(first.Snippets), 5)
+			assert.Equal(t, first.Snippets[0].ID, tt.first)
+			assert.Equal(t, first.Prev, "")
+
+			seen := map[primitive.ObjectID]bool{}
+			page := first
+			for pages := 1; ; pages++ {
+				for _, s := range page.Snippets {
+					assert.Equal(t, seen[s.ID], false)
+					seen[s.ID] = true
+				}
+				if page.Next == "" {
+					assert.Equal(t, pages, 3)
+					break
+				}
+				q.After = page.Next
+				page, err = snippets.Latest(q)
+				assert.NilError(t, err)
+			}
+			assert.Equal(t, len(seen), 12)
+
+			q.After = ""
+			q.Before = page.Prev
+			page, err = snippets.Latest(q)
+			assert.NilError(t, err)
+			q.Before = page.Prev
+			page, err = snippets.Latest(q)
+			assert.NilError(t, err)
+			assert.Equal(t, page.Prev, "")
+			assert.Equal(t, page.Snippets[0].ID, first.Snippets[0].ID)
+			assert.Equal(t, page.Snippets[4].ID, first.Snippets[4].ID)
+		})
+	}
+
+	_, err = snippets.Latest(models.PageQuery{After: "bogus"})
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCursor), true)
+}
+
+func testSnippetSearch(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	commentary := st.Commentary
+	pondID, err := snippets.Insert("Old pond", "A frog jumps in", []string{"haiku"}, "", "", "Alice", primitive.NewObjectID().Hex(), 0)
+	assert.NilError(t, err)
+	frogID, err := snippets.Insert("Frogs", "Frog frog frog", []string{"poem"}, "", "", "Bob", primitive.NewObjectID().Hex(), 0)
+	assert.NilError(t, err)
+	_, err = snippets.Insert("Autumn", "Leaves fall", []string{"haiku"}, "", "", "Alice", primitive.NewObjectID().Hex(), 0)
+	assert.NilError(t, err)
+	tadpoleID, err := snippets.Insert("Tadpoles", "Tadpoles swim", nil, "", "", "j.doe", primitive.NewObjectID().Hex(), 0)
+	assert.NilError(t, err)
+	_, err = commentary.AddComentary(pondID, primitive.NilObjectID, map[string]string{"Bob": ""}, "Splash 100%", false)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name  string
+		query models.SearchQuery
+		want  []primitive.ObjectID
+	}{
+		{"Ranked", models.SearchQuery{Terms: []string{"frog"}}, []primitive.ObjectID{frogID, pondID}},
+		{"Comments", models.SearchQuery{Terms: []string{"splash"}}, []primitive.ObjectID{pondID}},
+		{"Wildcards are literal", models.SearchQuery{Terms: []string{"0%"}}, []primitive.ObjectID{pondID}},
+		{"Tag", models.SearchQuery{Terms: []string{"frog"}, Tag: "haiku"}, []primitive.ObjectID{pondID}},
+		{"Author", models.SearchQuery{Terms: []string{"frog"}, Author: "Bob"}, []primitive.ObjectID{frogID}},
+		{"Dotted author", models.SearchQuery{Terms: []string{"tadpoles"}, Author: "j.doe"}, []primitive.ObjectID{tadpoleID}},
+		{"Future", models.SearchQuery{Terms: []string{"frog"}, From: time.Now().Add(time.Hour)}, nil},
+		{"Past", models.SearchQuery{Terms: []string{"frog"}, To: time.Now().Add(-time.Hour)}, nil},
+		{"No match", models.SearchQuery{Terms: []string{"toad"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := snippets.Search(tt.query)
+			assert.NilError(t, err)
+			var got []primitive.ObjectID
+			for _, r := range results {
+				got = append(got, r.Snippet.ID)
+			}
+			assert.Equal(t, len(got), len(tt.want))
+			for i := range tt.want {
+				assert.Equal(t, got[i], tt.want[i])
+			}
+		})
+	}
+}
+
+func testSnippetTags(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	pondID, err := snippets.Insert("Old pond", "A frog jumps in", []string{"haiku", "nature"}, "", "", "Alice", primitive.NewObjectID().Hex(), 0)
+	assert.NilError(t, err)
+	_, err = snippets.Insert("Autumn", "Leaves fall", []string{"haiku"}, "", "", "Alice", primitive.NewObjectID().Hex(), 0)
+	assert.NilError(t, err)
+
+	page, err := snippets.ByTag("nature", models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Snippets), 1)
+	assert.Equal(t, page.Snippets[0].ID, pondID)
+	assert.Equal(t, len(page.Snippets[0].Tags), 2)
+	counts, err := snippets.TagCounts()
+	assert.NilError(t, err)
+	assert.Equal(t, len(counts), 2)
+	assert.Equal(t, counts[0], models.TagCount{Name: "haiku", Count: 2})
+	assert.Equal(t, counts[1], models.TagCount{Name: "nature", Count: 1})
+
+	author := page.Snippets[0].AuthorID()
+	assert.NilError(t, snippets.Update(pondID, author, "Old pond", "A frog jumps in", []string{"frog"}, ""))
+	snippet, err := snippets.Get(pondID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippet.Tags), 1)
+	assert.Equal(t, snippet.Tags[0], "frog")
+	page, err = snippets.ByTag("nature", models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Snippets), 0)
+}
+
+func testSnippetUpdateDelete(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	users := st.Users
+	commentary := st.Commentary
+	authorID := addUser(t, st, "Alice", "alice@example.com")
+	id, err := snippets.Insert("Title", "Content", []string{"tag"}, "", "", "Alice", authorID.Hex(), 0)
+	assert.NilError(t, err)
+	assert.NilError(t, users.AddFavourites(id, authorID))
+	_, err = commentary.AddComentary(id, primitive.NilObjectID, map[string]string{"Alice": authorID.Hex()}, "First", false)
+	assert.NilError(t, err)
+
+	err = snippets.Update(id, primitive.NewObjectID().Hex(), "Stolen", "Content", []string{"tag"}, "")
+	assert.Equal(t, errors.Is(err, models.ErrNotAuthor), true)
+	err = snippets.Update(primitive.NewObjectID(), authorID.Hex(), "Title", "Content", []string{"tag"}, "")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	assert.NilError(t, snippets.Update(id, authorID.Hex(), "New title", "New content", []string{"new"}, "go"))
+	snippet, err := snippets.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Title, "New title")
+	assert.Equal(t, snippet.Language, "go")
+	user, err := users.Get(authorID, authorID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, user.Favourites[0].Title, "New title")
+	assert.Equal(t, user.Favourites[0].Language, "go")
+
+	err = snippets.Delete(id, primitive.NewObjectID().Hex())
+	assert.Equal(t, errors.Is(err, models.ErrNotAuthor), true)
+	assert.NilError(t, snippets.Delete(id, authorID.Hex()))
+	_, err = snippets.Get(id)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	user, err = users.Get(authorID, authorID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(user.Favourites), 0)
+	assert.Equal(t, count(t, st, "commentaries"), 0)
+}
+
+// testSnippetAuthorNames checks that names which read as field paths still
+// credit the snippets to their authors.
+func testSnippetAuthorNames(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	users := st.Users
+	for _, name := range []string{"j.doe", "$admin"} {
+		authorID := addUser(t, st, name, name+"@example.com")
+		id, err := snippets.Insert("Title", "Content", []string{"tag"}, "", "", name, authorID.Hex(), 0)
+		assert.NilError(t, err)
+
+		user, err := users.Get(authorID, authorID.Hex(), models.PageQuery{})
+		assert.NilError(t, err)
+		assert.Equal(t, len(user.CreatedSnippets), 1)
+		assert.NilError(t, snippets.Update(id, authorID.Hex(), "New title", "New content", []string{"new"}, ""))
+		snippet, err := snippets.Get(id)
+		assert.NilError(t, err)
+		assert.Equal(t, snippet.Title, "New title")
+		assert.Equal(t, snippet.AuthorName(), name)
+
+		assert.NilError(t, snippets.Delete(id, authorID.Hex()))
+		_, err = snippets.Get(id)
+		assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	}
+}
+
+func testSnippetRevisions(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	authorID := addUser(t, st, "Alice", "alice@example.com")
+
+	id, err := snippets.Insert("Title", "Content", []string{"tag"}, "", "", "Alice", authorID.Hex(), 0)
+	assert.NilError(t, err)
+	assert.NilError(t, snippets.Update(id, authorID.Hex(), "New title", "New content", []string{"new", "tags"}, "go"))
+
+	revisions, err := snippets.Revisions(id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions), 2)
+	assert.Equal(t, revisions[0].Number, 2)
+	assert.Equal(t, revisions[0].Title, "New title")
+	assert.Equal(t, len(revisions[0].Tags), 2)
+	assert.Equal(t, revisions[0].Language, "go")
+	assert.Equal(t, revisions[0].AuthorName(), "Alice")
+	assert.Equal(t, revisions[1].Number, 1)
+	assert.Equal(t, revisions[1].Content, "Content")
+
+	err = snippets.Restore(id, primitive.NewObjectID().Hex(), 1)
+	assert.Equal(t, errors.Is(err, models.ErrNotAuthor), true)
+	err = snippets.Restore(id, authorID.Hex(), 3)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	assert.NilError(t, snippets.Restore(id, authorID.Hex(), 1))
+	snippet, err := snippets.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Title, "Title")
+	assert.Equal(t, snippet.Tags[0], "tag")
+	restored, err := snippets.Revision(id, 3)
+	assert.NilError(t, err)
+	assert.Equal(t, restored.RestoredFrom, 1)
+	assert.Equal(t, restored.Content, "Content")
+
+	assert.NilError(t, snippets.Delete(id, authorID.Hex()))
+	_, err = snippets.Revisions(id)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	assert.Equal(t, count(t, st, "revisions"), 0)
+}
+
+func testSnippetFork(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	aliceID := addUser(t, st, "Alice", "alice@example.com")
+	bobID := addUser(t, st, "Bob", "bob@example.com")
+
+	parentID, err := snippets.Insert("Title", "Content", []string{"tag"}, "go", "", "Alice", aliceID.Hex(), 0)
+	assert.NilError(t, err)
+
+	_, err = snippets.Fork(primitive.NewObjectID(), "Bob", bobID.Hex())
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	forkID, err := snippets.Fork(parentID, "Bob", bobID.Hex())
+	assert.NilError(t, err)
+
+	fork, err := snippets.Get(forkID)
+	assert.NilError(t, err)
+	assert.Equal(t, fork.ForkedFrom, parentID)
+	assert.Equal(t, fork.AuthorID(), bobID.Hex())
+	assert.Equal(t, fork.Content, "Content")
+	assert.Equal(t, fork.Language, "go")
+	assert.Equal(t, fork.Tags[0], "tag")
+	revisions, err := snippets.Revisions(forkID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions), 1)
+	parent, err := snippets.Get(parentID)
+	assert.NilError(t, err)
+	assert.Equal(t, parent.ForkedFrom.IsZero(), true)
+
+	forks, err := snippets.Forks(parentID, "")
+	assert.NilError(t, err)
+	assert.Equal(t, len(forks), 1)
+	assert.Equal(t, forks[0].ID, forkID)
+
+	// Forks outlive their parent.
+	assert.NilError(t, snippets.Delete(parentID, aliceID.Hex()))
+	fork, err = snippets.Get(forkID)
+	assert.NilError(t, err)
+	assert.Equal(t, fork.ForkedFrom, parentID)
+}
+
+func testSnippetVisibility(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	users := st.Users
+	aliceID := addUser(t, st, "Alice", "alice@example.com")
+	bobID := addUser(t, st, "Bob", "bob@example.com")
+
+	publicID, err := snippets.Insert("Public", "Content", []string{"tag"}, "", "", "Alice", aliceID.Hex(), 0)
+	assert.NilError(t, err)
+	unlistedID, err := snippets.Insert("Unlisted", "Content", []string{"tag"}, "", models.VisibilityUnlisted, "Alice", aliceID.Hex(), 0)
+	assert.NilError(t, err)
+	privateID, err := snippets.Insert("Private", "Content", []string{"tag"}, "", models.VisibilityPrivate, "Alice", aliceID.Hex(), 0)
+	assert.NilError(t, err)
+
+	public, err := snippets.Get(publicID)
+	assert.NilError(t, err)
+	assert.Equal(t, public.Visibility, models.VisibilityPublic)
+	page, err := snippets.Latest(models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Snippets), 1)
+	assert.Equal(t, page.Snippets[0].ID, publicID)
+	page, err = snippets.ByTag("tag", models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Snippets), 1)
+	counts, err := snippets.TagCounts()
+	assert.NilError(t, err)
+	assert.Equal(t, counts[0].Count, 1)
+	results, err := snippets.Search(models.SearchQuery{Terms: []string{"content"}})
+	assert.NilError(t, err)
+	assert.Equal(t, len(results), 1)
+
+	_, err = snippets.GetVisible(unlistedID, "")
+	assert.NilError(t, err)
+	_, err = snippets.GetVisible(privateID, bobID.Hex())
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	_, err = snippets.GetVisible(privateID, aliceID.Hex())
+	assert.NilError(t, err)
+	_, err = snippets.Fork(privateID, "Bob", bobID.Hex())
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	// Forks keep their visibility and are only listed to their author
+	// unless public.
+	forkID, err := snippets.Fork(unlistedID, "Bob", bobID.Hex())
+	assert.NilError(t, err)
+	fork, err := snippets.Get(forkID)
+	assert.NilError(t, err)
+	assert.Equal(t, fork.Visibility, models.VisibilityUnlisted)
+	forks, err := snippets.Forks(unlistedID, aliceID.Hex())
+	assert.NilError(t, err)
+	assert.Equal(t, len(forks), 0)
+	forks, err = snippets.Forks(unlistedID, bobID.Hex())
+	assert.NilError(t, err)
+	assert.Equal(t, len(forks), 1)
+
+	err = users.AddFavourites(privateID, bobID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	assert.NilError(t, users.AddFavourites(unlistedID, bobID))
+	assert.NilError(t, users.AddFavourites(privateID, aliceID))
+
+	alice, err := users.Get(aliceID, aliceID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(alice.CreatedSnippets), 3)
+	assert.Equal(t, len(alice.Favourites), 1)
+	alice, err = users.Get(aliceID, bobID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(alice.CreatedSnippets), 1)
+	assert.Equal(t, len(alice.Favourites), 0)
+	bob, err := users.Get(bobID, bobID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(bob.Favourites), 1)
+	bob, err = users.Get(bobID, "", models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(bob.Favourites), 0)
+	assert.Equal(t, len(bob.CreatedSnippets), 0)
+}
+
+func testSnippetExpiry(t *testing.T, st Stores) {
+	snippets := st.Snippets
+	users := st.Users
+	userID := addUser(t, st, "Alice", "alice@example.com")
+	liveID, err := snippets.Insert("Live", "Content", []string{"tag"}, "", "", "Alice", userID.Hex(), 0)
+	assert.NilError(t, err)
+	expiredID, err := snippets.Insert("Expired", "Content", []string{"tag"}, "", "", "Alice", userID.Hex(), 1)
+	assert.NilError(t, err)
+	assert.NilError(t, users.AddFavourites(expiredID, userID))
+	assert.NilError(t, st.Expire(expiredID))
+
+	_, err = snippets.Get(expiredID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	live, err := snippets.Get(liveID)
+	assert.NilError(t, err)
+	assert.Equal(t, live.Expires.IsZero(), true)
+	page, err := snippets.Latest(models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Snippets), 1)
+	assert.Equal(t, page.Snippets[0].ID, liveID)
+	user, err := users.Get(userID, userID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(user.CreatedSnippets), 1)
+	assert.Equal(t, len(user.Favourites), 0)
+
+	n, err := snippets.DeleteExpired()
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(1))
+	assert.Equal(t, count(t, st, "snippets"), 1)
+	assert.Equal(t, count(t, st, "favourites"), 0)
+}
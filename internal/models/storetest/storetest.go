@@ -0,0 +1,83 @@
+// Package storetest runs the same tests against every storage backend, so
+// that they keep behaving alike. Each backend's tests call Run with a
+// function that opens an empty database.
+package storetest
+
+import (
+	"testing"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stores is a set of stores sharing one empty database, along with the
+// few things the tests have to do behind the backs of the stores.
+type Stores struct {
+	Snippets   models.SnippetStore
+	Users      models.UserStore
+	Commentary models.CommentaryStore
+	// Expire moves the expiry time of the snippet with the given ID, and of
+	// the copies of it in favourites, into the past.
+	Expire func(id primitive.ObjectID) error
+	// Count returns how many "snippets", "revisions", "commentaries" or
+	// "favourites" are stored.
+	Count func(what string) (int, error)
+}
+
+var tests = []struct {
+	name string
+	run  func(t *testing.T, s Stores)
+}{
+	{"SnippetGet", testSnippetGet},
+	{"SnippetLatest", testSnippetLatest},
+	{"SnippetSearch", testSnippetSearch},
+	{"SnippetTags", testSnippetTags},
+	{"SnippetUpdateDelete", testSnippetUpdateDelete},
+	{"SnippetAuthorNames", testSnippetAuthorNames},
+	{"SnippetRevisions", testSnippetRevisions},
+	{"SnippetFork", testSnippetFork},
+	{"SnippetVisibility", testSnippetVisibility},
+	{"SnippetExpiry", testSnippetExpiry},
+	{"UserExists", testUserExists},
+	{"UserInsertDuplicateEmail", testUserInsertDuplicateEmail},
+	{"UserFavourites", testUserFavourites},
+	{"UserTokens", testUserTokens},
+	{"UserVerifyAndSetPassword", testUserVerifyAndSetPassword},
+	{"UserTwoFactor", testUserTwoFactor},
+	{"UserAuditLog", testUserAuditLog},
+	{"UserSetNameAndEmail", testUserSetNameAndEmail},
+	{"UserDeleteAnonymize", testUserDeleteAnonymize("Alice")},
+	{"UserDeleteAnonymizeDottedName", testUserDeleteAnonymize("$al.ice")},
+	{"UserDeleteRemove", testUserDeleteRemove("Alice")},
+	{"UserDeleteRemoveDottedName", testUserDeleteRemove("$al.ice")},
+	{"CommentaryThreads", testCommentaryThreads},
+	{"CommentaryPages", testCommentaryPages},
+}
+
+// Run runs every test against the stores open returns, a fresh set each.
+func Run(t *testing.T, open func(t *testing.T) Stores) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+}
+
+// addUser signs up a user and returns their ID.
+func addUser(t *testing.T, st Stores, name, email string) primitive.ObjectID {
+	t.Helper()
+	assert.NilError(t, st.Users.Insert(name, email, "pa$$word"))
+	id, _, err := st.Users.Authenticate(email, "pa$$word")
+	assert.NilError(t, err)
+	return id
+}
+
+// count returns the result of st.Count.
+func count(t *testing.T, st Stores, what string) int {
+	t.Helper()
+	n, err := st.Count(what)
+	assert.NilError(t, err)
+	return n
+}
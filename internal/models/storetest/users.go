@@ -0,0 +1,376 @@
+package storetest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testUserExists(t *testing.T, st Stores) {
+	m := st.Users
+	id := addUser(t, st, "Alice", "alice@example.com")
+
+	tests := []struct {
+		name   string
+		userID primitive.ObjectID
+		want   bool
+	}{
+		{
+			name:   "Valid ID",
+			userID: id,
+			want:   true,
+		},
+		{
+			name:   "Zero ID",
+			userID: primitive.NilObjectID,
+			want:   false,
+		},
+		{
+			name:   "Non-existent ID",
+			userID: primitive.NewObjectID(),
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exists, err := m.Exists(tt.userID)
+			assert.Equal(t, exists, tt.want)
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func testUserInsertDuplicateEmail(t *testing.T, st Stores) {
+	m := st.Users
+	assert.NilError(t, m.Insert("Alice", "alice@example.com", "pa$$word"))
+	err := m.Insert("Bob", "alice@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrDuplicateEmail), true)
+}
+
+func testUserFavourites(t *testing.T, st Stores) {
+	users := st.Users
+	snippets := st.Snippets
+
+	userID := addUser(t, st, "Alice", "alice@example.com")
+	snippetID, err := snippets.Insert("Title", "Content", []string{"tag"}, "", "", "Alice", userID.Hex(), 0)
+	assert.NilError(t, err)
+
+	assert.NilError(t, users.AddFavourites(snippetID, userID))
+	err = users.AddFavourites(snippetID, userID)
+	assert.Equal(t, errors.Is(err, models.ErrAlreadyFavourite), true)
+	err = users.AddFavourites(primitive.NewObjectID(), userID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	user, err := users.Get(userID, userID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(user.Favourites), 1)
+	assert.Equal(t, len(user.CreatedSnippets), 1)
+	assert.Equal(t, user.CreatedSnippets[0].Author["Alice"], userID.Hex())
+
+	snippet, err := snippets.Get(snippetID)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Favourited, 1)
+
+	assert.NilError(t, users.RemoveFavourites(snippet, snippetID, userID))
+	user, err = users.Get(userID, userID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(user.Favourites), 0)
+	// Removing it again leaves the count alone.
+	assert.NilError(t, users.RemoveFavourites(snippet, snippetID, userID))
+	snippet, err = snippets.Get(snippetID)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Favourited, 0)
+}
+
+func testUserTokens(t *testing.T, st Stores) {
+	m := st.Users
+	userID := addUser(t, st, "Alice", "alice@example.com")
+
+	token, plaintext, err := models.NewAPIToken(userID, "CI", models.ScopeRead)
+	assert.NilError(t, err)
+	assert.NilError(t, m.AddToken(token))
+
+	got, name, err := m.AuthenticateToken(plaintext)
+	assert.NilError(t, err)
+	assert.Equal(t, name, "Alice")
+	assert.Equal(t, got.ID, token.ID)
+	assert.Equal(t, got.CanWrite(), false)
+	tokens, err := m.Tokens(userID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tokens), 1)
+	assert.Equal(t, tokens[0].Hash, models.HashToken(plaintext))
+	assert.Equal(t, tokens[0].LastUsed.IsZero(), false)
+
+	_, _, err = m.AuthenticateToken(plaintext + "x")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+	err = m.RevokeToken(primitive.NewObjectID(), token.ID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	assert.NilError(t, m.RevokeToken(userID, token.ID))
+	_, _, err = m.AuthenticateToken(plaintext)
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+}
+
+func testUserVerifyAndSetPassword(t *testing.T, st Stores) {
+	m := st.Users
+	assert.NilError(t, m.Insert("Alice", "alice@example.com", "pa$$word"))
+	user, err := m.GetByEmail("alice@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, user.Name, "Alice")
+	assert.Equal(t, user.EmailVerified, false)
+
+	err = m.SetEmailVerified(user.ID, "old@example.com")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	assert.NilError(t, m.SetEmailVerified(user.ID, "alice@example.com"))
+	assert.NilError(t, m.SetEmailVerified(user.ID, "alice@example.com"))
+	user, err = m.GetByID(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, user.EmailVerified, true)
+
+	assert.NilError(t, m.SetPassword(user.ID, "new pa$$word"))
+	_, _, err = m.Authenticate("alice@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+	_, _, err = m.Authenticate("alice@example.com", "new pa$$word")
+	assert.NilError(t, err)
+
+	_, err = m.GetByID(primitive.NewObjectID())
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	_, err = m.GetByEmail("bob@example.com")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	err = m.SetPassword(primitive.NewObjectID(), "pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+}
+
+func testUserTwoFactor(t *testing.T, st Stores) {
+	m := st.Users
+	assert.NilError(t, m.Insert("Alice", "alice@example.com", "pa$$word"))
+	user, err := m.GetByEmail("alice@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, user.TOTPSecret, "")
+
+	codes, hashes, err := models.NewRecoveryCodes()
+	assert.NilError(t, err)
+	assert.Equal(t, len(codes), models.RecoveryCodeCount)
+	assert.NilError(t, m.EnableTOTP(user.ID, "JBSWY3DPEHPK3PXP", hashes))
+	user, err = m.GetByID(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, user.TOTPSecret, "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, len(user.RecoveryCodes), models.RecoveryCodeCount)
+
+	assert.NilError(t, m.UseTOTPStep(user.ID, 100))
+	assert.Equal(t, m.UseTOTPStep(user.ID, 100), models.ErrCodeUsed)
+	assert.Equal(t, m.UseTOTPStep(user.ID, 99), models.ErrCodeUsed)
+	assert.NilError(t, m.UseTOTPStep(user.ID, 101))
+
+	hash := models.HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")))
+	assert.NilError(t, m.UseRecoveryCode(user.ID, hash))
+	assert.Equal(t, m.UseRecoveryCode(user.ID, hash), models.ErrNoRecord)
+	user, err = m.GetByID(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(user.RecoveryCodes), models.RecoveryCodeCount-1)
+
+	assert.NilError(t, m.SetRecoveryCodes(user.ID, hashes[:2]))
+	user, err = m.GetByID(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(user.RecoveryCodes), 2)
+
+	assert.NilError(t, m.DisableTOTP(user.ID))
+	user, err = m.GetByID(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, user.TOTPSecret, "")
+	assert.Equal(t, user.TOTPStep, int64(0))
+	assert.Equal(t, len(user.RecoveryCodes), 0)
+	assert.Equal(t, m.DisableTOTP(primitive.NewObjectID()), models.ErrNoRecord)
+}
+
+func testUserAuditLog(t *testing.T, st Stores) {
+	m := st.Users
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, m.AddAuditEntry(models.NewAuditEntry(models.AuditLoginLockout, alice, "alice@example.com", "192.0.2.1")))
+	}
+	assert.NilError(t, m.AddAuditEntry(models.NewAuditEntry(models.AuditLoginLockout, bob, "bob@example.com", "192.0.2.2")))
+	latest := models.NewAuditEntry(models.AuditLoginLockout, alice, "alice@example.com", "192.0.2.3")
+	assert.NilError(t, m.AddAuditEntry(latest))
+
+	entries, err := m.AuditEntries(alice, 2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[0].ID, latest.ID)
+	assert.Equal(t, entries[0].UserID, alice)
+	assert.Equal(t, entries[0].Event, models.AuditLoginLockout)
+	assert.Equal(t, entries[0].IP, "192.0.2.3")
+
+	entries, err = m.AuditEntries(primitive.NewObjectID(), 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+}
+
+func testUserSetNameAndEmail(t *testing.T, st Stores) {
+	users := st.Users
+	snippets := st.Snippets
+	commentary := st.Commentary
+	aliceID := addUser(t, st, "Alice", "alice@example.com")
+	bobID := addUser(t, st, "Bob", "bob@example.com")
+	snippetID, err := snippets.Insert("Title", "Content", nil, "", "", "Alice", aliceID.Hex(), 0)
+	assert.NilError(t, err)
+	commentID, err := commentary.AddComentary(snippetID, primitive.NilObjectID, map[string]string{"Alice": aliceID.Hex()}, "Comment", false)
+	assert.NilError(t, err)
+	assert.NilError(t, users.AddFavourites(snippetID, bobID))
+
+	// Everything Alice wrote follows her new name.
+	assert.NilError(t, users.SetName(aliceID, "Alicia"))
+	user, err := users.Get(aliceID, aliceID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, user.Name, "Alicia")
+	assert.Equal(t, len(user.CreatedSnippets), 1)
+	snippet, err := snippets.Get(snippetID)
+	assert.NilError(t, err)
+	assert.Equal(t, snippet.Author["Alicia"], aliceID.Hex())
+	assert.Equal(t, len(snippet.Author), 1)
+	revisions, err := snippets.Revisions(snippetID)
+	assert.NilError(t, err)
+	assert.Equal(t, revisions[0].AuthorName(), "Alicia")
+	comment, err := commentary.Commentary(snippetID, commentID)
+	assert.NilError(t, err)
+	assert.Equal(t, comment.Author["Alicia"], aliceID.Hex())
+	bob, err := users.Get(bobID, bobID.Hex(), models.PageQuery{})
+	assert.NilError(t, err)
+	assert.Equal(t, bob.Favourites[0].AuthorName(), "Alicia")
+
+	// Names that read as field paths are renamed from as well as to.
+	for _, name := range []string{"$al.ice", "Alice"} {
+		assert.NilError(t, users.SetName(aliceID, name))
+		snippet, err = snippets.Get(snippetID)
+		assert.NilError(t, err)
+		assert.Equal(t, snippet.AuthorName(), name)
+		comment, err = commentary.Commentary(snippetID, commentID)
+		assert.NilError(t, err)
+		assert.Equal(t, comment.Author[name], aliceID.Hex())
+		bob, err = users.Get(bobID, bobID.Hex(), models.PageQuery{})
+		assert.NilError(t, err)
+		assert.Equal(t, bob.Favourites[0].AuthorName(), name)
+	}
+
+	// A new address has to be verified again.
+	err = users.SetEmail(aliceID, "bob@example.com")
+	assert.Equal(t, errors.Is(err, models.ErrDuplicateEmail), true)
+	assert.NilError(t, users.SetEmailVerified(aliceID, "alice@example.com"))
+	assert.NilError(t, users.SetEmail(aliceID, "alicia@example.com"))
+	user, err = users.GetByEmail("alicia@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, user.ID, aliceID)
+	assert.Equal(t, user.EmailVerified, false)
+
+	err = users.SetName(primitive.NewObjectID(), "Eve")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	err = users.SetEmail(primitive.NewObjectID(), "eve@example.com")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+}
+
+// deleteFixture is what testUserDeleteAnonymize and testUserDeleteRemove
+// start from: Alice has a public and a private snippet, a favourite and an
+// API token, and wrote a comment that Bob replied to.
+type deleteFixture struct {
+	aliceID, bobID         primitive.ObjectID
+	publicID, privateID    primitive.ObjectID
+	bobsID, aliceCommentID primitive.ObjectID
+}
+
+func setupDelete(t *testing.T, st Stores, aliceName string) deleteFixture {
+	t.Helper()
+	var f deleteFixture
+	var err error
+	f.aliceID = addUser(t, st, aliceName, "alice@example.com")
+	f.bobID = addUser(t, st, "Bob", "bob@example.com")
+	alice := map[string]string{aliceName: f.aliceID.Hex()}
+	bob := map[string]string{"Bob": f.bobID.Hex()}
+
+	f.publicID, err = st.Snippets.Insert("Public", "Content", nil, "", "", aliceName, f.aliceID.Hex(), 0)
+	assert.NilError(t, err)
+	f.privateID, err = st.Snippets.Insert("Private", "Content", nil, "", models.VisibilityPrivate, aliceName, f.aliceID.Hex(), 0)
+	assert.NilError(t, err)
+	f.bobsID, err = st.Snippets.Insert("Bob's", "Content", nil, "", "", "Bob", f.bobID.Hex(), 0)
+	assert.NilError(t, err)
+	assert.NilError(t, st.Users.AddFavourites(f.publicID, f.bobID))
+	assert.NilError(t, st.Users.AddFavourites(f.bobsID, f.aliceID))
+	f.aliceCommentID, err = st.Commentary.AddComentary(f.bobsID, primitive.NilObjectID, alice, "From Alice", false)
+	assert.NilError(t, err)
+	_, err = st.Commentary.AddComentary(f.bobsID, f.aliceCommentID, bob, "Reply", false)
+	assert.NilError(t, err)
+	_, err = st.Commentary.AddComentary(f.bobsID, primitive.NilObjectID, bob, "From Bob", false)
+	assert.NilError(t, err)
+	token, _, err := models.NewAPIToken(f.aliceID, "CI", models.ScopeRead)
+	assert.NilError(t, err)
+	assert.NilError(t, st.Users.AddToken(token))
+	return f
+}
+
+// checkDeleted checks what goes whether or not Alice's content is kept.
+func checkDeleted(t *testing.T, st Stores, f deleteFixture) {
+	t.Helper()
+	_, err := st.Users.GetByID(f.aliceID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	tokens, err := st.Users.Tokens(f.aliceID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tokens), 0)
+	_, err = st.Snippets.Get(f.privateID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	bobs, err := st.Snippets.Get(f.bobsID)
+	assert.NilError(t, err)
+	assert.Equal(t, bobs.Favourited, 0)
+	err = st.Users.Delete(f.aliceID, false)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+}
+
+// testUserDeleteAnonymize deletes Alice, named name, keeping what she wrote.
+func testUserDeleteAnonymize(name string) func(t *testing.T, st Stores) {
+	return func(t *testing.T, st Stores) {
+		f := setupDelete(t, st, name)
+		assert.NilError(t, st.Users.Delete(f.aliceID, true))
+		checkDeleted(t, st, f)
+		public, err := st.Snippets.Get(f.publicID)
+		assert.NilError(t, err)
+		assert.Equal(t, public.AuthorName(), models.DeletedAuthor)
+		assert.Equal(t, public.AuthorID(), "")
+		revisions, err := st.Snippets.Revisions(f.publicID)
+		assert.NilError(t, err)
+		assert.Equal(t, revisions[0].AuthorName(), models.DeletedAuthor)
+		comment, err := st.Commentary.Commentary(f.bobsID, f.aliceCommentID)
+		assert.NilError(t, err)
+		assert.Equal(t, comment.AuthorID(), "")
+		bobs, err := st.Snippets.Get(f.bobsID)
+		assert.NilError(t, err)
+		assert.Equal(t, bobs.Commented, 3)
+		bob, err := st.Users.Get(f.bobID, f.bobID.Hex(), models.PageQuery{})
+		assert.NilError(t, err)
+		assert.Equal(t, len(bob.Favourites), 1)
+		assert.Equal(t, bob.Favourites[0].AuthorName(), models.DeletedAuthor)
+	}
+}
+
+// testUserDeleteRemove deletes Alice, named name, along with what she wrote.
+func testUserDeleteRemove(name string) func(t *testing.T, st Stores) {
+	return func(t *testing.T, st Stores) {
+		f := setupDelete(t, st, name)
+		assert.NilError(t, st.Users.Delete(f.aliceID, false))
+		checkDeleted(t, st, f)
+		_, err := st.Snippets.Get(f.publicID)
+		assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+		// The reply to Alice's comment goes with it.
+		page, err := st.Commentary.Commentaries(f.bobsID, models.CommentQuery{})
+		assert.NilError(t, err)
+		assert.Equal(t, len(page.Commentaries), 1)
+		assert.Equal(t, page.Commentaries[0].Content, "From Bob")
+		bobs, err := st.Snippets.Get(f.bobsID)
+		assert.NilError(t, err)
+		assert.Equal(t, bobs.Commented, 1)
+		bob, err := st.Users.Get(f.bobID, f.bobID.Hex(), models.PageQuery{})
+		assert.NilError(t, err)
+		assert.Equal(t, len(bob.Favourites), 0)
+	}
+}
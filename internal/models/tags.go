@@ -0,0 +1,84 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxTags      = 10
+	MaxTagLength = 32
+	// TagCloudSize is how many of the most used tags make the tag cloud.
+	TagCloudSize = 30
+)
+
+// ValidTag reports whether tag only uses the characters allowed in tags:
+// letters, digits and any of "_+#.-".
+func ValidTag(tag string) bool {
+	return tag != "" && strings.IndexFunc(tag, func(r rune) bool { return !validTagRune(r) }) < 0
+}
+
+func validTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_+#.-", r)
+}
+
+// SplitTags breaks a tag list as typed by a user, separated by commas or
+// spaces, into its raw parts.
+func SplitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// NormalizeTags lower-cases and deduplicates tags, keeping their order and
+// dropping empty ones. Characters not allowed by ValidTag are removed, tags
+// longer than MaxTagLength are cut short and only the first MaxTags are kept;
+// forms reject those cases before they get here, so this only bites on
+// migrated data.
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.Map(func(r rune) rune {
+			if !validTagRune(r) {
+				return -1
+			}
+			return unicode.ToLower(r)
+		}, tag)
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			tag = string([]rune(tag)[:MaxTagLength])
+		}
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+		if len(normalized) == MaxTags {
+			break
+		}
+	}
+	return normalized
+}
+
+// TagCount is a tag and the number of live snippets carrying it.
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// TopTags orders counts by most used and then by name, and keeps the first
+// limit of them.
+func TopTags(counts []TagCount, limit int) []TagCount {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
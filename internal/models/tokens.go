@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Scopes of API tokens.
+const (
+	ScopeRead      = "read"
+	ScopeReadWrite = "read-write"
+)
+
+// tokenPrefix marks personal API tokens, which makes them easy to spot in
+// logs and secret scanners.
+const tokenPrefix = "sbx_"
+
+// APIToken is a personal access token for the JSON API. Only the SHA-256
+// hash of the token is stored; the token itself is shown to the user once,
+// when it is created. Tokens are long and random, so unlike passwords they
+// don't need a slow hash.
+type APIToken struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	UserID   primitive.ObjectID `bson:"user_id"`
+	Name     string             `bson:"name"`
+	Scope    string             `bson:"scope"`
+	Hash     string             `bson:"hash"`
+	Created  time.Time          `bson:"created"`
+	LastUsed time.Time          `bson:"last_used,omitempty"`
+}
+
+// CanWrite reports whether the token may be used for requests that change
+// anything.
+func (t APIToken) CanWrite() bool {
+	return t.Scope == ScopeReadWrite
+}
+
+// NewAPIToken generates a token for userID and returns it along with the
+// plaintext, which is not kept anywhere.
+func NewAPIToken(userID primitive.ObjectID, name, scope string) (APIToken, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	plaintext := tokenPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	token := APIToken{
+		ID:      primitive.NewObjectID(),
+		UserID:  userID,
+		Name:    name,
+		Scope:   scope,
+		Hash:    HashToken(plaintext),
+		Created: time.Now().UTC(),
+	}
+	return token, plaintext, nil
+}
+
+// HashToken returns the hex SHA-256 hash tokens are stored and looked up by.
+func HashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *UserModel) AddToken(token APIToken) error {
+	_, err := m.DB.Collection("api_tokens").InsertOne(context.TODO(), token)
+	return err
+}
+
+// Tokens returns the API tokens of a user, newest first.
+func (m *UserModel) Tokens(userID primitive.ObjectID) ([]APIToken, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cur, err := m.DB.Collection("api_tokens").Find(context.TODO(), bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	tokens := []APIToken{}
+	err = cur.All(context.TODO(), &tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes a token, returning ErrNoRecord unless it belongs to
+// userID.
+func (m *UserModel) RevokeToken(userID, tokenID primitive.ObjectID) error {
+	result, err := m.DB.Collection("api_tokens").DeleteOne(context.TODO(), bson.M{"_id": tokenID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// AuthenticateToken looks up a plaintext token and returns it along with
+// the name of its user, recording the time it was used. Unknown tokens give
+// ErrInvalidCredentials.
+func (m *UserModel) AuthenticateToken(plaintext string) (APIToken, string, error) {
+	var token APIToken
+	err := m.DB.Collection("api_tokens").FindOneAndUpdate(context.TODO(),
+		bson.M{"hash": HashToken(plaintext)},
+		bson.M{"$set": bson.M{"last_used": time.Now().UTC()}},
+	).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return APIToken{}, "", ErrInvalidCredentials
+		}
+		return APIToken{}, "", err
+	}
+	var user User
+	err = m.DB.Collection("users").FindOne(context.TODO(), bson.M{"_id": token.UserID}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return APIToken{}, "", ErrInvalidCredentials
+		}
+		return APIToken{}, "", err
+	}
+	return token, user.Name, nil
+}
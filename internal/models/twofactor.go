@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecoveryCodeCount is how many recovery codes a user is given when they
+// enable two-factor authentication.
+const RecoveryCodeCount = 10
+
+// NewRecoveryCodes returns RecoveryCodeCount random recovery codes, which
+// are shown to the user once, and their hashes, which are stored. Like API
+// tokens they are random enough not to need a slow hash.
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash of a recovery code as typed by the user,
+// who may leave out the dash or use upper case.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return HashToken(code)
+}
+
+// EnableTOTP turns on two-factor authentication for the user with the given
+// authenticator secret and recovery code hashes.
+func (m *UserModel) EnableTOTP(id primitive.ObjectID, secret string, recoveryCodes []string) error {
+	return m.updateUser(id, bson.M{"$set": bson.M{
+		"totp_secret":    secret,
+		"totp_step":      int64(0),
+		"recovery_codes": recoveryCodes,
+	}})
+}
+
+func (m *UserModel) DisableTOTP(id primitive.ObjectID) error {
+	return m.updateUser(id, bson.M{"$set": bson.M{
+		"totp_secret":    "",
+		"totp_step":      int64(0),
+		"recovery_codes": []string{},
+	}})
+}
+
+// UseTOTPStep records that a code for the time step was accepted. It
+// returns ErrCodeUsed if one for that step or a later one already was, so
+// that a code can't be replayed.
+func (m *UserModel) UseTOTPStep(id primitive.ObjectID, step int64) error {
+	filter := bson.M{"_id": id, "totp_step": bson.M{"$lt": step}}
+	result, err := m.DB.Collection("users").UpdateOne(context.TODO(), filter, bson.M{"$set": bson.M{"totp_step": step}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrCodeUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode spends the recovery code with the hash, returning
+// ErrNoRecord if the user has no such code left.
+func (m *UserModel) UseRecoveryCode(id primitive.ObjectID, hash string) error {
+	filter := bson.M{"_id": id, "recovery_codes": hash}
+	result, err := m.DB.Collection("users").UpdateOne(context.TODO(), filter, bson.M{"$pull": bson.M{"recovery_codes": hash}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// SetRecoveryCodes replaces the recovery codes of the user.
+func (m *UserModel) SetRecoveryCodes(id primitive.ObjectID, recoveryCodes []string) error {
+	return m.updateUser(id, bson.M{"$set": bson.M{"recovery_codes": recoveryCodes}})
+}
+
+func (m *UserModel) updateUser(id primitive.ObjectID, update bson.M) error {
+	result, err := m.DB.Collection("users").UpdateOne(context.TODO(), bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -9,29 +9,62 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
-type UserModelInterface interface {
+type UserStore interface {
 	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
-	Get(id int) (User, error)
+	Authenticate(email, password string) (primitive.ObjectID, string, error)
+	Exists(id primitive.ObjectID) (bool, error)
+	GetByID(id primitive.ObjectID) (User, error)
+	GetByEmail(email string) (User, error)
+	SetEmailVerified(id primitive.ObjectID, email string) error
+	SetPassword(id primitive.ObjectID, password string) error
+	SetName(id primitive.ObjectID, name string) error
+	SetEmail(id primitive.ObjectID, email string) error
+	Delete(id primitive.ObjectID, keepContent bool) error
+	EnableTOTP(id primitive.ObjectID, secret string, recoveryCodes []string) error
+	DisableTOTP(id primitive.ObjectID) error
+	UseTOTPStep(id primitive.ObjectID, step int64) error
+	UseRecoveryCode(id primitive.ObjectID, hash string) error
+	SetRecoveryCodes(id primitive.ObjectID, recoveryCodes []string) error
+	Get(id primitive.ObjectID, viewerIDStr string, created PageQuery) (User, error)
+	AddFavourites(SnippetID primitive.ObjectID, ID primitive.ObjectID) error
+	RemoveFavourites(Snippet Snippet, SnippetID primitive.ObjectID, ID primitive.ObjectID) error
+	AddToken(token APIToken) error
+	Tokens(userID primitive.ObjectID) ([]APIToken, error)
+	RevokeToken(userID, tokenID primitive.ObjectID) error
+	AuthenticateToken(plaintext string) (APIToken, string, error)
+	AddAuditEntry(entry AuditEntry) error
+	AuditEntries(userID primitive.ObjectID, limit int) ([]AuditEntry, error)
 }
 
 type User struct {
-	IDStr           string             `bson:"idstr"`
-	ID              primitive.ObjectID `bson:"_id,omitempty"`
-	Name            string             `bson:"name"`
-	Email           string             `bson:"email"`
-	HashedPassword  string             `bson:"hashed_password"`
-	Created         time.Time          `bson:"created"`
-	Favourites      []Snippet          `bson:"favourites"`
-	CreatedSnippets []Snippet          `bson:"created_snippets"`
+	IDStr          string             `bson:"idstr"`
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	Name           string             `bson:"name"`
+	Email          string             `bson:"email"`
+	HashedPassword string             `bson:"hashed_password"`
+	EmailVerified  bool               `bson:"email_verified"`
+	// TOTPSecret is the secret of the user's authenticator app, empty
+	// unless they enabled two-factor authentication. TOTPStep is the last
+	// time step a code was accepted for, and RecoveryCodes the hashes of
+	// the recovery codes they have left.
+	TOTPSecret      string    `bson:"totp_secret"`
+	TOTPStep        int64     `bson:"totp_step"`
+	RecoveryCodes   []string  `bson:"recovery_codes"`
+	Created         time.Time `bson:"created"`
+	Favourites      []Snippet `bson:"favourites"`
+	CreatedSnippets []Snippet `bson:"created_snippets"`
+	// CreatedNext and CreatedPrev are the cursors around the page of
+	// CreatedSnippets filled in by Get.
+	CreatedNext string `bson:"-"`
+	CreatedPrev string `bson:"-"`
 }
 
 type UserModel struct {
-	Client *mongo.Client
+	DB *mongo.Database
 }
 
 func (m *UserModel) Insert(name, email, password string) error {
@@ -40,11 +73,12 @@ func (m *UserModel) Insert(name, email, password string) error {
 		return err
 	}
 
-	collection := m.Client.Database("snippetbox").Collection("users")
+	collection := m.DB.Collection("users")
 	user := bson.M{
 		"name":             name,
 		"email":            email,
 		"hashed_password":  hashedPassword,
+		"email_verified":   false,
 		"created":          time.Now().UTC(),
 		"favourites":       []Snippet{},
 		"created_snippets": []Snippet{},
@@ -69,11 +103,12 @@ func (m *UserModel) Insert(name, email, password string) error {
 func (m *UserModel) Authenticate(email, password string) (primitive.ObjectID, string, error) {
 	var user User
 
-	collection := m.Client.Database("snippetbox").Collection("users")
+	collection := m.DB.Collection("users")
 	filter := bson.M{"email": email}
 	err := collection.FindOne(context.TODO(), filter).Decode(&user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
+			CompareDummyPassword(password)
 			return primitive.NilObjectID, "", ErrInvalidCredentials
 		}
 		return primitive.NilObjectID, "", err
@@ -91,7 +126,7 @@ func (m *UserModel) Authenticate(email, password string) (primitive.ObjectID, st
 }
 
 func (m *UserModel) Exists(id primitive.ObjectID) (bool, error) {
-	collection := m.Client.Database("snippetbox").Collection("users")
+	collection := m.DB.Collection("users")
 	filter := bson.M{"_id": id}
 	count, err := collection.CountDocuments(context.TODO(), filter)
 	if err != nil {
@@ -100,44 +135,88 @@ func (m *UserModel) Exists(id primitive.ObjectID) (bool, error) {
 	return count > 0, nil
 }
 
-func (m *UserModel) Get(id primitive.ObjectID) (User, error) {
+// GetByID returns the user without their snippets and favourites.
+func (m *UserModel) GetByID(id primitive.ObjectID) (User, error) {
+	return m.getOne(bson.M{"_id": id})
+}
+
+func (m *UserModel) GetByEmail(email string) (User, error) {
+	return m.getOne(bson.M{"email": email})
+}
+
+func (m *UserModel) getOne(filter bson.M) (User, error) {
 	var user User
-	collection := m.Client.Database("snippetbox").Collection("users")
-	filter := bson.M{"_id": id}
-	err := collection.FindOne(context.TODO(), filter).Decode(&user)
+	opts := options.FindOne().SetProjection(bson.M{"favourites": 0, "created_snippets": 0})
+	err := m.DB.Collection("users").FindOne(context.TODO(), filter, opts).Decode(&user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return User{}, ErrNoRecord
 		}
 		return User{}, err
 	}
-	collection = m.Client.Database("snippetbox").Collection("snippets")
-	username := user.Name
-	filter = bson.M{"Author." + username: user.IDStr}
-	var snippets []Snippet
-	cur, err := collection.Find(context.TODO(), filter)
+	return user, nil
+}
+
+// SetEmailVerified marks email as verified for the user, provided it is
+// still their address; ErrNoRecord is returned otherwise.
+func (m *UserModel) SetEmailVerified(id primitive.ObjectID, email string) error {
+	filter := bson.M{"_id": id, "email": email}
+	result, err := m.DB.Collection("users").UpdateOne(context.TODO(), filter, bson.M{"$set": bson.M{"email_verified": true}})
 	if err != nil {
-		return User{}, err
+		return err
 	}
-	defer cur.Close(context.TODO())
+	if result.MatchedCount == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
 
-	for cur.Next(context.TODO()) {
-		var snippet Snippet
-		err := cur.Decode(&snippet)
-		if err != nil {
-			return User{}, err
-		}
-		snippets = append(snippets, snippet)
+func (m *UserModel) SetPassword(id primitive.ObjectID, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+	result, err := m.DB.Collection("users").UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": bson.M{"hashed_password": string(hashedPassword)}})
+	if err != nil {
+		return err
 	}
+	if result.MatchedCount == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
 
-	if err := cur.Err(); err != nil {
+// Get returns the user with a page of the snippets they wrote and their
+// favourites. A user viewing their own account sees every snippet they may;
+// others only see the public ones.
+func (m *UserModel) Get(id primitive.ObjectID, viewerIDStr string, created PageQuery) (User, error) {
+	var user User
+	collection := m.DB.Collection("users")
+	filter := bson.M{"_id": id}
+	err := collection.FindOne(context.TODO(), filter).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return User{}, ErrNoRecord
+		}
+		return User{}, err
+	}
+	filter = writtenBy(user.IDStr)
+	filter["$and"] = bson.A{notExpired()}
+	if viewerIDStr != user.IDStr {
+		filter["$and"] = bson.A{notExpired(), listed()}
+	}
+	page, err := pageSnippets(m.DB.Collection("snippets"), filter, created)
+	if err != nil {
 		return User{}, err
 	}
-	user.CreatedSnippets = snippets
+	user.CreatedSnippets = page.Snippets
+	user.CreatedNext = page.Next
+	user.CreatedPrev = page.Prev
+	user.Favourites = visibleFavourites(withoutExpired(user.Favourites), user.IDStr, viewerIDStr)
 	return user, nil
 }
 func (m *UserModel) AddFavourites(SnippetID primitive.ObjectID, ID primitive.ObjectID) error {
-	collection := m.Client.Database("snippetbox").Collection("users")
+	collection := m.DB.Collection("users")
 
 	var user User
 	err := collection.FindOne(context.TODO(), bson.M{"_id": ID, "favourites._id": bson.M{"$in": []primitive.ObjectID{SnippetID}}}).Decode(&user)
@@ -147,22 +226,35 @@ func (m *UserModel) AddFavourites(SnippetID primitive.ObjectID, ID primitive.Obj
 			return err
 		}
 	} else {
-		return errors.New("post is already in favourites")
+		return ErrAlreadyFavourite
 	}
 
-	collection = m.Client.Database("snippetbox").Collection("snippets")
-	filter := bson.M{"_id": SnippetID}
+	collection = m.DB.Collection("snippets")
+	filter := bson.M{"_id": SnippetID, "$and": bson.A{notExpired()}}
 	var Snippet Snippet
-	_, err = collection.UpdateOne(context.TODO(), filter, bson.M{"$inc": bson.M{"favourited": 1}})
+	err = collection.FindOne(context.TODO(), filter).Decode(&Snippet)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNoRecord
+		}
 		return err
 	}
+	if !Snippet.VisibleTo(ID.Hex()) {
+		return ErrNoRecord
+	}
+	result, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$inc": bson.M{"favourited": 1}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNoRecord
+	}
 	err = collection.FindOne(context.TODO(), filter).Decode(&Snippet)
 	if err != nil {
 		return err
 	}
 
-	collection = m.Client.Database("snippetbox").Collection("users")
+	collection = m.DB.Collection("users")
 	_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": ID}, bson.M{"$push": bson.M{"favourites": Snippet}})
 	if err != nil {
 		return err
@@ -171,16 +263,42 @@ func (m *UserModel) AddFavourites(SnippetID primitive.ObjectID, ID primitive.Obj
 	return nil
 }
 func (m *UserModel) RemoveFavourites(Snippet Snippet, SnippetID primitive.ObjectID, ID primitive.ObjectID) error {
-	collection := m.Client.Database("snippetbox").Collection("snippets")
-	filter := bson.M{"_id": SnippetID}
-	_, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$inc": bson.M{"favourited": -1}})
+	collection := m.DB.Collection("users")
+	result, err := collection.UpdateOne(context.TODO(), bson.M{"_id": ID}, bson.M{"$pull": bson.M{"favourites": bson.M{"_id": SnippetID}}})
 	if err != nil {
 		return err
 	}
-	collection = m.Client.Database("snippetbox").Collection("users")
-	_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": ID}, bson.M{"$pull": bson.M{"favourites": Snippet}})
-	if err != nil {
-		return err
+	// Only a favourite that was there counts, so unfavouriting twice
+	// doesn't push the counter below zero.
+	if result.ModifiedCount == 0 {
+		return nil
 	}
-	return nil
+	collection = m.DB.Collection("snippets")
+	_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": SnippetID}, bson.M{"$inc": bson.M{"favourited": -1}})
+	return err
+}
+
+// withoutExpired filters out snippets that expired but haven't been swept
+// yet.
+func withoutExpired(snippets []Snippet) []Snippet {
+	live := snippets[:0:0]
+	for _, s := range snippets {
+		if !s.IsExpired() {
+			live = append(live, s)
+		}
+	}
+	return live
+}
+
+// visibleFavourites filters the favourites of the user with the hex ID
+// userIDStr down to those the viewer may see listed: all the ones still
+// visible to the user on their own account, the public ones elsewhere.
+func visibleFavourites(favourites []Snippet, userIDStr, viewerIDStr string) []Snippet {
+	visible := favourites[:0:0]
+	for _, s := range favourites {
+		if s.IsPublic() || (viewerIDStr == userIDStr && s.VisibleTo(viewerIDStr)) {
+			visible = append(visible, s)
+		}
+	}
+	return visible
 }
@@ -0,0 +1,255 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// leeway is how far the clocks of the provider and the application may
+// disagree when checking the expiry of an ID token.
+const leeway = time.Minute
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type payload struct {
+	Issuer            string    `json:"iss"`
+	Subject           string    `json:"sub"`
+	Audience          audience  `json:"aud"`
+	AuthorizedParty   string    `json:"azp"`
+	Expiry            int64     `json:"exp"`
+	Nonce             string    `json:"nonce"`
+	Email             string    `json:"email"`
+	EmailVerified     looseBool `json:"email_verified"`
+	Name              string    `json:"name"`
+	PreferredUsername string    `json:"preferred_username"`
+}
+
+// audience is the aud claim, which is either a string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// looseBool is a boolean claim that some providers send as a string.
+type looseBool bool
+
+func (l *looseBool) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case "true", `"true"`:
+		*l = true
+	default:
+		*l = false
+	}
+	return nil
+}
+
+// Verify checks that token is an ID token the provider issued to this
+// client for the login with nonce, and unexpired at now, and returns its
+// claims.
+func (p *Provider) Verify(ctx context.Context, token, nonce string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var h header
+	err := decodeSegment(parts[0], &h)
+	if err != nil {
+		return Claims{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	err = p.verifySignature(ctx, h, parts[0]+"."+parts[1], sig)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	var c payload
+	err = decodeSegment(parts[1], &c)
+	if err != nil {
+		return Claims{}, err
+	}
+	switch {
+	case c.Issuer != p.Issuer:
+		return Claims{}, fmt.Errorf("%w: issued by %q", ErrInvalidToken, c.Issuer)
+	case !c.Audience.contains(p.ClientID):
+		return Claims{}, fmt.Errorf("%w: issued to another client", ErrInvalidToken)
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.ClientID:
+		return Claims{}, fmt.Errorf("%w: issued to another client", ErrInvalidToken)
+	case now.After(time.Unix(c.Expiry, 0).Add(leeway)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.Nonce != nonce:
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case c.Subject == "":
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	name := c.Name
+	if name == "" {
+		name = c.PreferredUsername
+	}
+	return Claims{
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: bool(c.EmailVerified),
+		Name:          name,
+	}, nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	return nil
+}
+
+// verifySignature checks sig over signed with the provider key named in h.
+// Only the algorithms providers use in practice, RS256 and ES256, are
+// accepted, which rules out "none" and tokens signed with the key of
+// another algorithm.
+func (p *Provider) verifySignature(ctx context.Context, h header, signed string, sig []byte) error {
+	key, err := p.key(ctx, h.Kid)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(signed))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if h.Alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if h.Alg == "ES256" && len(sig) == 64 {
+			r := new(big.Int).SetBytes(sig[:32])
+			s := new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(key, digest[:], r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+}
+
+// key returns the signing key of the provider with the ID kid, fetching
+// the key set again if it isn't known, since providers rotate keys.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys returns the signing keys of the provider by ID. Keys of types
+// that aren't supported are left out.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := p.getJSON(ctx, p.jwksURL, &set)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := decodeInt(k.N)
+			e, err2 := decodeInt(k.E)
+			if err1 != nil || err2 != nil || !e.IsInt64() {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := decodeInt(k.X)
+			y, err2 := decodeInt(k.Y)
+			if err1 != nil || err2 != nil || !onP256(x, y) {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+// onP256 reports whether (x, y) is a point on the P-256 curve.
+func onP256(x, y *big.Int) bool {
+	if x.BitLen() > 256 || y.BitLen() > 256 {
+		return false
+	}
+	point := make([]byte, 65)
+	point[0] = 4
+	x.FillBytes(point[1:33])
+	y.FillBytes(point[33:])
+	_, err := ecdh.P256().NewPublicKey(point)
+	return err == nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,139 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+	"snippetbox/internal/oidc"
+	"snippetbox/internal/oidc/oidctest"
+)
+
+const redirectURL = "https://snippetbox.test/callback"
+
+var alice = oidctest.User{Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+
+func TestChallenge(t *testing.T) {
+	// Appendix B of RFC 7636.
+	assert.Equal(t, oidc.Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"), "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+}
+
+func newTestProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	stub := oidctest.NewProvider("snippetbox", "s3cret")
+	t.Cleanup(stub.Close)
+	p, err := oidc.Discover(context.Background(), stub.URL, "snippetbox", "s3cret", nil)
+	assert.NilError(t, err)
+	return stub, p
+}
+
+// authorize follows the authorization URL of p and returns the query the
+// provider sends the user back with.
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) url.Values {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(p.AuthCodeURL(redirectURL, state, nonce, verifier))
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusFound)
+	back, err := url.Parse(resp.Header.Get("Location"))
+	assert.NilError(t, err)
+	assert.Equal(t, strings.HasPrefix(back.String(), redirectURL+"?"), true)
+	return back.Query()
+}
+
+func TestLogin(t *testing.T) {
+	stub, p := newTestProvider(t)
+	stub.SetUser(alice)
+	verifier, err := oidc.NewRandom()
+	assert.NilError(t, err)
+
+	back := authorize(t, p, "state", "nonce", verifier)
+	assert.Equal(t, back.Get("state"), "state")
+	claims, err := p.Exchange(context.Background(), back.Get("code"), redirectURL, verifier, "nonce")
+	assert.NilError(t, err)
+	assert.Equal(t, claims, oidc.Claims{Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice"})
+
+	// A code is only good once, and only with its verifier.
+	_, err = p.Exchange(context.Background(), back.Get("code"), redirectURL, verifier, "nonce")
+	assert.Equal(t, errors.Is(err, oidc.ErrExchange), true)
+	back = authorize(t, p, "state", "nonce", verifier)
+	_, err = p.Exchange(context.Background(), back.Get("code"), redirectURL, verifier+"x", "nonce")
+	assert.Equal(t, errors.Is(err, oidc.ErrExchange), true)
+
+	// The nonce ties the ID token to the login it was asked for.
+	back = authorize(t, p, "state", "nonce", verifier)
+	_, err = p.Exchange(context.Background(), back.Get("code"), redirectURL, verifier, "other")
+	assert.Equal(t, errors.Is(err, oidc.ErrInvalidToken), true)
+
+	stub.Deny()
+	back = authorize(t, p, "state", "nonce", verifier)
+	assert.Equal(t, back.Get("error"), "access_denied")
+}
+
+func TestVerify(t *testing.T) {
+	stub, p := newTestProvider(t)
+	now := time.Now()
+	valid := stub.Sign(stub.Claims(alice, "nonce"))
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+		at    time.Time
+		ok    bool
+	}{
+		{"Valid", valid, "nonce", now, true},
+		{"Expired", valid, "nonce", now.Add(10 * time.Minute), false},
+		{"Wrong nonce", valid, "other", now, false},
+		{"Other issuer", signWith(stub, "iss", "https://evil.test"), "nonce", now, false},
+		{"Other client", signWith(stub, "aud", "someone-else"), "nonce", now, false},
+		{"Audience list", signWith(stub, "aud", []string{"someone-else", "snippetbox"}), "nonce", now, false},
+		{"No subject", signWith(stub, "sub", ""), "nonce", now, false},
+		{"Tampered", tamper(valid), "nonce", now, false},
+		{"Unsigned", unsigned(valid), "nonce", now, false},
+		{"Malformed", "not.a.jwt", "nonce", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.Verify(context.Background(), tt.token, tt.nonce, tt.at)
+			if tt.ok {
+				assert.NilError(t, err)
+				assert.Equal(t, claims.Email, alice.Email)
+				return
+			}
+			assert.Equal(t, errors.Is(err, oidc.ErrInvalidToken), true)
+		})
+	}
+}
+
+// signWith returns a token for alice with the claim key set to value.
+func signWith(stub *oidctest.Provider, key string, value any) string {
+	claims := stub.Claims(alice, "nonce")
+	claims[key] = value
+	return stub.Sign(claims)
+}
+
+// tamper makes alice's token claim another email address, keeping the
+// signature.
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	payload = []byte(strings.Replace(string(payload), "alice@", "mallory@", 1))
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
+
+// unsigned turns token into one with the "none" algorithm.
+func unsigned(token string) string {
+	parts := strings.Split(token, ".")
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"test-key"}`))
+	return header + "." + parts[1] + "."
+}
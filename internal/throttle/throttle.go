@@ -0,0 +1,120 @@
+// Package throttle slows down repeated failures, such as wrong passwords,
+// per key: after a few free failures each one doubles the time before the
+// next attempt is allowed, and enough of them in a row lock the key out for
+// a while. State is kept in memory, so it is per process and forgotten on
+// restart.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+type Limiter struct {
+	// Free is how many failures are allowed before backing off starts.
+	Free int
+	// Base is the wait after the first failure past Free, doubled for
+	// each one after that up to Max.
+	Base time.Duration
+	Max  time.Duration
+	// LockAfter failures in a row lock the key out for LockFor. Zero
+	// disables lockouts.
+	LockAfter int
+	LockFor   time.Duration
+	// Forget is how long after its last failure a key starts afresh.
+	Forget time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+type entry struct {
+	failures int
+	last     time.Time
+	next     time.Time
+}
+
+func (l *Limiter) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// get returns the entry for key, starting afresh if it was forgotten. The
+// caller must hold the lock.
+func (l *Limiter) get(key string, now time.Time) *entry {
+	if l.entries == nil {
+		l.entries = map[string]*entry{}
+	}
+	e, ok := l.entries[key]
+	if !ok || l.forgotten(e, now) {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	return e
+}
+
+func (l *Limiter) forgotten(e *entry, now time.Time) bool {
+	return now.After(e.next) && now.Sub(e.last) > l.Forget
+}
+
+// Wait returns how long until key may make another attempt, zero if it
+// may now.
+func (l *Limiter) Wait(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock()
+	e, ok := l.entries[key]
+	if !ok || !e.next.After(now) {
+		return 0
+	}
+	return e.next.Sub(now)
+}
+
+// Fail records a failure for key and reports whether it locked the key
+// out.
+func (l *Limiter) Fail(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock()
+	e := l.get(key, now)
+	e.failures++
+	e.last = now
+	if l.LockAfter > 0 && e.failures >= l.LockAfter {
+		// The lockout takes the place of the backoff, which starts over
+		// once it is served.
+		e.failures = 0
+		e.next = now.Add(l.LockFor)
+		return true
+	}
+	if e.failures > l.Free {
+		wait := l.Base << (e.failures - l.Free - 1)
+		if wait > l.Max || wait <= 0 {
+			wait = l.Max
+		}
+		e.next = now.Add(wait)
+	}
+	return false
+}
+
+// Reset forgets the failures of key, after a success.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// Sweep drops the keys that have been forgotten, so that the memory used
+// doesn't grow with every key ever seen.
+func (l *Limiter) Sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock()
+	for key, e := range l.entries {
+		if l.forgotten(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestLimiter(c *clock) *Limiter {
+	return &Limiter{
+		Free:      2,
+		Base:      time.Second,
+		Max:       5 * time.Second,
+		LockAfter: 6,
+		LockFor:   time.Minute,
+		Forget:    time.Hour,
+		now:       c.now,
+	}
+}
+
+func TestLimiterBackoff(t *testing.T) {
+	c := &clock{t: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l := newTestLimiter(c)
+
+	// The free failures cost nothing, then the wait doubles up to Max.
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second} {
+		assert.Equal(t, l.Fail("alice"), false)
+		assert.Equal(t, l.Wait("alice"), want)
+		if i == 2 {
+			c.t = c.t.Add(500 * time.Millisecond)
+			assert.Equal(t, l.Wait("alice"), 500*time.Millisecond)
+			c.t = c.t.Add(500 * time.Millisecond)
+			assert.Equal(t, l.Wait("alice"), time.Duration(0))
+		}
+	}
+	assert.Equal(t, l.Wait("bob"), time.Duration(0))
+
+	assert.Equal(t, l.Fail("alice"), true)
+	assert.Equal(t, l.Wait("alice"), time.Minute)
+	c.t = c.t.Add(time.Minute)
+	assert.Equal(t, l.Wait("alice"), time.Duration(0))
+
+	// After a lockout the backoff starts over.
+	assert.Equal(t, l.Fail("alice"), false)
+	assert.Equal(t, l.Wait("alice"), time.Duration(0))
+
+	l.Reset("alice")
+	assert.Equal(t, l.Wait("alice"), time.Duration(0))
+}
+
+func TestLimiterMax(t *testing.T) {
+	c := &clock{t: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l := newTestLimiter(c)
+	l.LockAfter = 0
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, l.Fail("alice"), false)
+	}
+	assert.Equal(t, l.Wait("alice"), 5*time.Second)
+}
+
+func TestLimiterForget(t *testing.T) {
+	c := &clock{t: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l := newTestLimiter(c)
+	for i := 0; i < 5; i++ {
+		l.Fail("alice")
+	}
+	l.Fail("bob")
+
+	c.t = c.t.Add(30 * time.Minute)
+	l.Fail("bob")
+	c.t = c.t.Add(31 * time.Minute)
+	l.Sweep()
+	assert.Equal(t, len(l.entries), 1)
+
+	// A forgotten key starts with its free failures again.
+	assert.Equal(t, l.Fail("alice"), false)
+	assert.Equal(t, l.Wait("alice"), time.Duration(0))
+}
@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox/internal/assert"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, base32 encoded.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTPVectors(t *testing.T) {
+	// Appendix B of RFC 6238, SHA1 rows.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	key, err := decode(rfcSecret)
+	assert.NilError(t, err)
+	for _, tt := range tests {
+		got := hotp(key, uint64(Step(time.Unix(tt.unix, 0))), 8)
+		assert.Equal(t, got, tt.want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, now)
+	assert.NilError(t, err)
+	assert.Equal(t, code, "050471")
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		want   bool
+	}{
+		{"Current step", rfcSecret, code, now, true},
+		{"Previous step", rfcSecret, code, now.Add(Period * time.Second), true},
+		{"Next step", rfcSecret, code, now.Add(-Period * time.Second), true},
+		{"Too old", rfcSecret, code, now.Add(2 * Period * time.Second), false},
+		{"Wrong code", rfcSecret, "123456", now, false},
+		{"Too short", rfcSecret, code[:5], now, false},
+		{"Lower case secret", strings.ToLower(rfcSecret), code, now, true},
+		{"Bad secret", "not base32!", code, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, tt.at)
+			assert.Equal(t, ok, tt.want)
+			if ok {
+				assert.Equal(t, step, Step(now))
+			}
+		})
+	}
+}
+
+func TestNewSecretAndURI(t *testing.T) {
+	secret, err := NewSecret()
+	assert.NilError(t, err)
+	assert.Equal(t, len(secret), 32)
+	_, err = Code(secret, time.Now())
+	assert.NilError(t, err)
+
+	uri := URI("JBSWY3DPEHPK3PXP", "Snippetbox", "alice@example.com")
+	assert.Equal(t, uri, "otpauth://totp/Snippetbox:alice@example.com?algorithm=SHA1&digits=6&issuer=Snippetbox&period=30&secret=JBSWY3DPEHPK3PXP")
+}